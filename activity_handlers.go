@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// getActivities serves the activity feed of the project, the latest first,
+// filtered by actorId, objectType and the since/until dates when set.
+func (app *App) getActivities(w http.ResponseWriter, r *http.Request) {
+	count, _ := strconv.Atoi(r.FormValue("count"))
+	start, _ := strconv.Atoi(r.FormValue("start"))
+	projectId := r.FormValue("projectId")
+
+	if count > 50 || count < 1 {
+		count = 50
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	if !authorizeReference(w, r, projectId, PERMISSION_VIEW) {
+		return
+	}
+
+	filter := ActivityFilter{
+		ActorID:    r.FormValue("actorId"),
+		ObjectType: r.FormValue("objectType"),
+	}
+	if len(filter.ObjectType) > 0 && !isValidActivityObjectType(filter.ObjectType) {
+		respondError(w, http.StatusBadRequest, "invalid-object-type")
+		return
+	}
+	var err error
+	if filter.Since, err = parseActivityDate(r.FormValue("since")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-date")
+		return
+	}
+	if filter.Until, err = parseActivityDate(r.FormValue("until")); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-date")
+		return
+	}
+
+	activities, err := getActivities(start, count, projectId, filter)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, Activities{Data: activities})
+}
+
+// parseActivityDate accepts RFC 3339 timestamps or plain dates, nil when
+// not set.
+func parseActivityDate(value string) (*time.Time, error) {
+	if len(value) < 1 {
+		return nil, nil
+	}
+	date, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		date, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	date = date.UTC()
+	return &date, nil
+}
+
+// recordActivity logs the change made by the current user to the feed of
+// the project, or by the admin impersonating them. The change is done
+// already, failures are only logged.
+func recordActivity(r *http.Request, projectID, objectType, objectID, action, description string) {
+	actor := r.Context().Value("currentUser").(*User)
+	if impersonator, ok := r.Context().Value("impersonator").(*User); ok {
+		actor = impersonator
+	}
+	a := Activity{
+		ProjectID:   projectID,
+		ActorID:     actor.ID,
+		ObjectType:  objectType,
+		ObjectID:    objectID,
+		Action:      action,
+		Description: description,
+	}
+	if err := a.recordActivity(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Objects of the activities
+const (
+	ACTIVITY_SCOPE        = "scope"
+	ACTIVITY_SCENARIO     = "scenario"
+	ACTIVITY_SESSION      = "session"
+	ACTIVITY_TEST         = "test"
+	ACTIVITY_COLLABORATOR = "collaborator"
+)
+
+var ACTIVITY_OBJECT_TYPES = [...]string{
+	ACTIVITY_SCOPE,
+	ACTIVITY_SCENARIO,
+	ACTIVITY_SESSION,
+	ACTIVITY_TEST,
+	ACTIVITY_COLLABORATOR,
+}
+
+// Actions of the activities
+const (
+	ACTIVITY_CREATED = "created"
+	ACTIVITY_UPDATED = "updated"
+	ACTIVITY_DELETED = "deleted"
+	ACTIVITY_OPENED  = "opened"
+	ACTIVITY_CLOSED  = "closed"
+	ACTIVITY_CLAIMED = "claimed"
+	ACTIVITY_PASSED  = "passed"
+	ACTIVITY_FAILED  = "failed"
+	ACTIVITY_JOINED  = "joined"
+	ACTIVITY_REVOKED = "revoked"
+)
+
+// Activity is an entry of the append-only log of a project. The
+// description is a compact summary of the change, such as the name of the
+// object.
+type Activity struct {
+	ID          string `json:"id"`
+	ProjectID   string `json:"projectId"`
+	ActorID     string `json:"actorId"`
+	ActorEmail  string `json:"actorEmail"`
+	ObjectType  string `json:"objectType"`
+	ObjectID    string `json:"objectId"`
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"createdAt"`
+}
+
+type Activities struct {
+	Data []Activity `json:"data"`
+}
+
+// ActivityFilter narrows the feed down, empty fields match everything.
+type ActivityFilter struct {
+	ActorID    string
+	ObjectType string
+	Since      *time.Time
+	Until      *time.Time
+}
+
+func isValidActivityObjectType(objectType string) bool {
+	for _, t := range ACTIVITY_OBJECT_TYPES {
+		if t == objectType {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Activity) recordActivity() error {
+	return app.DB.QueryRow(`
+	INSERT INTO project_activities (project_id, actor_id, object_type, object_id, action, description)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at::text
+	`,
+		a.ProjectID,
+		a.ActorID,
+		a.ObjectType,
+		a.ObjectID,
+		a.Action,
+		a.Description,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+// getActivities lists the activities of the project, the latest first.
+func getActivities(start, count int, projectID string, filter ActivityFilter) ([]Activity, error) {
+	rows, err := app.DB.Query(`
+	SELECT a.id, a.project_id, a.actor_id, COALESCE(users.email_address, ''),
+	a.object_type, a.object_id, a.action, a.description, a.created_at::text
+	FROM project_activities a
+	LEFT JOIN users ON users.id::text=a.actor_id
+	WHERE a.project_id=$3
+	AND ($4='' OR a.actor_id=$4)
+	AND ($5='' OR a.object_type=$5)
+	AND ($6::timestamp IS NULL OR a.created_at>=$6)
+	AND ($7::timestamp IS NULL OR a.created_at<$7)
+	ORDER BY a.created_at DESC, a.id
+	LIMIT $1 OFFSET $2
+	`,
+		count, start, projectID, filter.ActorID, filter.ObjectType, filter.Since, filter.Until)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []Activity{}
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(
+			&a.ID,
+			&a.ProjectID,
+			&a.ActorID,
+			&a.ActorEmail,
+			&a.ObjectType,
+			&a.ObjectID,
+			&a.Action,
+			&a.Description,
+			&a.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+
+	return activities, rows.Err()
+}
+
+// describeChanges summarizes an update as the name of the object and the
+// fields changed, "Login (name, steps)".
+func describeChanges(name string, changed ...string) string {
+	if len(changed) < 1 {
+		return name
+	}
+	return name + " (" + strings.Join(changed, ", ") + ")"
+}
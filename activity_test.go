@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getTestActivities(t *testing.T, query string) Activities {
+	req, _ := http.NewRequest("GET", "/api/activities?"+query, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var activities Activities
+	json.Unmarshal(response.Body.Bytes(), &activities)
+	return activities
+}
+
+func TestProjectActivities(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	scope := createTestObject(t, testUserToken1, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	scenario := createTestObject(t, testUserToken1, "/api/scenario",
+		`{"name":"test scenario","projectId":"`+projectId+`","scopeId":"`+scopeId+`","steps":[]}`)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+
+	req, _ := http.NewRequest("PUT", "/api/scenario/"+scenarioId,
+		bytes.NewBuffer([]byte(`{"name":"login","scopeId":"`+scopeId+`","steps":[]}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	session := createTestObject(t, testUserToken1, "/api/session",
+		`{"version":"1.0","projectId":"`+projectId+`","scenarios":[{"id":"`+scenarioId+`"}]}`)
+	sessionId := fmt.Sprintf("%s", session["id"])
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	test := createTestObject(t, testUserToken2, "/api/test", `{"sessionId":"`+sessionId+`","scenarioId":"`+scenarioId+`"}`)
+	testId := fmt.Sprintf("%s", test["id"])
+	req, _ = http.NewRequest("PUT", "/api/test/"+testId, bytes.NewBuffer([]byte(`{"status":2,"steps":[]}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/session/"+sessionId,
+		bytes.NewBuffer([]byte(`{"version":"1.0","status":1,"scenarios":[{"id":"`+scenarioId+`"}]}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	activities := getTestActivities(t, "projectId="+projectId)
+	assert.Equal(t, 8, len(activities.Data))
+	joined := activities.Data[3]
+	assert.Equal(t, ACTIVITY_COLLABORATOR, joined.ObjectType)
+	assert.Equal(t, ACTIVITY_JOINED, joined.Action)
+	assert.Equal(t, "masepindrayana@gmail.com", joined.ActorEmail)
+
+	req, _ = http.NewRequest("PUT", "/api/revoke/"+projectId+"/"+joined.ActorID, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// The latest first
+	activities = getTestActivities(t, "projectId="+projectId)
+	actions := []string{}
+	for _, a := range activities.Data {
+		actions = append(actions, a.ObjectType+" "+a.Action)
+	}
+	assert.Equal(t, []string{
+		"collaborator revoked",
+		"session closed",
+		"test passed",
+		"test claimed",
+		"collaborator joined",
+		"session opened",
+		"scenario updated",
+		"scenario created",
+		"scope created",
+	}, actions)
+	assert.Equal(t, "login (name)", activities.Data[6].Description)
+	assert.Equal(t, "masepindrayana@gmail.com", activities.Data[0].Description)
+
+	activities = getTestActivities(t, "projectId="+projectId+"&objectType=test")
+	assert.Equal(t, 2, len(activities.Data))
+	activities = getTestActivities(t, "projectId="+projectId+"&actorId="+joined.ActorID)
+	assert.Equal(t, 3, len(activities.Data))
+	activities = getTestActivities(t, "projectId="+projectId+"&count=2&start=1")
+	assert.Equal(t, 2, len(activities.Data))
+	assert.Equal(t, "session", activities.Data[0].ObjectType)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	activities = getTestActivities(t, "projectId="+projectId+"&since="+tomorrow)
+	assert.Equal(t, 0, len(activities.Data))
+	activities = getTestActivities(t, "projectId="+projectId+"&until="+tomorrow)
+	assert.Equal(t, 9, len(activities.Data))
+
+	req, _ = http.NewRequest("GET", "/api/activities?projectId="+projectId+"&objectType=project", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	// The log cannot be rewritten
+	_, err := app.DB.Exec(`UPDATE project_activities SET description=''`)
+	assert.NotEqual(t, nil, err)
+	_, err = app.DB.Exec(`DELETE FROM project_activities`)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestActivityImpersonation(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	var customer, admin map[string]interface{}
+	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &customer)
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &admin)
+
+	impersonation := createTestObject(t, testAdminToken1, "/api/admin/impersonation",
+		`{"userId":"`+fmt.Sprintf("%s", customer["id"])+`","reason":"ticket #44","allowDestructive":true}`)
+
+	// The change is made by the admin, not the customer
+	req, _ = http.NewRequest("POST", "/api/scope", bytes.NewBuffer([]byte(`{"name":"test scope","projectId":"`+projectId+`"}`)))
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", fmt.Sprintf("%s", impersonation["id"]))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	activities := getTestActivities(t, "projectId="+projectId)
+	assert.Equal(t, 1, len(activities.Data))
+	assert.Equal(t, admin["id"], activities.Data[0].ActorID)
+}
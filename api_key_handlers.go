@@ -0,0 +1,110 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+// getApiKeys lists the personal access tokens of the current user, or the
+// API keys of a project with the projectId parameter.
+func (app *App) getApiKeys(w http.ResponseWriter, r *http.Request) {
+	projectId := r.FormValue("projectId")
+	if len(projectId) > 0 {
+		if !authorizeReference(w, r, projectId, PERMISSION_MANAGE_API_KEYS) {
+			return
+		}
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	keys, err := getApiKeys(currentUser.ID, projectId)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, keys)
+}
+
+// createApiKey responds with the key, it can not be retrieved later.
+func (app *App) createApiKey(w http.ResponseWriter, r *http.Request) {
+	var p ApiKey
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if len(p.ProjectID) > 0 {
+		if !authorizeReference(w, r, p.ProjectID, PERMISSION_MANAGE_API_KEYS) {
+			return
+		}
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	p.UserID = currentUser.ID
+
+	if err := p.createApiKey(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-name", "invalid-expiry", "invalid-scope":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+// revokeApiKey revokes a personal access token of the current user, or a
+// key of a project the user manages the keys of.
+func (app *App) revokeApiKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	p := ApiKey{ID: id}
+	if err = p.getApiKey(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if len(p.ProjectID) > 0 {
+		if !authorize(w, r, p.ProjectID, PERMISSION_MANAGE_API_KEYS) {
+			return
+		}
+	} else {
+		currentUser := r.Context().Value("currentUser").(*User)
+		if p.UserID != currentUser.ID && !isAdmin(r) {
+			respondError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+	}
+
+	if err = p.revokeApiKey(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	API_KEY_PREFIX_PERSONAL = "tsp_"
+	API_KEY_PREFIX_PROJECT  = "tsk_"
+)
+
+const (
+	API_KEY_SCOPE_READ_ONLY     = "read-only"
+	API_KEY_SCOPE_RESULTS_WRITE = "results-write"
+	API_KEY_SCOPE_FULL          = "full"
+)
+
+var API_KEY_SCOPES = [...]string{
+	API_KEY_SCOPE_READ_ONLY,
+	API_KEY_SCOPE_RESULTS_WRITE,
+	API_KEY_SCOPE_FULL,
+}
+
+// Writes allowed to the results-write keys, e.g. a CI pipeline running the
+// tests of a session
+var API_KEY_RESULTS_ROUTES = [...]string{
+	"POST /api/session",
+	"PUT /api/session/{id}",
+	"POST /api/test",
+	"PUT /api/test/{id}",
+	"POST /api/blob",
+}
+
+// Routes available to the project keys, the others are not bound to a project
+var API_KEY_PROJECT_ROUTES = [...]string{
+	"/api/project/{id}",
+	"/api/scopes",
+	"/api/scope",
+	"/api/scope/{id}",
+	"/api/scenarios",
+	"/api/scenario",
+	"/api/scenario/{id}",
+	"/api/sessions",
+	"/api/session",
+	"/api/session/{id}",
+	"/api/reset-session/{id}",
+	"/api/test",
+	"/api/test/{id}",
+	"/api/blob",
+	"/api/blob/{id}",
+}
+
+type ApiKey struct {
+	ID            string `json:"id"`
+	UserID        string `json:"userId"`
+	ProjectID     string `json:"projectId"`
+	Name          string `json:"name"`
+	Prefix        string `json:"prefix"`
+	Scope         string `json:"scope"`
+	ExpiresInDays int    `json:"expiresInDays,omitempty"`
+	ExpiresAt     string `json:"expiresAt"`
+	LastUsedAt    string `json:"lastUsedAt"`
+	CreatedAt     string `json:"createdAt"`
+	Key           string `json:"key,omitempty"` // Only returned on creation
+}
+
+func isApiKey(token string) bool {
+	return strings.HasPrefix(token, API_KEY_PREFIX_PERSONAL) ||
+		strings.HasPrefix(token, API_KEY_PREFIX_PROJECT)
+}
+
+func (k *ApiKey) validate() error {
+	if len(k.Name) < 1 {
+		return errors.New("invalid-name")
+	}
+	if k.ExpiresInDays < 0 {
+		return errors.New("invalid-expiry")
+	}
+	for _, scope := range API_KEY_SCOPES {
+		if scope == k.Scope {
+			return nil
+		}
+	}
+	return errors.New("invalid-scope")
+}
+
+// createApiKey generates the key, only its hash is stored.
+func (k *ApiKey) createApiKey() error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return err
+	}
+	k.Key = API_KEY_PREFIX_PERSONAL + secret
+	if len(k.ProjectID) > 0 {
+		k.Key = API_KEY_PREFIX_PROJECT + secret
+	}
+	k.Prefix = k.Key[:12]
+
+	var expiresAt, lastUsedAt sql.NullString
+	err = app.DB.QueryRow(`
+	INSERT INTO api_keys (user_id, project_id, name, prefix, key_hash, scope, expires_at)
+	VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6,
+	CASE WHEN $7 > 0 THEN NOW() + make_interval(days => $7) END)
+	RETURNING id, expires_at, last_used_at, created_at
+	`,
+		k.UserID, k.ProjectID, k.Name, k.Prefix, hashToken(k.Key), k.Scope, k.ExpiresInDays,
+	).Scan(&k.ID, &expiresAt, &lastUsedAt, &k.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	k.ExpiresAt = expiresAt.String
+	k.LastUsedAt = lastUsedAt.String
+	return nil
+}
+
+func (k *ApiKey) getApiKey() error {
+	var projectID, expiresAt, lastUsedAt sql.NullString
+	err := app.DB.QueryRow(`
+	SELECT user_id, project_id, name, prefix, scope, expires_at, last_used_at, created_at
+	FROM api_keys WHERE id::text=$1
+	AND deleted_at IS NULL
+	`,
+		k.ID).Scan(
+		&k.UserID,
+		&projectID,
+		&k.Name,
+		&k.Prefix,
+		&k.Scope,
+		&expiresAt,
+		&lastUsedAt,
+		&k.CreatedAt,
+	)
+	k.ProjectID = projectID.String
+	k.ExpiresAt = expiresAt.String
+	k.LastUsedAt = lastUsedAt.String
+	return err
+}
+
+func (k *ApiKey) revokeApiKey() error {
+	_, err := app.DB.Exec(`
+	UPDATE api_keys SET deleted_at=NOW() WHERE id=$1
+	`, k.ID)
+	return err
+}
+
+// getApiKeys lists the personal tokens of the user, or the keys of the
+// project when projectId is set.
+func getApiKeys(userId, projectId string) ([]ApiKey, error) {
+	rows, err := app.DB.Query(`
+	SELECT id, user_id, COALESCE(project_id, ''), name, prefix, scope,
+	COALESCE(expires_at::text, ''), COALESCE(last_used_at::text, ''), created_at
+	FROM api_keys
+	WHERE deleted_at IS NULL
+	AND ((NULLIF($2, '') IS NULL AND user_id=$1 AND project_id IS NULL) OR project_id=NULLIF($2, ''))
+	ORDER BY created_at DESC
+	`,
+		userId, projectId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []ApiKey{}
+
+	for rows.Next() {
+		var k ApiKey
+		if err := rows.Scan(
+			&k.ID,
+			&k.UserID,
+			&k.ProjectID,
+			&k.Name,
+			&k.Prefix,
+			&k.Scope,
+			&k.ExpiresAt,
+			&k.LastUsedAt,
+			&k.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// authenticateApiKey returns the user acting with the key. Project keys act
+// as the user who created them, restricted to the project. The last use is
+// recorded at most once a minute.
+func authenticateApiKey(key string) (*User, *ApiKey, error) {
+	k := ApiKey{}
+	var projectID sql.NullString
+	var isExpired bool
+	err := app.DB.QueryRow(`
+	UPDATE api_keys SET last_used_at=CASE
+		WHEN last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute' THEN NOW()
+		ELSE last_used_at
+	END
+	WHERE key_hash=$1 AND deleted_at IS NULL
+	RETURNING id, user_id, project_id, scope, COALESCE(expires_at <= NOW(), false)
+	`,
+		hashToken(key)).Scan(&k.ID, &k.UserID, &projectID, &k.Scope, &isExpired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, errors.New("invalid-api-key")
+		}
+		return nil, nil, err
+	}
+	k.ProjectID = projectID.String
+	if isExpired {
+		return nil, nil, errors.New("expired-api-key")
+	}
+
+	u := User{ID: k.UserID}
+	if err = u.getUser(); err != nil {
+		return nil, nil, err
+	}
+	quotas, err := getUserQuotas(u.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	u.Quotas = quotas
+
+	return &u, &k, nil
+}
+
+// allows checks the route of the request against the scope of the key. Keys
+// cannot manage keys.
+func (k *ApiKey) allows(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil || strings.HasPrefix(template, "/api/api-key") {
+		return false
+	}
+
+	if len(k.ProjectID) > 0 {
+		isProjectRoute := false
+		for _, t := range API_KEY_PROJECT_ROUTES {
+			if t == template {
+				isProjectRoute = true
+				break
+			}
+		}
+		if !isProjectRoute {
+			return false
+		}
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	switch k.Scope {
+	case API_KEY_SCOPE_FULL:
+		return true
+	case API_KEY_SCOPE_RESULTS_WRITE:
+		for _, t := range API_KEY_RESULTS_ROUTES {
+			if t == r.Method+" "+template {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getRequestApiKey(r *http.Request) *ApiKey {
+	k, _ := r.Context().Value("apiKey").(*ApiKey)
+	return k
+}
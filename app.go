@@ -1,7 +1,6 @@
 package main
 
 import (
-	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -10,30 +9,28 @@ import (
 	"strconv"
 	"time"
 
-	firebase "firebase.google.com/go"
-	"firebase.google.com/go/auth"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/gorilla/mux"
 	"github.com/growthbook/growthbook-golang"
 	_ "github.com/lib/pq"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/posthog/posthog-go"
 	"github.com/xendit/xendit-go/client"
-	"google.golang.org/api/option"
 )
 
 type App struct {
-	Router     *mux.Router
-	DB         *sql.DB
-	Firebase   *auth.Client
-	Xendit     *client.API
-	Storage    *Minio
-	GBFeatures growthbook.FeatureMap
-	Posthog    posthog.Client
+	Router *mux.Router
+	DB     *sql.DB
+	// Identity providers, see AUTH_PROVIDERS
+	Authenticators []Authenticator
+	Xendit         *client.API
+	Storage        Storage
+	// Nil when RATE_LIMIT_STORE is off
+	RateLimiter *RateLimiter
+	GBFeatures  growthbook.FeatureMap
+	Posthog     posthog.Client
 }
 
 func (app *App) Init() {
@@ -61,57 +58,21 @@ func (app *App) Init() {
 	}
 
 	// File storage
-	s3Endpoint := os.Getenv("S3_URL")
-	s3AccessKey := os.Getenv("S3_ACCESS_KEY")
-	s3SecretKey := os.Getenv("S3_SECRET_KEY")
-	useSSL := false
-
-	app.Storage = &Minio{
-		bucketName:     "default-default",
-		bucketLocation: "us-east-1",
-	}
-
-	cred := credentials.NewStaticV4(s3AccessKey, s3SecretKey, "")
-	// Initialize minio client object.
-	app.Storage.client, err = minio.New(s3Endpoint, &minio.Options{
-		Creds:  cred,
-		Secure: useSSL,
-	})
+	app.Storage, err = newStorage()
 	if err != nil {
-		log.Println(s3Endpoint)
 		log.Println(err)
 		log.Fatal(err)
 	}
 
-	ctx, delay := context.WithTimeout(context.Background(), 5*time.Second)
-	defer delay()
-	err = app.Storage.client.MakeBucket(ctx, app.Storage.bucketName, minio.MakeBucketOptions{
-		Region: app.Storage.bucketLocation,
-	})
-	// Prepare other buckets
-	err = app.Storage.client.MakeBucket(ctx, PUBLIC_BUCKET, minio.MakeBucketOptions{
-		Region: app.Storage.bucketLocation,
-	})
-	if err != nil {
-		exists, errBucketExists := app.Storage.client.BucketExists(ctx, PUBLIC_BUCKET)
-		if errBucketExists == nil && exists {
-			// ignore
-		} else {
-			log.Println(errBucketExists)
-			log.Fatal(errBucketExists)
-		}
-	}
-
-	// Firebase connection
-	opt := option.WithCredentialsFile(os.Getenv("FIREBASE_ACCOUNT_KEY_PATH"))
-	config := &firebase.Config{ProjectID: os.Getenv("FIREBASE_PROJECT_ID")}
-
-	firebaseApp, err := firebase.NewApp(context.Background(), config, opt)
+	// Identity providers
+	app.Authenticators, err = newAuthenticators()
 	if err != nil {
 		log.Println(err)
 		log.Fatal(err)
 	}
-	app.Firebase, err = firebaseApp.Auth(context.Background())
+
+	// Rate limiting
+	app.RateLimiter, err = newRateLimiter()
 	if err != nil {
 		log.Println(err)
 		log.Fatal(err)
@@ -133,6 +94,17 @@ func (app *App) Init() {
 		}
 	}()
 
+	// Blob garbage collector
+	go app.runBlobGCLoop()
+
+	// Expired tokens
+	go app.runTokenPurgeLoop()
+
+	// Idle rate limit buckets
+	if app.RateLimiter != nil {
+		go app.RateLimiter.runPurgeLoop()
+	}
+
 	// Posthog
 	/*
 		posthogApiKey := os.Getenv("POSTHOG_API_KEY")
@@ -157,6 +129,9 @@ func (app *App) Init() {
 
 	// Midlewares
 	app.Router.Use(Middleware)
+	if app.RateLimiter != nil {
+		app.Router.Use(app.RateLimiter.RateLimitMiddleware)
+	}
 
 	app.initRoutes()
 }
@@ -220,10 +195,63 @@ func (app *App) initRoutes() {
 	app.Router.HandleFunc("/api/project/{id}", app.getProject).Methods("GET")
 	app.Router.HandleFunc("/api/project/{id}", app.updateProject).Methods("PUT")
 	app.Router.HandleFunc("/api/project/{id}", app.deleteProject).Methods("DELETE")
+	app.Router.HandleFunc("/api/project-archive/{id}", app.archiveProject).Methods("PUT")
+	app.Router.HandleFunc("/api/project-archive/{id}", app.unarchiveProject).Methods("DELETE")
+	app.Router.HandleFunc("/api/recycle-bin/{id}", app.getRecycleBin).Methods("GET")
+	app.Router.HandleFunc("/api/activities", app.getActivities).Methods("GET")
+	app.Router.HandleFunc("/api/restore/{id}", app.restoreObject).Methods("PUT")
+	app.Router.HandleFunc("/api/project-export/{id}", app.exportProject).Methods("GET")
+	app.Router.HandleFunc("/api/project-import", app.importProject).Methods("POST")
+	app.Router.HandleFunc("/api/project-template/{id}", app.markTemplate).Methods("PUT")
+	app.Router.HandleFunc("/api/project-template/{id}", app.unmarkTemplate).Methods("DELETE")
+	app.Router.HandleFunc("/api/project-duplicate/{id}", app.duplicateProject).Methods("POST")
 	app.Router.HandleFunc("/api/invite/{id}", app.getInvitation).Methods("GET")
 	app.Router.HandleFunc("/api/invite/{id}", app.acceptInvitation).Methods("PUT")
+	app.Router.HandleFunc("/api/invite-settings/{id}", app.updateInviteSettings).Methods("PUT")
+	app.Router.HandleFunc("/api/rotate-invite/{id}", app.rotateInviteCode).Methods("PUT")
 	app.Router.HandleFunc("/api/collaborators/{id}", app.getCollaborators).Methods("GET")
 	app.Router.HandleFunc("/api/revoke/{projectId}/{userId}", app.revokeCollaborator).Methods("PUT")
+	app.Router.HandleFunc("/api/collaborator-access/{projectId}/{userId}", app.updateCollaboratorAccess).Methods("PUT")
+	app.Router.HandleFunc("/api/ownership-transfers", app.getOwnershipTransfers).Methods("GET")
+	app.Router.HandleFunc("/api/ownership-transfer", app.createOwnershipTransfer).Methods("POST")
+	app.Router.HandleFunc("/api/ownership-transfer/{id}", app.acceptOwnershipTransfer).Methods("PUT")
+	app.Router.HandleFunc("/api/ownership-transfer/{id}", app.cancelOwnershipTransfer).Methods("DELETE")
+	app.Router.HandleFunc("/api/access-override/{id}/{userId}", app.setAccessOverride).Methods("PUT")
+	app.Router.HandleFunc("/api/access-override/{id}/{userId}", app.dropAccessOverride).Methods("DELETE")
+	app.Router.HandleFunc("/api/invitations", app.getInvitations).Methods("GET")
+	app.Router.HandleFunc("/api/invitation", app.createInvitation).Methods("POST")
+	app.Router.HandleFunc("/api/invitation/{id}", app.revokeInvitation).Methods("DELETE")
+	app.Router.HandleFunc("/api/accept-invitation", app.acceptEmailInvitation).Methods("PUT")
+
+	// Organizations
+	app.Router.HandleFunc("/api/organizations", app.getOrganizations).Methods("GET")
+	app.Router.HandleFunc("/api/organization", app.createOrganization).Methods("POST")
+	app.Router.HandleFunc("/api/organization/{id}", app.getOrganization).Methods("GET")
+	app.Router.HandleFunc("/api/organization/{id}", app.updateOrganization).Methods("PUT")
+	app.Router.HandleFunc("/api/organization/{id}", app.deleteOrganization).Methods("DELETE")
+	app.Router.HandleFunc("/api/organization-members/{id}", app.getOrganizationMembers).Methods("GET")
+	app.Router.HandleFunc("/api/organization-member/{id}", app.addOrganizationMember).Methods("POST")
+	app.Router.HandleFunc("/api/organization-member/{id}/{userId}", app.updateOrganizationMember).Methods("PUT")
+	app.Router.HandleFunc("/api/organization-member/{id}/{userId}", app.removeOrganizationMember).Methods("DELETE")
+	app.Router.HandleFunc("/api/project-organization/{id}", app.setProjectOrganization).Methods("PUT")
+
+	// Teams
+	app.Router.HandleFunc("/api/teams", app.getTeams).Methods("GET")
+	app.Router.HandleFunc("/api/team", app.createTeam).Methods("POST")
+	app.Router.HandleFunc("/api/team/{id}", app.getTeam).Methods("GET")
+	app.Router.HandleFunc("/api/team/{id}", app.updateTeam).Methods("PUT")
+	app.Router.HandleFunc("/api/team/{id}", app.deleteTeam).Methods("DELETE")
+	app.Router.HandleFunc("/api/team-member/{id}", app.addTeamMember).Methods("POST")
+	app.Router.HandleFunc("/api/team-member/{id}/{userId}", app.removeTeamMember).Methods("DELETE")
+	app.Router.HandleFunc("/api/team-access/{projectId}/{teamId}", app.setTeamAccess).Methods("PUT")
+	app.Router.HandleFunc("/api/team-access/{projectId}/{teamId}", app.dropTeamAccess).Methods("DELETE")
+
+	// Roles
+	app.Router.HandleFunc("/api/roles", app.getRoles).Methods("GET")
+	app.Router.HandleFunc("/api/role", app.createRole).Methods("POST")
+	app.Router.HandleFunc("/api/role/{id}", app.updateRole).Methods("PUT")
+	app.Router.HandleFunc("/api/role/{id}", app.deleteRole).Methods("DELETE")
+	app.Router.HandleFunc("/api/assign-role/{projectId}/{userId}", app.assignRole).Methods("PUT")
 
 	// Scopes
 	app.Router.HandleFunc("/api/scopes", app.getScopes).Methods("GET")
@@ -257,6 +285,31 @@ func (app *App) initRoutes() {
 	app.Router.HandleFunc("/api/user/{id}", app.getUser).Methods("GET") // With ID
 	app.Router.HandleFunc("/api/user/{id}", app.updateUser).Methods("PUT")
 	app.Router.HandleFunc("/api/user/{id}", app.deleteUser).Methods("DELETE")
+	app.Router.HandleFunc("/api/logout", app.logout).Methods("POST")
+
+	// Personal access tokens and project API keys
+	app.Router.HandleFunc("/api/api-keys", app.getApiKeys).Methods("GET")
+	app.Router.HandleFunc("/api/api-key", app.createApiKey).Methods("POST")
+	app.Router.HandleFunc("/api/api-key/{id}", app.revokeApiKey).Methods("DELETE")
+
+	// SCIM provisioning by the identity provider
+	app.Router.HandleFunc("/scim/v2/Users", app.getScimUsers).Methods("GET")
+	app.Router.HandleFunc("/scim/v2/Users", app.createScimUser).Methods("POST")
+	app.Router.HandleFunc("/scim/v2/Users/{id}", app.getScimUser).Methods("GET")
+	app.Router.HandleFunc("/scim/v2/Users/{id}", app.replaceScimUser).Methods("PUT")
+	app.Router.HandleFunc("/scim/v2/Users/{id}", app.patchScimUser).Methods("PATCH")
+	app.Router.HandleFunc("/scim/v2/Users/{id}", app.deleteScimUser).Methods("DELETE")
+	app.Router.HandleFunc("/scim/v2/Groups", app.getScimGroups).Methods("GET")
+	app.Router.HandleFunc("/scim/v2/Groups", app.createScimGroup).Methods("POST")
+	app.Router.HandleFunc("/scim/v2/Groups/{id}", app.getScimGroup).Methods("GET")
+	app.Router.HandleFunc("/scim/v2/Groups/{id}", app.replaceScimGroup).Methods("PUT")
+	app.Router.HandleFunc("/scim/v2/Groups/{id}", app.patchScimGroup).Methods("PATCH")
+	app.Router.HandleFunc("/scim/v2/Groups/{id}", app.deleteScimGroup).Methods("DELETE")
+
+	// Local email/password provider
+	app.Router.HandleFunc("/api/auth/register", app.register).Methods("POST")
+	app.Router.HandleFunc("/api/auth/verify-email", app.verifyEmail).Methods("POST")
+	app.Router.HandleFunc("/api/auth/login", app.login).Methods("POST")
 
 	// Payment
 	app.Router.HandleFunc("/api/payments/callback", app.paymentCallback).Methods("POST")
@@ -267,6 +320,15 @@ func (app *App) initRoutes() {
 	// Blob
 	app.Router.HandleFunc("/api/blob", app.uploadFile).Methods("POST")
 	app.Router.HandleFunc("/api/blob/{id}", app.getFile).Methods("GET")
+	app.Router.HandleFunc("/api/blob/{id}", app.deleteFile).Methods("DELETE")
+
+	// Admin
+	app.Router.HandleFunc("/api/admin/blob-gc", app.getBlobGCReport).Methods("GET")
+	app.Router.HandleFunc("/api/admin/blob-gc/metrics", app.getBlobGCMetrics).Methods("GET")
+	app.Router.HandleFunc("/api/admin/revoke-tokens/{userId}", app.revokeUserTokens).Methods("PUT")
+	app.Router.HandleFunc("/api/admin/impersonation", app.startImpersonation).Methods("POST")
+	app.Router.HandleFunc("/api/admin/impersonation/{id}", app.endImpersonation).Methods("DELETE")
+	app.Router.HandleFunc("/api/admin/audit-logs", app.getAuditLogs).Methods("GET")
 }
 
 func (app *App) Run(addr string) {
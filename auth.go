@@ -6,15 +6,19 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
-	"flag"
-	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
-
-	"github.com/dgrijalva/jwt-go"
+	"time"
 )
 
+// hashToken returns the key of the token in the cache.
+func hashToken(idToken string) string {
+	hash := sha256.Sum256([]byte(idToken))
+	return hex.EncodeToString(hash[:])
+}
+
 func (app *App) authenticateIDToken(idToken string) (*User, error) {
 	var err error
 	if len(idToken) < 1 {
@@ -23,8 +27,7 @@ func (app *App) authenticateIDToken(idToken string) (*User, error) {
 		return nil, err
 	}
 	var u User
-	hash := sha256.Sum256([]byte(idToken))
-	t := Token{Key: hex.EncodeToString(hash[:])}
+	t := Token{Key: hashToken(idToken)}
 	if err = t.verifyCachedToken(); err != nil && err != sql.ErrNoRows {
 		log.Println(err)
 		return nil, err
@@ -34,28 +37,15 @@ func (app *App) authenticateIDToken(idToken string) (*User, error) {
 	if err == sql.ErrNoRows {
 		err = nil
 
-		var emailAddress string
-
-		if flag.Lookup("test.v") != nil { // In test
-			claims := jwt.MapClaims{}
-			_, _ = jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(idToken), nil
-			})
-			if claims["email"] == nil {
-				err = errors.New("invalid-token")
-				log.Println(err)
-				return nil, err
-			}
-			emailAddress = fmt.Sprintf("%v", claims["email"])
-		} else {
-			// Verify
-			verified, err := app.Firebase.VerifyIDToken(context.Background(), idToken)
-			if err != nil {
-				log.Println(err)
-				return nil, err
-			}
-			emailAddress = fmt.Sprintf("%v", verified.Firebase.Identities["email"].([]interface{})[0])
+		// Verify
+		identity, err := app.verifyIDToken(context.Background(), idToken)
+		if err != nil {
+			log.Println(err)
+			return nil, err
 		}
+		emailAddress := identity.EmailAddress
+		t.ExpiresAt = identity.ExpiresAt
+		t.AuthProvider = identity.Provider
 
 		if len(emailAddress) < 1 {
 			log.Println(err)
@@ -77,6 +67,17 @@ func (app *App) authenticateIDToken(idToken string) (*User, error) {
 			}
 		}
 
+		isRevoked, err := isRevokedToken(u.ID, identity.IssuedAt)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		if isRevoked {
+			err = errors.New("revoked-token")
+			log.Println(err)
+			return nil, err
+		}
+
 		// Ignoring err, should not be blocking
 		emailAddresses := []string{}
 		emailAddresses = append(emailAddresses, emailAddress)
@@ -85,7 +86,6 @@ func (app *App) authenticateIDToken(idToken string) (*User, error) {
 		// Store cached token
 		t.EmailAddress = u.EmailAddress
 		t.UserID = u.ID
-		t.AuthProvider = "google"
 		if err := t.cacheToken(); err != nil {
 			log.Println(err)
 			return nil, err
@@ -107,3 +107,25 @@ func (app *App) authenticateIDToken(idToken string) (*User, error) {
 
 	return &u, err
 }
+
+// runTokenPurgeLoop removes the expired tokens from the cache every
+// TOKEN_PURGE_INTERVAL_MINUTES, set it to 0 to disable the purge.
+func (app *App) runTokenPurgeLoop() {
+	minutes, err := strconv.Atoi(os.Getenv("TOKEN_PURGE_INTERVAL_MINUTES"))
+	if err != nil {
+		minutes = 60
+	}
+	if minutes < 1 {
+		log.Println("Token purge is disabled")
+		return
+	}
+	for {
+		time.Sleep(time.Duration(minutes) * time.Minute)
+		purged, err := purgeExpiredTokens()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("Expired tokens purged:", purged)
+	}
+}
@@ -6,17 +6,27 @@ import (
 	"log"
 )
 
+// Longest path from an object to its project (project → scope → scenario)
+const MAX_OBJECT_DEPTH = 4
+
 var ACL_LEVELS = [...]string{
 	"OWNER",
 	"MODIFY",
 	"READ",
 }
 
+// Uploaded bytes allowed per subscription type
+var STORAGE_LIMITS = map[string]int64{
+	"free":     100 * 1024 * 1024,
+	"standard": 10 * 1024 * 1024 * 1024,
+}
+
 type Token struct {
 	Key          string
 	EmailAddress string
 	UserID       string
 	AuthProvider string
+	ExpiresAt    int64 // Unix time, 0 when the token does not expire
 }
 
 type Quotas struct {
@@ -26,6 +36,8 @@ type Quotas struct {
 	Scenario         int    `json:"scenario"`
 	Session          int    `json:"session"`
 	Test             int    `json:"test"`
+	Storage          int64  `json:"storage"`
+	StorageLimit     int64  `json:"storage_limit"`
 }
 
 type Acl struct {
@@ -33,6 +45,7 @@ type Acl struct {
 	ObjectType string
 	UserID     string
 	Access     string
+	RoleID     string
 }
 
 type ParentChilds struct {
@@ -40,45 +53,143 @@ type ParentChilds struct {
 	Childs []string
 }
 
+// verifyCachedToken looks up a token that has been verified before. Revoked
+// tokens are kept until they expire so they are not verified again.
 func (t *Token) verifyCachedToken() error {
-	return app.DB.QueryRow(`
-	SELECT user_id, email_address, auth_provider FROM tokens WHERE key=$1
-	AND deleted_at IS NULL
+	var isRevoked, isExpired bool
+	err := app.DB.QueryRow(`
+	SELECT user_id, email_address, auth_provider,
+	deleted_at IS NOT NULL, COALESCE(expires_at <= NOW(), false)
+	FROM tokens WHERE key=$1
+	ORDER BY created_at DESC LIMIT 1
 	`,
 		t.Key).Scan(
 		&t.UserID,
 		&t.EmailAddress,
 		&t.AuthProvider,
+		&isRevoked,
+		&isExpired,
 	)
+	if err != nil {
+		return err
+	}
+	if isRevoked {
+		return errors.New("revoked-token")
+	}
+	if isExpired {
+		return errors.New("expired-token")
+	}
+	return nil
 }
 
 func (t *Token) cacheToken() error {
 	_, err := app.DB.Exec(`
-	INSERT INTO tokens (key, user_id, email_address, auth_provider) VALUES ($1, $2, $3, $4)
+	INSERT INTO tokens (key, user_id, email_address, auth_provider, expires_at)
+	VALUES ($1, $2, $3, $4, to_timestamp(NULLIF($5, 0))::timestamp)
 	`,
-		t.Key, t.UserID, t.EmailAddress, t.AuthProvider)
+		t.Key, t.UserID, t.EmailAddress, t.AuthProvider, t.ExpiresAt)
 	return err
 }
 
-func (ac *Acl) createAccess() error {
-	isValid := false
+func (t *Token) revokeToken() error {
+	_, err := app.DB.Exec(`
+	UPDATE tokens SET deleted_at=NOW() WHERE key=$1 AND deleted_at IS NULL
+	`, t.Key)
+	return err
+}
+
+// revokeUserTokens rejects every token issued to the user so far, including
+// the ones that have not been used yet.
+func revokeUserTokens(userID string) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = revokeUserTokensTx(tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func revokeUserTokensTx(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`
+	UPDATE tokens SET deleted_at=NOW() WHERE user_id=$1 AND deleted_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE users SET tokens_valid_after=NOW() WHERE id::text=$1
+	`, userID)
+	return err
+}
+
+// isRevokedToken checks whether a token issued at issuedAt (Unix time) was
+// issued before the tokens of the user have been revoked.
+func isRevokedToken(userID string, issuedAt int64) (bool, error) {
+	var isRevoked bool
+	err := app.DB.QueryRow(`
+	SELECT COALESCE(tokens_valid_after >= to_timestamp($2)::timestamp, false)
+	FROM users WHERE id::text=$1
+	`, userID, issuedAt).Scan(&isRevoked)
+	return isRevoked, err
+}
+
+// purgeExpiredTokens removes the cached tokens that cannot be used anymore,
+// revoked or not.
+func purgeExpiredTokens() (int64, error) {
+	res, err := app.DB.Exec(`
+	DELETE FROM tokens WHERE expires_at <= NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (ac *Acl) validate() error {
+	if ac.Access == ACL_CUSTOM {
+		if len(ac.RoleID) < 1 {
+			return errors.New("invalid-role")
+		}
+		// The role must be defined by the project of the object
+		role := Role{ID: ac.RoleID}
+		if err := role.getRole(); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("invalid-role")
+			}
+			return err
+		}
+		projectID, err := getProjectOf(ac.ObjectID)
+		if err != nil {
+			return err
+		}
+		if role.ProjectID != projectID {
+			return errors.New("invalid-role")
+		}
+		return nil
+	}
+	ac.RoleID = ""
 	for _, level := range ACL_LEVELS {
 		if level == ac.Access {
-			isValid = true
-			break
+			return nil
 		}
 	}
-	if !isValid {
-		err := errors.New("invalid-access-level")
+	return errors.New("invalid-access-level")
+}
+
+func (ac *Acl) createAccess() error {
+	if err := ac.validate(); err != nil {
 		log.Println(err)
 		return err
 	}
 	_, err := app.DB.Exec(`
 	INSERT INTO access_control_lists
-	(object_id, object_type, user_id, access)
-	VALUES ($1, $2, $3, $4)
+	(object_id, object_type, user_id, access, role_id)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''))
 	`,
-		ac.ObjectID, ac.ObjectType, ac.UserID, ac.Access)
+		ac.ObjectID, ac.ObjectType, ac.UserID, ac.Access, ac.RoleID)
 	return err
 }
 
@@ -91,18 +202,167 @@ func (ac *Acl) dropAccess() error {
 	return err
 }
 
+// dropChildAccess removes the rows of the user on the objects of the
+// project, so that the access to the project applies to all of them again.
+func dropChildAccess(projectID, userID string) error {
+	_, err := app.DB.Exec(`
+	DELETE FROM access_control_lists
+	WHERE user_id=$2 AND object_id IN (
+	  SELECT id::text FROM scopes WHERE project_id::text=$1
+	  UNION ALL SELECT id::text FROM scenarios WHERE project_id::text=$1
+	  UNION ALL SELECT id::text FROM sessions WHERE project_id::text=$1
+	  UNION ALL SELECT t.id::text FROM tests t, sessions s WHERE t.session_id=s.id AND s.project_id::text=$1
+	  UNION ALL SELECT id::text FROM blobs WHERE project_id::text=$1
+	)
+	`, projectID, userID)
+	return err
+}
+
+// setAccess replaces the access of the user to the object.
+func (ac *Acl) setAccess() error {
+	if err := ac.validate(); err != nil {
+		log.Println(err)
+		return err
+	}
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = ac.setAccessTx(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setAccessTx replaces the access within the transaction, the access must
+// have been validated.
+func (ac *Acl) setAccessTx(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	DELETE FROM access_control_lists
+	WHERE object_id=$1 AND object_type=$2 AND user_id=$3
+	`,
+		ac.ObjectID, ac.ObjectType, ac.UserID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO access_control_lists
+	(object_id, object_type, user_id, access, role_id)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+	`,
+		ac.ObjectID, ac.ObjectType, ac.UserID, ac.Access, ac.RoleID)
+	return err
+}
+
 func (a *Acl) getAccess() error {
-	return app.DB.QueryRow(`
-    SELECT object_type, access
+	var roleID sql.NullString
+	err := app.DB.QueryRow(`
+    SELECT object_type, access, role_id
     FROM access_control_lists
     WHERE object_id=$1 AND user_id=$2
+    AND deleted_at IS NULL
 	`,
 		a.ObjectID,
 		a.UserID,
 	).Scan(
 		&a.ObjectType,
 		&a.Access,
+		&roleID,
 	)
+	a.RoleID = roleID.String
+	return err
+}
+
+// resolveAccess finds the access of the user to the object by walking up
+// the object hierarchy (project → scope → scenario, project → session →
+// test, project → blob) until an ACL row is found. The nearest row wins, so
+// a row on a child object overrides the access inherited from the project.
+// Without any row, the access comes from the teams granted on the project,
+// then from its organization.
+func (a *Acl) resolveAccess() error {
+	objectID := a.ObjectID
+	for depth := 0; depth < MAX_OBJECT_DEPTH; depth++ {
+		access := Acl{ObjectID: objectID, UserID: a.UserID}
+		err := access.getAccess()
+		if err == nil {
+			if depth == 0 {
+				a.ObjectType = access.ObjectType
+			}
+			a.Access = access.Access
+			a.RoleID = access.RoleID
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		objectType, parentID, err := getParentObject(objectID)
+		if err == sql.ErrNoRows {
+			// Top of the hierarchy, the project grants access to teams and
+			// to the members of its organization
+			access, roleID, err := getTeamAccess(objectID, a.UserID)
+			if err == sql.ErrNoRows {
+				access, err = getOrganizationAccess(objectID, a.UserID)
+			}
+			if err != nil {
+				return err
+			}
+			if depth == 0 {
+				a.ObjectType = "project"
+			}
+			a.Access = access
+			a.RoleID = roleID
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if depth == 0 {
+			a.ObjectType = objectType
+		}
+		objectID = parentID
+	}
+	return sql.ErrNoRows
+}
+
+// getParentObject returns the type of a child object and the ID of its
+// parent, or sql.ErrNoRows for objects without parent.
+func getParentObject(objectID string) (string, string, error) {
+	var objectType, parentID string
+	err := app.DB.QueryRow(`
+	SELECT 'scope', project_id::text FROM scopes WHERE id::text=$1
+	UNION ALL
+	SELECT 'scenario', scope_id::text FROM scenarios WHERE id::text=$1
+	UNION ALL
+	SELECT 'session', project_id::text FROM sessions WHERE id::text=$1
+	UNION ALL
+	SELECT 'test', session_id::text FROM tests WHERE id::text=$1
+	UNION ALL
+	SELECT 'blob', project_id::text FROM blobs WHERE id::text=$1 AND project_id IS NOT NULL
+	LIMIT 1
+	`,
+		objectID).Scan(
+		&objectType,
+		&parentID,
+	)
+	return objectType, parentID, err
+}
+
+// getProjectOf returns the ID of the project at the top of the hierarchy of
+// the object, the object itself for projects.
+func getProjectOf(objectID string) (string, error) {
+	for depth := 0; depth < MAX_OBJECT_DEPTH; depth++ {
+		_, parentID, err := getParentObject(objectID)
+		if err == sql.ErrNoRows {
+			return objectID, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		objectID = parentID
+	}
+	return objectID, nil
 }
 
 func (p *ParentChilds) createParentChilds() error {
@@ -127,123 +387,196 @@ func (p *ParentChilds) createParentChilds() error {
 	return err
 }
 
-func getUserQuotas(userID string) (Quotas, error) {
-	var quotas Quotas
+// BillingAccount pays for the content of projects: the organization of the
+// project, or the owner for projects outside of any organization.
+type BillingAccount struct {
+	UserID           string
+	OrganizationID   string
+	SubscriptionType string
+}
+
+func getUserBillingAccount(userID string) (BillingAccount, error) {
+	b := BillingAccount{UserID: userID}
+	err := app.DB.QueryRow(`
+	SELECT subscription_type FROM users WHERE id::text=$1
+	`, userID).Scan(&b.SubscriptionType)
+	return b, err
+}
 
-	// Project
+func getOrganizationBillingAccount(organizationID string) (BillingAccount, error) {
+	b := BillingAccount{OrganizationID: organizationID}
 	err := app.DB.QueryRow(`
-	SELECT users.subscription_type, COUNT(*) FROM projects p, access_control_lists acl, users WHERE p.id::text=acl.object_id AND users.id::text=acl.user_id AND object_type='project' AND p.deleted_at IS NULL AND users.id=$1 GROUP BY users.id;
+	SELECT subscription_type FROM organizations WHERE id::text=$1 AND deleted_at IS NULL
+	`, organizationID).Scan(&b.SubscriptionType)
+	return b, err
+}
+
+func getProjectBillingAccount(projectID string) (BillingAccount, error) {
+	var organizationID string
+	err := app.DB.QueryRow(`
+	SELECT COALESCE(organization_id::text, '') FROM projects WHERE id::text=$1 AND deleted_at IS NULL
+	`, projectID).Scan(&organizationID)
+	if err != nil {
+		return BillingAccount{}, err
+	}
+	if len(organizationID) > 0 {
+		return getOrganizationBillingAccount(organizationID)
+	}
+	owner, err := getProjectOwner(projectID)
+	if err != nil {
+		return BillingAccount{}, err
+	}
+	return getUserBillingAccount(owner)
+}
+
+// billedProjects returns the query of the IDs of the projects billed to the
+// account, with its argument as $1.
+func (b *BillingAccount) billedProjects() (string, string) {
+	if len(b.OrganizationID) > 0 {
+		return `
+		SELECT id::text FROM projects WHERE organization_id::text=$1 AND deleted_at IS NULL
+		`, b.OrganizationID
+	}
+	return `
+	SELECT p.id::text FROM projects p, access_control_lists acl
+	WHERE p.id::text=acl.object_id AND acl.object_type='project' AND acl.access='OWNER'
+	AND acl.user_id=$1 AND p.organization_id IS NULL AND p.deleted_at IS NULL
+	`, b.UserID
+}
+
+// count returns the number of rows of the table in the billed projects,
+// table being one of projects, scopes, scenarios, sessions or tests.
+func (b *BillingAccount) count(table string) (int, error) {
+	billed, arg := b.billedProjects()
+	var query string
+	switch table {
+	case "projects":
+		query = `SELECT COUNT(*) FROM (` + billed + `) billed`
+	case "tests":
+		query = `
+		SELECT COUNT(tests.id) FROM tests, sessions
+		WHERE tests.session_id::text=sessions.id::text AND sessions.deleted_at IS NULL
+		AND sessions.project_id::text IN (` + billed + `)
+		`
+	default:
+		query = `
+		SELECT COUNT(*) FROM ` + table + `
+		WHERE deleted_at IS NULL AND project_id::text IN (` + billed + `)
+		`
+	}
+	var count int
+	err := app.DB.QueryRow(query, arg).Scan(&count)
+	return count, err
+}
+
+// getStorageUsage sums the bytes uploaded to the billed projects, and for
+// users the bytes they uploaded outside of any project.
+func (b *BillingAccount) getStorageUsage() (int64, error) {
+	billed, arg := b.billedProjects()
+	var usage int64
+	err := app.DB.QueryRow(`
+	SELECT COALESCE(SUM(b.size), 0) FROM blobs b
+	WHERE b.deleted_at IS NULL AND (
+	  b.project_id::text IN (`+billed+`) OR
+	  (b.project_id IS NULL AND EXISTS (
+	    SELECT 1 FROM access_control_lists acl
+	    WHERE acl.object_type='blob' AND acl.object_id=b.id::text
+	    AND acl.access='OWNER' AND acl.user_id=$1
+	  ))
+	)
 	`,
-		userID).Scan(
-		&quotas.SubscriptionType,
-		&quotas.Project,
+		arg).Scan(
+		&usage,
 	)
-	if err != nil && err != sql.ErrNoRows {
+	return usage, err
+}
+
+func (b *BillingAccount) getQuotas() (Quotas, error) {
+	var err error
+	quotas := Quotas{SubscriptionType: b.SubscriptionType}
+
+	if quotas.Project, err = b.count("projects"); err != nil {
 		log.Println(err)
 		return quotas, err
 	}
-
-	// Scope
-	err = app.DB.QueryRow(`
-	SELECT COUNT(scopes.id) FROM scopes, projects, access_control_lists acl, users WHERE scopes.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND scopes.deleted_at IS NULL GROUP BY users.id;
-	`, userID).Scan(
-		&quotas.Scope,
-	)
-	if err != nil && err != sql.ErrNoRows {
+	if quotas.Scope, err = b.count("scopes"); err != nil {
 		log.Println(err)
 		return quotas, err
 	}
-
-	// Scenario
-	err = app.DB.QueryRow(`
-	SELECT COUNT(scenarios.id) FROM scenarios, projects, access_control_lists acl, users WHERE scenarios.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND scenarios.deleted_at IS NULL GROUP BY users.id;
-	`,
-		userID).Scan(
-		&quotas.Scenario,
-	)
-	if err != nil && err != sql.ErrNoRows {
+	if quotas.Scenario, err = b.count("scenarios"); err != nil {
 		log.Println(err)
 		return quotas, err
 	}
-
-	// Session
-	err = app.DB.QueryRow(`
-	SELECT COUNT(sessions.id) FROM sessions, projects, access_control_lists acl, users WHERE sessions.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND sessions.deleted_at IS NULL GROUP BY users.id;
-	`,
-		userID).Scan(
-		&quotas.Session,
-	)
-	if err != nil && err != sql.ErrNoRows {
+	if quotas.Session, err = b.count("sessions"); err != nil {
 		log.Println(err)
 		return quotas, err
 	}
-
-	// Test
-	err = app.DB.QueryRow(`
-SELECT COUNT(tests.id) FROM tests, projects, sessions, access_control_lists acl, users WHERE tests.session_id::text=sessions.id::text AND sessions.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND sessions.deleted_at IS NULL GROUP BY users.id;
-	`,
-		userID).Scan(
-		&quotas.Test,
-	)
-	if err != nil && err != sql.ErrNoRows {
+	if quotas.Test, err = b.count("tests"); err != nil {
 		log.Println(err)
 		return quotas, err
 	}
+	if quotas.Storage, err = b.getStorageUsage(); err != nil {
+		log.Println(err)
+		return quotas, err
+	}
+	quotas.StorageLimit = STORAGE_LIMITS[quotas.SubscriptionType]
 
 	return quotas, nil
-
 }
 
-func isEligibleToCreateProject(userID string) (bool, error) {
-	var subscriptionType string
-	var count int
-	err := app.DB.QueryRow(`
-	SELECT users.subscription_type, COUNT(*) FROM projects p, access_control_lists acl, users WHERE p.id::text=acl.object_id AND users.id::text=acl.user_id AND object_type='project' AND p.deleted_at IS NULL AND users.id=$1 GROUP BY users.id;
-	`,
-		userID).Scan(
-		&subscriptionType,
-		&count,
-	)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return true, nil
-		}
+// getUserQuotas counts the content of the projects the user owns outside of
+// any organization.
+func getUserQuotas(userID string) (Quotas, error) {
+	account, err := getUserBillingAccount(userID)
+	if err != nil && err != sql.ErrNoRows {
 		log.Println(err)
-		return false, err
-	}
-	if subscriptionType == "free" && count >= 3 {
-		return false, nil
-	}
-	if subscriptionType == "standard" && count >= 10 {
-		return false, nil
+		return Quotas{}, err
 	}
-	return true, nil
+	return account.getQuotas()
+}
 
+// getStorageUsage sums the bytes uploaded to the projects owned by the user
+// and the bytes the user uploaded outside of any project.
+func getStorageUsage(userID string) (int64, error) {
+	account := BillingAccount{UserID: userID}
+	return account.getStorageUsage()
 }
 
-func isEligibleToCreateScope(projectID string) (bool, error) {
-	var userID string
-	err := app.DB.QueryRow(`
-    SELECT users.id FROM projects p, access_control_lists acl, users WHERE p.id::text=acl.object_id AND users.id::text=acl.user_id AND object_type='project' AND acl.access='OWNER' AND p.deleted_at IS NULL
-    AND p.id=$1
-	`,
-		projectID).Scan(
-		&userID,
-	)
+// Content limits of the subscriptions, unlimited for the other types
+var CONTENT_LIMITS = map[string]map[string]int{
+	"free": {
+		"projects":  3,
+		"scopes":    10,
+		"scenarios": 50,
+		"sessions":  50,
+	},
+	"standard": {
+		"projects":  10,
+		"scopes":    100,
+		"scenarios": 1000,
+		"sessions":  1000,
+	},
+}
+
+// isEligibleToCreate checks that the account can have n more rows of the
+// table.
+func (b *BillingAccount) isEligibleToCreate(table string, n int) (bool, error) {
+	limit, ok := CONTENT_LIMITS[b.SubscriptionType][table]
+	if !ok {
+		return true, nil
+	}
+	count, err := b.count(table)
 	if err != nil {
 		log.Println(err)
 		return false, err
 	}
+	return count+n <= limit, nil
+}
 
-	var count int
-	var subscriptionType string
-	err = app.DB.QueryRow(`
-	SELECT users.subscription_type, COUNT(scopes.id) FROM scopes, projects, access_control_lists acl, users WHERE scopes.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND scopes.deleted_at IS NULL GROUP BY users.id;
-	`,
-		userID).Scan(
-		&subscriptionType,
-		&count,
-	)
+// isEligibleToCreateProject checks the quota of the organization when set,
+// the quota of the user otherwise.
+func isEligibleToCreateProject(userID, organizationID string) (bool, error) {
+	account, err := getNewProjectBillingAccount(userID, organizationID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return true, nil
@@ -251,89 +584,67 @@ func isEligibleToCreateScope(projectID string) (bool, error) {
 		log.Println(err)
 		return false, err
 	}
-	if subscriptionType == "free" && count >= 10 {
-		return false, nil
-	}
-	if subscriptionType == "standard" && count >= 100 {
-		return false, nil
-	}
-	return true, nil
+	return account.isEligibleToCreate("projects", 1)
 }
 
-func isEligibleToCreateScenario(projectID string) (bool, error) {
-	var userID string
-	err := app.DB.QueryRow(`
-    SELECT users.id FROM projects p, access_control_lists acl, users WHERE p.id::text=acl.object_id AND users.id::text=acl.user_id AND object_type='project' AND p.deleted_at IS NULL
-    AND p.id=$1
-	`,
-		projectID).Scan(
-		&userID,
-	)
-	if err != nil {
-		log.Println(err)
-		return false, err
+// getNewProjectBillingAccount returns who pays for a project the user
+// creates, in the organization when set.
+func getNewProjectBillingAccount(userID, organizationID string) (BillingAccount, error) {
+	if len(organizationID) > 0 {
+		return getOrganizationBillingAccount(organizationID)
 	}
+	return getUserBillingAccount(userID)
+}
 
-	var subscriptionType string
-	var count int
-	err = app.DB.QueryRow(`
-	SELECT users.subscription_type, COUNT(scenarios.id) FROM scenarios, projects, access_control_lists acl, users WHERE scenarios.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND scenarios.deleted_at IS NULL GROUP BY users.id;
-	`,
-		userID).Scan(
-		&subscriptionType,
-		&count,
-	)
+func isEligibleToCreateInProject(projectID, table string) (bool, error) {
+	account, err := getProjectBillingAccount(projectID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return true, nil
-		}
 		log.Println(err)
 		return false, err
 	}
-	if subscriptionType == "free" && count >= 50 {
-		return false, nil
-	}
-	if subscriptionType == "standard" && count >= 1000 {
-		return false, nil
-	}
-	return true, nil
+	return account.isEligibleToCreate(table, 1)
+}
+
+func isEligibleToCreateScope(projectID string) (bool, error) {
+	return isEligibleToCreateInProject(projectID, "scopes")
+}
+
+func isEligibleToCreateScenario(projectID string) (bool, error) {
+	return isEligibleToCreateInProject(projectID, "scenarios")
 }
 
 func isEligibleToCreateSession(projectID string) (bool, error) {
-	var userID string
-	err := app.DB.QueryRow(`
-    SELECT users.id FROM projects p, access_control_lists acl, users WHERE p.id::text=acl.object_id AND users.id::text=acl.user_id AND object_type='project' AND p.deleted_at IS NULL
-    AND p.id=$1
-	`,
-		projectID).Scan(
-		&userID,
-	)
+	return isEligibleToCreateInProject(projectID, "sessions")
+}
+
+// isEligibleToUpload checks the storage quota of whoever pays for the upload,
+// the organization or the owner of the project, or the uploader when there
+// is no project.
+func isEligibleToUpload(userID, projectID string, size int64) (bool, error) {
+	var account BillingAccount
+	var err error
+	if len(projectID) > 0 {
+		account, err = getProjectBillingAccount(projectID)
+	} else {
+		account, err = getUserBillingAccount(userID)
+	}
 	if err != nil {
 		log.Println(err)
 		return false, err
 	}
+	return account.isEligibleToStore(size)
+}
 
-	var subscriptionType string
-	var count int
-	err = app.DB.QueryRow(`
-	SELECT users.subscription_type, COUNT(sessions.id) FROM sessions, projects, access_control_lists acl, users WHERE sessions.project_id::text=projects.id::text AND acl.object_id=projects.id::text AND acl.access='OWNER' AND acl.user_id::text=users.id::text AND users.id=$1 AND sessions.deleted_at IS NULL GROUP BY users.id;
-	`,
-		userID).Scan(
-		&subscriptionType,
-		&count,
-	)
+func (b *BillingAccount) isEligibleToStore(size int64) (bool, error) {
+	limit, ok := STORAGE_LIMITS[b.SubscriptionType]
+	if !ok {
+		return true, nil
+	}
+
+	usage, err := b.getStorageUsage()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return true, nil
-		}
 		log.Println(err)
 		return false, err
 	}
-	if subscriptionType == "free" && count >= 50 {
-		return false, nil
-	}
-	if subscriptionType == "standard" && count >= 1000 {
-		return false, nil
-	}
-	return true, nil
+	return usage+size <= limit, nil
 }
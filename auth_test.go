@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	uuidParser "github.com/docker/distribution/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -13,7 +22,7 @@ func TestAuthenticateIDToken(t *testing.T) {
 	app.MigrateClean()
 	defer app.DB.Close()
 
-	// Verify against firebase
+	// Verify against the JWKS in testdata
 	currentUser, err := app.authenticateIDToken(testUserToken1)
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "padfoot.tgz@gmail.com", currentUser.EmailAddress)
@@ -47,3 +56,329 @@ func TestAuthPublicEndpoint(t *testing.T) {
 	response = executeRequest(req)
 	assert.Equal(t, http.StatusOK, response.Code)
 }
+
+func TestAuthTokenExpiry(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	currentUser, err := app.authenticateIDToken(testUserToken1)
+	assert.Equal(t, nil, err)
+
+	_, err = app.DB.Exec(`UPDATE tokens SET expires_at=NOW() - INTERVAL '1 MINUTE' WHERE user_id=$1`, currentUser.ID)
+	assert.Equal(t, nil, err)
+
+	// Expired tokens are not verified again
+	_, err = app.authenticateIDToken(testUserToken1)
+	assert.Equal(t, "expired-token", err.Error())
+
+	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Contains(t, response.Body.String(), "expired-token")
+
+	purged, err := purgeExpiredTokens()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), purged)
+}
+
+func TestAuthLogout(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	req, _ := http.NewRequest("POST", "/api/logout", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Contains(t, response.Body.String(), "revoked-token")
+
+	// Other tokens are still valid
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestAuthRevokeUserTokens(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	currentUser, err := app.authenticateIDToken(testUserToken2)
+	assert.Equal(t, nil, err)
+
+	req, _ := http.NewRequest("PUT", "/api/admin/revoke-tokens/"+currentUser.ID, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/admin/revoke-tokens/"+currentUser.ID, nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	_, err = app.authenticateIDToken(testUserToken2)
+	assert.Equal(t, "revoked-token", err.Error())
+
+	// Tokens issued before the revocation are rejected even if never used
+	_, err = app.DB.Exec(`DELETE FROM tokens WHERE user_id=$1`, currentUser.ID)
+	assert.Equal(t, nil, err)
+	_, err = app.authenticateIDToken(testUserToken2)
+	assert.Equal(t, "revoked-token", err.Error())
+}
+
+func TestAuthLocalProvider(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	local, err := NewLocalAuthenticator(strings.Repeat("s", 32))
+	assert.Equal(t, nil, err)
+	authenticators := app.Authenticators
+	app.Authenticators = append([]Authenticator{local}, authenticators...)
+	defer func() { app.Authenticators = authenticators }()
+
+	credential := []byte(`{"emailAddress":"local@example.com","password":"correct horse"}`)
+	req, _ := http.NewRequest("POST", "/api/auth/register", bytes.NewBuffer([]byte(`{"emailAddress":"local@example.com","password":"short"}`)))
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/auth/register", bytes.NewBuffer(credential))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	// Not verified yet
+	req, _ = http.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(credential))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// The token is only sent by email, replace it with a known one
+	_, err = app.DB.Exec(`UPDATE local_credentials SET verification_key=$1`, hashToken("verification"))
+	assert.Equal(t, nil, err)
+
+	req, _ = http.NewRequest("POST", "/api/auth/verify-email", bytes.NewBuffer([]byte(`{"token":"wrong"}`)))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/auth/verify-email", bytes.NewBuffer([]byte(`{"token":"verification"}`)))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// Verified addresses cannot be taken over
+	req, _ = http.NewRequest("POST", "/api/auth/register", bytes.NewBuffer(credential))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/auth/login", bytes.NewBuffer([]byte(`{"emailAddress":"local@example.com","password":"wrong password"}`)))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/auth/login", bytes.NewBuffer(credential))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var login map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &login)
+	token := fmt.Sprintf("%s", login["token"])
+
+	identity, err := local.Verify(context.Background(), token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "local@example.com", identity.EmailAddress)
+	assert.Equal(t, AUTH_PROVIDER_LOCAL, identity.Provider)
+
+	// Signed with another secret
+	other, _ := NewLocalAuthenticator(strings.Repeat("x", 32))
+	forged, _, _ := other.issueToken("local@example.com")
+	_, err = local.Verify(context.Background(), forged)
+	assert.NotEqual(t, nil, err)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "local@example.com")
+}
+
+func TestAuthParseJWKS(t *testing.T) {
+	keys, err := parseJWKS([]byte(`{"keys":[
+	{"kty":"RSA","kid":"rsa","use":"sig","n":"sXchDaQebHnPiGvyDOAT4saGEUetSyo9MKLOoWFsueri23bOdgWp4Dy1WlUzewbgBHod5pcM9H95GQRV3JDXboIRROSBigeC5yjU1hGzHHyXss8UDprecbAYxknTcQkhslANGRUZmdTOQ5qTRsLAt6BTYuyvVRdhS8exSZEy_c4gs_7svlJJQ4H9_NxsiIoLwAEk7-Q3UXERGYw_75IDrGA84-lA_-Ct4eTlXHBIY2EaV7t7LjJaynVJCpkv4LKjTTAumiGUIuQhrNhZLuF_RJLqHpM2kgWFLU7-VTdL1VbC2tejvcI2BlMkEpk1BzBZI0KQB0GaDWFLN-aEAw3vRw","e":"AQAB"},
+	{"kty":"EC","kid":"ec","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+	{"kty":"RSA","kid":"enc","use":"enc","n":"sXch","e":"AQAB"}
+	]}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(keys))
+	_, isRSA := keys["rsa"].(*rsa.PublicKey)
+	assert.True(t, isRSA)
+	_, isEC := keys["ec"].(*ecdsa.PublicKey)
+	assert.True(t, isEC)
+
+	_, err = parseJWKS([]byte(`{"keys":[]}`))
+	assert.NotEqual(t, nil, err)
+}
+
+func TestAuthJWKSProvider(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	// ES256 keys are accepted as well
+	token := mintTestTokenWithKey("./testdata/jwt-ec.pem", "test-ec", jwt.SigningMethodES256,
+		jwt.MapClaims{"email": "ec@example.com"})
+	currentUser, err := app.authenticateIDToken(token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ec@example.com", currentUser.EmailAddress)
+
+	invalidTokens := map[string]string{
+		"expired": mintTestToken(jwt.MapClaims{"email": "expired@example.com",
+			"exp": time.Now().Add(-time.Minute).Unix()}),
+		"issuer":     mintTestToken(jwt.MapClaims{"email": "issuer@example.com", "iss": "https://evil.test"}),
+		"audience":   mintTestToken(jwt.MapClaims{"email": "audience@example.com", "aud": "other"}),
+		"unverified": mintTestToken(jwt.MapClaims{"email": "unverified@example.com", "email_verified": false}),
+		"unknown-key": mintTestTokenWithKey("./testdata/jwt-ec.pem", "other", jwt.SigningMethodES256,
+			jwt.MapClaims{"email": "unknown-key@example.com"}),
+		"tampered": mintTestToken(jwt.MapClaims{"email": "tampered@example.com"}) + "x",
+	}
+	for name, token := range invalidTokens {
+		_, err = app.authenticateIDToken(token)
+		assert.NotEqual(t, nil, err, name)
+	}
+
+	// Unsigned tokens are not accepted anymore
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+		"email": "unsigned@example.com",
+		"iss":   TEST_TOKEN_ISSUER,
+		"aud":   TEST_TOKEN_AUDIENCE,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = app.authenticateIDToken(unsigned)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestAuthPersonalApiKey(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	readOnly := createTestObject(t, testUserToken1, "/api/api-key", `{"name":"dashboard","scope":"read-only","expiresInDays":30}`)
+	readOnlyKey := "Bearer " + fmt.Sprintf("%s", readOnly["key"])
+	assert.True(t, strings.HasPrefix(readOnlyKey, "Bearer "+API_KEY_PREFIX_PERSONAL))
+	assert.NotEqual(t, "", readOnly["expiresAt"])
+
+	req, _ := http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", readOnlyKey)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/project", bytes.NewBuffer([]byte(`{"name":"test project"}`)))
+	req.Header.Set("Authorization", readOnlyKey)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "api-key-scope")
+
+	// Keys can not manage keys
+	req, _ = http.NewRequest("GET", "/api/api-keys", nil)
+	req.Header.Set("Authorization", readOnlyKey)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Only the hash is stored, the key is not listed
+	req, _ = http.NewRequest("GET", "/api/api-keys", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "dashboard")
+	assert.NotContains(t, response.Body.String(), readOnly["key"])
+	assert.NotContains(t, response.Body.String(), `"lastUsedAt":""`)
+
+	// Other users only see their own keys
+	req, _ = http.NewRequest("GET", "/api/api-keys", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotContains(t, response.Body.String(), "dashboard")
+
+	req, _ = http.NewRequest("DELETE", "/api/api-key/"+fmt.Sprintf("%s", readOnly["id"]), nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", readOnlyKey)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Contains(t, response.Body.String(), "invalid-api-key")
+
+	full := createTestObject(t, testUserToken1, "/api/api-key", `{"name":"script","scope":"full"}`)
+	fullKey := "Bearer " + fmt.Sprintf("%s", full["key"])
+
+	req, _ = http.NewRequest("POST", "/api/project", bytes.NewBuffer([]byte(`{"name":"test project"}`)))
+	req.Header.Set("Authorization", fullKey)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	_, err := app.DB.Exec(`UPDATE api_keys SET expires_at=NOW() - INTERVAL '1 day' WHERE id=$1`, full["id"])
+	assert.Equal(t, nil, err)
+
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", fullKey)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Contains(t, response.Body.String(), "expired-api-key")
+}
+
+func TestAuthProjectApiKey(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	otherProject := createTestObject(t, testUserToken1, "/api/project", `{"name":"other project"}`)
+	otherProjectId := fmt.Sprintf("%s", otherProject["id"])
+
+	// Collaborators need the permission to manage the keys
+	req, _ := http.NewRequest("POST", "/api/api-key", bytes.NewBuffer([]byte(`{"name":"ci","scope":"results-write","projectId":"`+projectId+`"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	apiKey := createTestObject(t, testUserToken1, "/api/api-key", `{"name":"ci","scope":"results-write","projectId":"`+projectId+`"}`)
+	key := "Bearer " + fmt.Sprintf("%s", apiKey["key"])
+	assert.True(t, strings.HasPrefix(key, "Bearer "+API_KEY_PREFIX_PROJECT))
+
+	req, _ = http.NewRequest("GET", "/api/api-keys?projectId="+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"name":"ci"`)
+
+	// CI creates sessions of the project
+	req, _ = http.NewRequest("POST", "/api/session", bytes.NewBuffer([]byte(`{"version":"1.0","projectId":"`+projectId+`","scenarios":[]}`)))
+	req.Header.Set("Authorization", key)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	// But not in other projects of the user
+	req, _ = http.NewRequest("POST", "/api/session", bytes.NewBuffer([]byte(`{"version":"1.0","projectId":"`+otherProjectId+`","scenarios":[]}`)))
+	req.Header.Set("Authorization", key)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/project/"+otherProjectId, nil)
+	req.Header.Set("Authorization", key)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Results-write does not allow to change the project
+	req, _ = http.NewRequest("POST", "/api/scope", bytes.NewBuffer([]byte(`{"name":"scope","projectId":"`+projectId+`"}`)))
+	req.Header.Set("Authorization", key)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "api-key-scope")
+
+	// Nor to use the routes outside of a project
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", key)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "api-key-scope")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Authenticator verifies the ID tokens issued by an identity provider.
+type Authenticator interface {
+	Name() string
+	// Verify checks the signature and the claims of the token and returns
+	// the identity it was issued for.
+	Verify(ctx context.Context, idToken string) (*Identity, error)
+}
+
+// Identity is the verified subject of an ID token.
+type Identity struct {
+	EmailAddress string
+	Provider     string // Cached as the auth provider of the token
+	IssuedAt     int64  // Unix time
+	ExpiresAt    int64  // Unix time, 0 when the token does not expire
+}
+
+const (
+	AUTH_PROVIDER_FIREBASE = "firebase"
+	AUTH_PROVIDER_OIDC     = "oidc"
+	AUTH_PROVIDER_JWKS     = "jwks"
+	AUTH_PROVIDER_LOCAL    = "local"
+)
+
+// newAuthenticators creates the authenticators listed in AUTH_PROVIDERS,
+// comma separated. Firebase is the default.
+func newAuthenticators() ([]Authenticator, error) {
+	providers := os.Getenv("AUTH_PROVIDERS")
+	if len(providers) < 1 {
+		providers = AUTH_PROVIDER_FIREBASE
+	}
+
+	authenticators := []Authenticator{}
+	for _, provider := range strings.Split(providers, ",") {
+		var authenticator Authenticator
+		var err error
+		switch strings.TrimSpace(provider) {
+		case AUTH_PROVIDER_FIREBASE:
+			authenticator, err = NewFirebaseAuthenticator(
+				os.Getenv("FIREBASE_ACCOUNT_KEY_PATH"),
+				os.Getenv("FIREBASE_PROJECT_ID"),
+			)
+		case AUTH_PROVIDER_OIDC:
+			authenticator, err = NewOIDCAuthenticator(
+				os.Getenv("OIDC_ISSUER"),
+				os.Getenv("OIDC_AUDIENCE"),
+				os.Getenv("OIDC_JWKS_URL"),
+			)
+		case AUTH_PROVIDER_JWKS:
+			authenticator, err = NewJWKSAuthenticator(
+				os.Getenv("JWKS_ISSUER"),
+				os.Getenv("JWKS_AUDIENCE"),
+				os.Getenv("JWKS_SOURCE"),
+			)
+		case AUTH_PROVIDER_LOCAL:
+			authenticator, err = NewLocalAuthenticator(os.Getenv("LOCAL_AUTH_SECRET"))
+		default:
+			return nil, errors.New("unknown-auth-provider")
+		}
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, authenticator)
+	}
+	return authenticators, nil
+}
+
+// verifyIDToken asks every configured authenticator to verify the token,
+// the first one to accept it wins.
+func (app *App) verifyIDToken(ctx context.Context, idToken string) (*Identity, error) {
+	err := errors.New("invalid-token")
+	for _, authenticator := range app.Authenticators {
+		identity, verifyErr := authenticator.Verify(ctx, idToken)
+		if verifyErr == nil {
+			if len(identity.EmailAddress) < 1 {
+				return nil, errors.New("invalid-token")
+			}
+			return identity, nil
+		}
+		err = verifyErr
+	}
+	return nil, err
+}
+
+// localAuthenticator returns the local email/password authenticator, or nil
+// when the local provider is not enabled.
+func (app *App) localAuthenticator() *LocalAuthenticator {
+	for _, authenticator := range app.Authenticators {
+		if local, ok := authenticator.(*LocalAuthenticator); ok {
+			return local
+		}
+	}
+	return nil
+}
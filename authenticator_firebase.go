@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/auth"
+	"google.golang.org/api/option"
+)
+
+type FirebaseAuthenticator struct {
+	Client *auth.Client
+}
+
+func NewFirebaseAuthenticator(accountKeyPath, projectID string) (*FirebaseAuthenticator, error) {
+	opt := option.WithCredentialsFile(accountKeyPath)
+	config := &firebase.Config{ProjectID: projectID}
+
+	firebaseApp, err := firebase.NewApp(context.Background(), config, opt)
+	if err != nil {
+		return nil, err
+	}
+	client, err := firebaseApp.Auth(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &FirebaseAuthenticator{Client: client}, nil
+}
+
+func (f *FirebaseAuthenticator) Name() string {
+	return AUTH_PROVIDER_FIREBASE
+}
+
+func (f *FirebaseAuthenticator) Verify(ctx context.Context, idToken string) (*Identity, error) {
+	verified, err := f.Client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+	emails, _ := verified.Firebase.Identities["email"].([]interface{})
+	if len(emails) < 1 {
+		return nil, errors.New("invalid-token")
+	}
+	emailAddress, _ := emails[0].(string)
+
+	// The sign-in method of the user, e.g. google.com or password
+	provider := verified.Firebase.SignInProvider
+	if len(provider) < 1 {
+		provider = AUTH_PROVIDER_FIREBASE
+	}
+
+	return &Identity{
+		EmailAddress: emailAddress,
+		Provider:     provider,
+		IssuedAt:     verified.IssuedAt,
+		ExpiresAt:    verified.Expires,
+	}, nil
+}
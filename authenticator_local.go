@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Issuer of the tokens signed by the local provider
+const LOCAL_AUTH_ISSUER = "testscope-local"
+
+// LocalAuthenticator signs in the users registered with an email address
+// and a password, and verifies the tokens it issues to them.
+type LocalAuthenticator struct {
+	Secret   []byte
+	TokenTTL time.Duration
+}
+
+// NewLocalAuthenticator signs the tokens with the secret. They are valid for
+// LOCAL_AUTH_TOKEN_TTL_MINUTES, an hour by default.
+func NewLocalAuthenticator(secret string) (*LocalAuthenticator, error) {
+	if len(secret) < 32 {
+		return nil, errors.New("invalid-local-auth-secret")
+	}
+	minutes, err := strconv.Atoi(os.Getenv("LOCAL_AUTH_TOKEN_TTL_MINUTES"))
+	if err != nil || minutes < 1 {
+		minutes = 60
+	}
+	return &LocalAuthenticator{
+		Secret:   []byte(secret),
+		TokenTTL: time.Duration(minutes) * time.Minute,
+	}, nil
+}
+
+func (l *LocalAuthenticator) Name() string {
+	return AUTH_PROVIDER_LOCAL
+}
+
+// issueToken signs a token for the email address.
+func (l *LocalAuthenticator) issueToken(emailAddress string) (string, int64, error) {
+	now := time.Now()
+	expiresAt := now.Add(l.TokenTTL).Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"iss":   LOCAL_AUTH_ISSUER,
+		"sub":   emailAddress,
+		"email": emailAddress,
+		"iat":   now.Unix(),
+		"exp":   expiresAt,
+	})
+	signed, err := token.SignedString(l.Secret)
+	if err != nil {
+		return "", 0, err
+	}
+	return signed, expiresAt, nil
+}
+
+func (l *LocalAuthenticator) Verify(ctx context.Context, idToken string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("invalid-signing-method")
+		}
+		return l.Secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(LOCAL_AUTH_ISSUER, true) {
+		return nil, errors.New("invalid-issuer")
+	}
+	emailAddress, _ := claims["email"].(string)
+	expiresAt := claimTime(claims, "exp")
+	if len(emailAddress) < 1 || expiresAt < 1 {
+		return nil, errors.New("invalid-token")
+	}
+	return &Identity{
+		EmailAddress: emailAddress,
+		Provider:     AUTH_PROVIDER_LOCAL,
+		IssuedAt:     claimTime(claims, "iat"),
+		ExpiresAt:    expiresAt,
+	}, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OIDCAuthenticator verifies the ID tokens of any OpenID Connect provider,
+// e.g. Keycloak or Auth0, against the keys it publishes.
+type OIDCAuthenticator struct {
+	Provider string
+	Issuer   string
+	Audience string
+	Keys     *JWKS
+}
+
+// NewOIDCAuthenticator uses the keys at jwksURL, or discovers them from the
+// configuration of the issuer when jwksURL is empty.
+func NewOIDCAuthenticator(issuer, audience, jwksURL string) (*OIDCAuthenticator, error) {
+	if len(issuer) < 1 || len(audience) < 1 {
+		return nil, errors.New("invalid-oidc-config")
+	}
+	if len(jwksURL) < 1 {
+		var err error
+		jwksURL, err = discoverJWKSURL(issuer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &OIDCAuthenticator{
+		Provider: AUTH_PROVIDER_OIDC,
+		Issuer:   issuer,
+		Audience: audience,
+		Keys:     NewJWKS(jwksURL),
+	}, nil
+}
+
+// NewJWKSAuthenticator verifies the tokens signed by the keys of a JWKS file
+// or URL, without any discovery. Along with a local key pair, it allows to
+// run the API offline, e.g. for development and tests.
+func NewJWKSAuthenticator(issuer, audience, source string) (*OIDCAuthenticator, error) {
+	if len(issuer) < 1 || len(audience) < 1 || len(source) < 1 {
+		return nil, errors.New("invalid-jwks-config")
+	}
+	keys := NewJWKS(source)
+	// Fail early on a misconfigured source
+	if err := keys.refresh(); err != nil {
+		return nil, err
+	}
+	return &OIDCAuthenticator{
+		Provider: AUTH_PROVIDER_JWKS,
+		Issuer:   issuer,
+		Audience: audience,
+		Keys:     keys,
+	}, nil
+}
+
+func discoverJWKSURL(issuer string) (string, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimRight(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New("oidc-discovery-failed")
+	}
+	var config struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return "", err
+	}
+	if len(config.JWKSURI) < 1 {
+		return "", errors.New("oidc-discovery-failed")
+	}
+	return config.JWKSURI, nil
+}
+
+func (o *OIDCAuthenticator) Name() string {
+	return o.Provider
+}
+
+func (o *OIDCAuthenticator) Verify(ctx context.Context, idToken string) (*Identity, error) {
+	claims, err := verifyJWT(idToken, o.Keys, o.Issuer, o.Audience)
+	if err != nil {
+		return nil, err
+	}
+	// Unverified addresses could be claimed by anyone
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, errors.New("email-not-verified")
+	}
+	emailAddress, _ := claims["email"].(string)
+	if len(emailAddress) < 1 {
+		return nil, errors.New("invalid-token")
+	}
+	return &Identity{
+		EmailAddress: emailAddress,
+		Provider:     o.Provider,
+		IssuedAt:     claimTime(claims, "iat"),
+		ExpiresAt:    claimTime(claims, "exp"),
+	}, nil
+}
@@ -2,21 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"errors"
 	"io"
+	"io/ioutil"
 	"log"
+	"os"
 	"time"
-
-	"github.com/minio/minio-go/v7"
-	uuid "github.com/satori/go.uuid"
 )
 
-type Minio struct {
-	bucketName     string
-	bucketLocation string
-	client         *minio.Client
-}
-
 type BlobData struct {
 	ID          string
 	Filename    string
@@ -24,6 +20,8 @@ type BlobData struct {
 	Size        int64
 	Metadata    string
 	Bucket      string
+	Hash        string
+	ProjectID   string
 	IsPublic    bool
 	Timestamp   time.Time
 	HasContent  bool
@@ -35,11 +33,6 @@ type BlobChunk struct {
 	Seq  int32
 }
 
-type BucketRequest struct {
-	Filename string
-	Bucket   string
-}
-
 type ID struct {
 	ID string
 }
@@ -50,17 +43,83 @@ const (
 	PUBLIC_BUCKET  = "public-default"
 )
 
-func (app *App) GetBlob(ctx context.Context, req *BlobData) error {
-	obj, err := app.Storage.client.GetObject(ctx, req.Bucket, req.ID, minio.GetObjectOptions{})
+// objectKey returns the storage key of the blob content. Blobs are stored
+// by their content hash, older blobs (without hash) are stored by their ID.
+func (b *BlobData) objectKey() string {
+	if len(b.Hash) > 0 {
+		return b.Hash
+	}
+	return b.ID
+}
+
+// getBlob loads the blob, hidden while its project is deleted.
+func (b *BlobData) getBlob() error {
+	var hash, projectID sql.NullString
+	err := app.DB.QueryRow(`
+	SELECT filename, content_type, size, bucket, hash, metadata, project_id, created_at
+	FROM blobs WHERE id::text=$1
+	AND deleted_at IS NULL
+	AND NOT EXISTS (
+	  SELECT 1 FROM projects WHERE projects.id=blobs.project_id AND projects.deleted_at IS NOT NULL
+	)
+	`,
+		b.ID).Scan(
+		&b.Filename,
+		&b.ContentType,
+		&b.Size,
+		&b.Bucket,
+		&hash,
+		&b.Metadata,
+		&projectID,
+		&b.Timestamp,
+	)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
+	b.Hash = hash.String
+	b.ProjectID = projectID.String
+	b.IsPublic = b.Bucket == PUBLIC_BUCKET
+	b.HasContent = b.Size > 0
+	return nil
+}
 
-	defer obj.Close()
+// getBlobContent loads the blob with the content of one of its variants,
+// or its own content when variant is empty.
+func (b *BlobData) getBlobContent(variant string) error {
+	if err := b.getBlob(); err != nil {
+		return err
+	}
+	if len(variant) > 0 {
+		return b.getBlobVariant(variant)
+	}
 	return nil
 }
 
+// GetBlob opens the blob content, or the content of one of its variants,
+// starting at offset. A negative length reads until the end of the content.
+func (app *App) GetBlob(ctx context.Context, req *BlobData, variant string, offset, length int64) (io.ReadCloser, error) {
+	if err := req.getBlobContent(variant); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return app.OpenBlob(ctx, req, offset, length)
+}
+
+// OpenBlob opens the content of a blob already loaded, starting at offset.
+// The range must lie within the content.
+func (app *App) OpenBlob(ctx context.Context, req *BlobData, offset, length int64) (io.ReadCloser, error) {
+	obj, err := app.Storage.Get(ctx, req.Bucket, req.objectKey(), offset, length)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+// PutBlob stores the content addressed by its SHA-256 hash. If the same
+// content already exists in the bucket, only a new reference is created
+// and the existing object is reused.
 func (app *App) PutBlob(ctx context.Context, req *BlobData, data io.Reader) (*ID, error) {
 	if req.Size == 0 {
 		return nil, errors.New("empty-file")
@@ -68,101 +127,282 @@ func (app *App) PutBlob(ctx context.Context, req *BlobData, data io.Reader) (*ID
 	if len(req.Bucket) == 0 {
 		req.Bucket = DEFAULT_BUCKET
 	}
-	id := uuid.NewV4().String()
 
-	_ = app.Storage.client.MakeBucket(ctx, req.Bucket, minio.MakeBucketOptions{
-		Region: app.Storage.bucketLocation,
-	})
-
-	existing, err := app.GetFromBucket(ctx, &BucketRequest{Filename: req.Filename, Bucket: req.Bucket})
-	if existing != nil {
-		tag, err := app.DB.Exec(`
-			UPDATE blobs
-			SET timestamp=CURRENT_TIMESTAMP, size=$1, metadata=$2, content_type=$3, bucket=$4
-			WHERE id=$5
-			`, req.Size, req.Metadata, req.ContentType, req.Bucket, existing.ID)
-		if err != nil {
-			return nil, err
-		}
-		if _, err = tag.RowsAffected(); err != nil {
-			return nil, errors.New("unable-to-update-file")
-		}
-		id = existing.ID
-	} else {
-		_, err = app.DB.Exec(`
-							INSERT INTO blob_repository
-							(id, filename, content_type, timestamp, size, metadata, bucket)
-							VALUES
-							($1, $2, $3, CURRENT_TIMESTAMP, $4, $5, $6)
-						`, id, req.Filename, req.ContentType, req.Size, req.Metadata, req.Bucket)
-		if err != nil {
-			return nil, err
-		}
+	// Spool the content so it can be hashed before it reaches the storage
+	tmp, err := ioutil.TempFile("", "blob-")
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	tag, err := app.DB.Exec(`
-		INSERT INTO blob_repository
-		(id, filename, content_type, timestamp, size, metadata, bucket)
-		VALUES
-		($1, $2, $3, CURRENT_TIMESTAMP, $4, $5, $6)
-	`, id, req.Filename, req.ContentType, req.Size, req.Metadata, req.Bucket)
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), data)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
+	if size == 0 {
+		return nil, errors.New("empty-file")
+	}
+	req.Size = size
+	req.Hash = hex.EncodeToString(hash.Sum(nil))
 
-	if _, err = tag.RowsAffected(); err != nil {
-		return nil, errors.New("unable-to-put-file")
+	_ = app.Storage.MakeBucket(ctx, req.Bucket)
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
+	defer tx.Rollback()
 
-	// Put object
-	_, err = app.Storage.client.PutObject(ctx, req.Bucket, id, data, -1,
-		minio.PutObjectOptions{
-			ContentType: req.ContentType,
-			PartSize:    10 * 1024 * 1024,
-		})
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	stored, err := app.retainObject(ctx, tx, req.Bucket, req.Hash, req.Size, req.ContentType, tmp)
+	if err == nil {
+		err = tx.QueryRow(`
+		INSERT INTO blobs (filename, content_type, size, bucket, hash, metadata, project_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid) RETURNING id
+		`, req.Filename, req.ContentType, req.Size, req.Bucket, req.Hash, req.Metadata, req.ProjectID).Scan(&req.ID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		log.Println(err)
+		tx.Rollback()
+		if stored {
+			app.discardStoredObject(ctx, req)
+		}
 		return nil, err
 	}
 
+	// Variants are best effort, the upload succeeds without them
+	if isImage(req.ContentType) {
+		if _, err = tmp.Seek(0, io.SeekStart); err == nil {
+			err = app.createBlobVariants(ctx, req, tmp)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
 	return &ID{
-		ID: id,
+		ID: req.ID,
 	}, nil
 }
 
-func (app *App) GetFromBucket(ctx context.Context, req *BucketRequest) (*BlobData, error) {
-	rows, err := app.DB.Query(`
-	
-		SELECT id, filename, content_type, timestamp, size, metadata, chunks
-		FROM  blob_repository
-		WHERE filename like '%` + req.Filename + `' AND bucket='` + req.Bucket + `'
-	`)
+// DeleteBlob drops a reference to the content. The object itself is only
+// removed from the storage once there is no reference left.
+func (app *App) DeleteBlob(ctx context.Context, req *BlobData) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer tx.Rollback()
 
+	var hash sql.NullString
+	err = tx.QueryRow(`
+	UPDATE blobs SET deleted_at=NOW() WHERE id::text=$1 AND deleted_at IS NULL
+	RETURNING bucket, hash
+	`, req.ID).Scan(&req.Bucket, &hash)
 	if err != nil {
-		return nil, err
+		log.Println(err)
+		return err
+	}
+	req.Hash = hash.String
+
+	// Derived content goes away together with the blob
+	rows, err := tx.Query(`
+	DELETE FROM blob_variants WHERE blob_id::text=$1 RETURNING bucket, hash
+	`, req.ID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	variants := []BlobData{}
+	for rows.Next() {
+		var v BlobData
+		if err = rows.Scan(&v.Bucket, &v.Hash); err != nil {
+			rows.Close()
+			log.Println(err)
+			return err
+		}
+		variants = append(variants, v)
 	}
-	defer rows.Close()
+	rows.Close()
 
-	if rows.Next() {
-		var resp BlobData
-		var fileName string
-		err = rows.Scan(&resp.ID, &resp.Filename, &resp.ContentType, &resp.Timestamp, &resp.Size, &resp.Metadata, &fileName)
+	// Objects are removed from the storage once the release is committed
+	released := []blobObject{}
+	for _, v := range variants {
+		o, err := releaseObject(tx, v.Bucket, v.Hash)
 		if err != nil {
-			return nil, err
+			log.Println(err)
+			return err
 		}
-		if fileName == resp.Filename {
-			resp.HasContent = true
+		if o != nil {
+			released = append(released, *o)
 		}
+	}
+	if len(req.Hash) > 0 {
+		o, err := releaseObject(tx, req.Bucket, req.Hash)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if o != nil {
+			released = append(released, *o)
+		}
+	}
 
-		if req.Bucket == "public-default" {
-			resp.IsPublic = true
-		} else {
-			resp.IsPublic = false
+	if err = tx.Commit(); err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, o := range released {
+		app.deleteStoredObject(ctx, o)
+	}
+	if len(req.Hash) < 1 {
+		// Blobs stored before the deduplication have no record to retry with
+		if err := app.Storage.Delete(ctx, req.Bucket, req.objectKey()); err != nil {
+			log.Println("object left in the storage", req.Bucket, req.objectKey(), err)
 		}
-		resp.Bucket = req.Bucket
+	}
+	return nil
+}
+
+// retainObject adds a reference to the content identified by its hash,
+// storing the content first if nobody references it yet, and tells whether
+// it did. The row stays locked until the transaction ends, so concurrent
+// uploads of the same content wait instead of storing it twice. The
+// content stored by a transaction rolled back is left without a record,
+// see discardStoredObject.
+func (app *App) retainObject(ctx context.Context, tx *sql.Tx, bucket, hash string, size int64, contentType string, data io.Reader) (bool, error) {
+	var refCount int
+	err := tx.QueryRow(`
+	INSERT INTO blob_objects (bucket, hash, size, content_type, ref_count)
+	VALUES ($1, $2, $3, $4, 1)
+	ON CONFLICT (bucket, hash) DO UPDATE
+	SET ref_count=blob_objects.ref_count+1, updated_at=NOW()
+	RETURNING ref_count
+	`, bucket, hash, size, contentType).Scan(&refCount)
+	if err != nil {
+		return false, err
+	}
+	if refCount > 1 {
+		return false, nil
+	}
+	return true, app.Storage.Put(ctx, bucket, hash, data, size, contentType)
+}
+
+// discardStoredObject removes the content stored by retainObject in a
+// transaction rolled back since, unless another upload stored it again.
+func (app *App) discardStoredObject(ctx context.Context, b *BlobData) {
+	app.deleteStoredObject(ctx, blobObject{
+		Bucket:      b.Bucket,
+		Hash:        b.Hash,
+		Size:        b.Size,
+		ContentType: b.ContentType,
+	})
+}
 
-		return &resp, nil
+// releaseObject drops a reference to the content identified by its hash.
+// The object without any reference left is returned, to be removed from
+// the storage with deleteStoredObject once the transaction is committed.
+// Its record stays until then, so that the removal can tell whether the
+// content was uploaded again meanwhile.
+func releaseObject(tx *sql.Tx, bucket, hash string) (*blobObject, error) {
+	var refCount int
+	o := blobObject{Bucket: bucket, Hash: hash}
+	err := tx.QueryRow(`
+	UPDATE blob_objects SET ref_count=ref_count-1, updated_at=NOW()
+	WHERE bucket=$1 AND hash=$2
+	RETURNING ref_count, size, content_type
+	`, bucket, hash).Scan(&refCount, &o.Size, &o.ContentType)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if refCount > 0 {
+		return nil, nil
+	}
+	// Unrecorded objects are removed too
+	return &o, nil
+}
+
+// deleteStoredObject removes an object without any reference left from the
+// storage, see removeStoredObject. On failure the object is recorded without
+// any reference, the GC removes it on its next run.
+func (app *App) deleteStoredObject(ctx context.Context, o blobObject) {
+	if err := app.removeStoredObject(ctx, o, false); err != nil {
+		log.Println("object left in the storage", o.Bucket, o.Hash, err)
+	}
+}
+
+// removeStoredObject removes the object from the storage along with its
+// record, unless a kept blob or variant still refers to it. The record is
+// locked meanwhile, so an upload of the same content waits and stores it
+// again afterwards. Unless collecting, objects referenced again since they
+// were released are kept too, whatever the blobs say.
+func (app *App) removeStoredObject(ctx context.Context, o blobObject, collect bool) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO blob_objects (bucket, hash, size, content_type, ref_count)
+	VALUES ($1, $2, $3, $4, 0)
+	ON CONFLICT (bucket, hash) DO NOTHING
+	`, o.Bucket, o.Hash, o.Size, o.ContentType)
+	if err != nil {
+		return err
+	}
+	var refCount int
+	var isReferenced bool
+	err = tx.QueryRow(`
+	SELECT ref_count FROM blob_objects WHERE bucket=$1 AND hash=$2
+	FOR UPDATE
+	`, o.Bucket, o.Hash).Scan(&refCount)
+	if err != nil {
+		return err
+	}
+	if refCount > 0 && !collect {
+		return nil
+	}
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+	  SELECT 1 FROM blobs WHERE bucket=$1 AND hash=$2 AND deleted_at IS NULL
+	) OR EXISTS (
+	  SELECT 1 FROM blob_variants v, blobs b
+	  WHERE v.blob_id=b.id AND b.deleted_at IS NULL AND v.bucket=$1 AND v.hash=$2
+	)
+	`, o.Bucket, o.Hash).Scan(&isReferenced)
+	if err != nil {
+		return err
+	}
+	if isReferenced {
+		return nil
+	}
+
+	if err = app.Storage.Delete(ctx, o.Bucket, o.Hash); err != nil {
+		// The record is kept for the GC to retry
+		if err := tx.Commit(); err != nil {
+			log.Println(err)
+		}
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM blob_objects WHERE bucket=$1 AND hash=$2
+	`, o.Bucket, o.Hash)
+	if err != nil {
+		return err
 	}
-	return nil, errors.New("blob-not-found")
+	return tx.Commit()
 }
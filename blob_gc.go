@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+type BlobGCReport struct {
+	DryRun          bool      `json:"dryRun"`
+	RetentionHours  int       `json:"retentionHours"`
+	ExpiredBlobs    int       `json:"expiredBlobs"`    // Blobs of projects deleted past the retention
+	OrphanedObjects int       `json:"orphanedObjects"` // Stored objects without any kept blob
+	PurgedRecords   int       `json:"purgedRecords"`   // Deleted blob records past the retention
+	ReclaimedBytes  int64     `json:"reclaimedBytes"`
+	StartedAt       time.Time `json:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt"`
+}
+
+type BlobGCMetrics struct {
+	Runs            int        `json:"runs"`
+	ExpiredBlobs    int        `json:"expiredBlobs"`
+	OrphanedObjects int        `json:"orphanedObjects"`
+	PurgedRecords   int        `json:"purgedRecords"`
+	ReclaimedBytes  int64      `json:"reclaimedBytes"`
+	LastRunAt       *time.Time `json:"lastRunAt"`
+}
+
+type blobObject struct {
+	Bucket      string
+	Hash        string
+	Size        int64
+	ContentType string
+}
+
+// blobGCRetention is the time a deleted blob or the blobs of a deleted
+// project are kept before being collected.
+func blobGCRetention() int {
+	hours, err := strconv.Atoi(os.Getenv("BLOB_GC_RETENTION_HOURS"))
+	if err != nil || hours < 0 {
+		hours = 720 // 30 days
+	}
+	return hours
+}
+
+// runBlobGCLoop collects the garbage every BLOB_GC_INTERVAL_MINUTES,
+// set it to 0 to disable the collector.
+func (app *App) runBlobGCLoop() {
+	minutes, err := strconv.Atoi(os.Getenv("BLOB_GC_INTERVAL_MINUTES"))
+	if err != nil {
+		minutes = 60
+	}
+	if minutes < 1 {
+		log.Println("Blob garbage collector is disabled")
+		return
+	}
+	for {
+		time.Sleep(time.Duration(minutes) * time.Minute)
+		report, err := app.collectBlobGarbage(context.Background(), false)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("Blob garbage collected, reclaimed bytes:", report.ReclaimedBytes)
+	}
+}
+
+// collectBlobGarbage removes the blobs of projects deleted past the
+// retention, the stored objects no kept blob refers to, and the records of
+// blobs deleted past the retention. The dry run only reports them.
+func (app *App) collectBlobGarbage(ctx context.Context, dryRun bool) (*BlobGCReport, error) {
+	report := &BlobGCReport{
+		DryRun:         dryRun,
+		RetentionHours: blobGCRetention(),
+		StartedAt:      time.Now(),
+	}
+
+	expiredBlobs, err := getExpiredBlobs(report.RetentionHours)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	report.ExpiredBlobs = len(expiredBlobs)
+
+	// Includes the objects that become unreferenced with the expired blobs
+	orphans, err := getCollectibleBlobObjects(report.RetentionHours)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	report.OrphanedObjects = len(orphans)
+	for _, o := range orphans {
+		report.ReclaimedBytes += o.Size
+	}
+
+	if dryRun {
+		err = app.DB.QueryRow(`
+		SELECT COUNT(*) FROM blobs
+		WHERE deleted_at <= NOW() - make_interval(hours => $1)
+		`, report.RetentionHours).Scan(&report.PurgedRecords)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		report.FinishedAt = time.Now()
+		return report, nil
+	}
+
+	for _, id := range expiredBlobs {
+		if err = app.DeleteBlob(ctx, &BlobData{ID: id}); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+
+	// Whatever is left has an inconsistent reference count
+	for _, o := range orphans {
+		if err = app.removeBlobObject(ctx, o); err != nil {
+			// Left for the next run
+			log.Println("object left in the storage", o.Bucket, o.Hash, err)
+		}
+	}
+
+	report.PurgedRecords, err = purgeDeletedBlobs(report.RetentionHours)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now()
+	_, err = app.DB.Exec(`
+	INSERT INTO blob_gc_runs
+	(expired_blobs, orphaned_objects, purged_records, reclaimed_bytes, started_at, finished_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`,
+		report.ExpiredBlobs,
+		report.OrphanedObjects,
+		report.PurgedRecords,
+		report.ReclaimedBytes,
+		report.StartedAt,
+		report.FinishedAt,
+	)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func getExpiredBlobs(retentionHours int) ([]string, error) {
+	rows, err := app.DB.Query(`
+	SELECT b.id FROM blobs b, projects p
+	WHERE b.project_id=p.id AND b.deleted_at IS NULL
+	AND p.deleted_at <= NOW() - make_interval(hours => $1)
+	`, retentionHours)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// getCollectibleBlobObjects lists the stored objects not referenced by any
+// kept blob or by the variants of a kept blob.
+func getCollectibleBlobObjects(retentionHours int) ([]blobObject, error) {
+	rows, err := app.DB.Query(`
+	WITH kept AS (
+	  SELECT b.id, b.bucket, b.hash FROM blobs b
+	  LEFT JOIN projects p ON p.id=b.project_id
+	  WHERE b.deleted_at IS NULL
+	  AND (p.deleted_at IS NULL OR p.deleted_at > NOW() - make_interval(hours => $1))
+	)
+	SELECT o.bucket, o.hash, o.size, o.content_type FROM blob_objects o
+	WHERE NOT EXISTS (
+	  SELECT 1 FROM kept WHERE kept.bucket=o.bucket AND kept.hash=o.hash
+	)
+	AND NOT EXISTS (
+	  SELECT 1 FROM blob_variants v, kept
+	  WHERE v.blob_id=kept.id AND v.bucket=o.bucket AND v.hash=o.hash
+	)
+	`, retentionHours)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	objects := []blobObject{}
+	for rows.Next() {
+		var o blobObject
+		if err := rows.Scan(&o.Bucket, &o.Hash, &o.Size, &o.ContentType); err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// removeBlobObject removes the object unless a blob was stored with the same
+// content since it was listed, whatever its reference count says.
+func (app *App) removeBlobObject(ctx context.Context, o blobObject) error {
+	return app.removeStoredObject(ctx, o, true)
+}
+
+func purgeDeletedBlobs(retentionHours int) (int, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	DELETE FROM blob_variants WHERE blob_id IN (
+	  SELECT id FROM blobs WHERE deleted_at <= NOW() - make_interval(hours => $1)
+	)
+	`, retentionHours)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM access_control_lists WHERE object_type='blob' AND object_id IN (
+	  SELECT id::text FROM blobs WHERE deleted_at <= NOW() - make_interval(hours => $1)
+	)
+	`, retentionHours)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+	DELETE FROM blobs WHERE deleted_at <= NOW() - make_interval(hours => $1)
+	`, retentionHours)
+	if err != nil {
+		return 0, err
+	}
+	purged, _ := res.RowsAffected()
+
+	return int(purged), tx.Commit()
+}
+
+func getBlobGCMetrics() (*BlobGCMetrics, error) {
+	var m BlobGCMetrics
+	err := app.DB.QueryRow(`
+	SELECT COUNT(*),
+	COALESCE(SUM(expired_blobs), 0),
+	COALESCE(SUM(orphaned_objects), 0),
+	COALESCE(SUM(purged_records), 0),
+	COALESCE(SUM(reclaimed_bytes), 0),
+	MAX(finished_at)
+	FROM blob_gc_runs
+	`).Scan(
+		&m.Runs,
+		&m.ExpiredBlobs,
+		&m.OrphanedObjects,
+		&m.PurgedRecords,
+		&m.ReclaimedBytes,
+		&m.LastRunAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
@@ -2,9 +2,15 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	uuidParser "github.com/docker/distribution/uuid"
 	"github.com/gorilla/mux"
 )
 
@@ -25,11 +31,41 @@ func (app *App) uploadFile(w http.ResponseWriter, r *http.Request) {
 
 	bucketName := DEFAULT_BUCKET
 
+	// Optional, blobs of a project are collected after the project is deleted
+	projectId := r.FormValue("projectId")
+	if apiKey := getRequestApiKey(r); len(projectId) < 1 && apiKey != nil {
+		// Uploads of a project key belong to its project
+		projectId = apiKey.ProjectID
+	}
+	if len(projectId) > 0 {
+		if !authorizeReference(w, r, projectId, PERMISSION_UPLOAD_FILES) {
+			return
+		}
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	gb := NewGrowthBook(app.GBFeatures, "")
+	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
+	if isContentCreationLimiterEnabled {
+		isEligible, err := isEligibleToUpload(currentUser.ID, projectId, handler.Size)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !isEligible {
+			respondError(w, 429, "storage-quota-exceeded")
+			return
+		}
+	}
+
 	payload := &BlobData{
 		Filename:    handler.Filename,
 		ContentType: contentType,
 		Size:        handler.Size,
 		Bucket:      bucketName,
+		ProjectID:   projectId,
 	}
 
 	resp, err := app.PutBlob(context.Background(), payload, file)
@@ -38,6 +74,20 @@ func (app *App) uploadFile(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+
+	access := Acl{
+		ObjectID:   resp.ID,
+		ObjectType: "blob",
+		UserID:     currentUser.ID,
+		Access:     "OWNER",
+	}
+	err = access.createAccess()
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	respond(w, http.StatusOK, resp)
 }
 
@@ -45,8 +95,182 @@ func (app *App) getFile(w http.ResponseWriter, r *http.Request) {
 	var err error
 	vars := mux.Vars(r)
 	id := vars["id"]
-	if len(id) > 0 {
+	_, err = uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
+	b := &BlobData{ID: id}
+	if r.FormValue("presign") == "true" {
+		if err = b.getBlob(); err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				respondError(w, http.StatusNotFound, "item-not-found")
+			default:
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		url, err := app.Storage.Presign(r.Context(), b.Bucket, b.objectKey(), 15*time.Minute)
+		if err != nil {
+			log.Println(err)
+			if err == ErrPresignNotSupported {
+				respondError(w, http.StatusNotImplemented, err.Error())
+			} else {
+				respondError(w, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		respond(w, http.StatusOK, map[string]string{"url": url})
+		return
+	}
+
+	variant := r.FormValue("variant")
+	if len(variant) > 0 && !isBlobVariant(variant) {
+		respondError(w, http.StatusBadRequest, "invalid-variant")
+		return
+	}
+
+	if err = b.getBlobContent(variant); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			respondError(w, http.StatusNotFound, "item-not-found")
+		default:
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// The range is checked before the object is opened, the storage rejects
+	// the ranges out of the content
+	offset, length, isPartial := parseRange(r.Header.Get("Range"))
+	if isPartial {
+		if offset >= b.Size {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(b.Size, 10))
+			respondError(w, http.StatusRequestedRangeNotSatisfiable, "invalid-range")
+			return
+		}
+		if length < 0 || offset+length > b.Size {
+			length = b.Size - offset
+		}
+	}
+
+	obj, err := app.OpenBlob(r.Context(), b, offset, length)
+	if err != nil {
+		switch err {
+		case ErrObjectNotFound:
+			respondError(w, http.StatusNotFound, "item-not-found")
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	defer obj.Close()
+
+	w.Header().Set("Content-Type", b.ContentType)
+	w.Header().Set("Content-Disposition", `inline; filename="`+b.Filename+`"`)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if isPartial {
+		w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(offset, 10)+"-"+
+			strconv.FormatInt(offset+length-1, 10)+"/"+strconv.FormatInt(b.Size, 10))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(b.Size, 10))
+		w.WriteHeader(http.StatusOK)
+	}
+	if _, err = io.Copy(w, obj); err != nil {
+		log.Println(err)
+	}
+}
+
+// parseRange parses a single "bytes=start-end" range. Suffix ranges and
+// multiple ranges are not supported, the whole content is served instead.
+func parseRange(header string) (offset, length int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, -1, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(bounds) != 2 || len(bounds[0]) < 1 {
+		return 0, -1, false
+	}
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start < 0 {
+		return 0, -1, false
+	}
+	if len(bounds[1]) < 1 {
+		return start, -1, true
+	}
+	end, err := strconv.ParseInt(bounds[1], 10, 64)
+	if err != nil || end < start {
+		return 0, -1, false
+	}
+	return start, end - start + 1, true
+}
+
+func (app *App) deleteFile(w http.ResponseWriter, r *http.Request) {
+	var err error
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err = uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
+	b := &BlobData{ID: id}
+	if err = app.DeleteBlob(r.Context(), b); err != nil {
+		log.Println(err)
+		switch err {
+		case sql.ErrNoRows:
+			respondError(w, http.StatusNotFound, "item-not-found")
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (app *App) getBlobGCReport(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	report, err := app.collectBlobGarbage(r.Context(), true)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, report)
+}
+
+func (app *App) getBlobGCMetrics(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	metrics, err := getBlobGCMetrics()
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
-	respondError(w, http.StatusInternalServerError, err.Error())
 
+	respond(w, http.StatusOK, metrics)
 }
@@ -1,7 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,3 +31,277 @@ func TestBlobUploadFile(t *testing.T) {
 	response = executeRequest(req)
 	assert.Equal(t, http.StatusOK, response.Code)
 }
+
+func uploadTestFile(token, filename string, content []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", filename)
+	part.Write(content)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/api/blob", body)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return executeRequest(req)
+}
+
+func TestBlobDeduplication(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	content := []byte("the same screenshot")
+
+	response := uploadTestFile(testUserToken1, "first.png", content)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var first map[string]string
+	json.Unmarshal(response.Body.Bytes(), &first)
+
+	response = uploadTestFile(testUserToken1, "second.png", content)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var second map[string]string
+	json.Unmarshal(response.Body.Bytes(), &second)
+
+	// Two references, one object
+	assert.NotEqual(t, first["ID"], second["ID"])
+	var objects, refCount int
+	err := app.DB.QueryRow(`SELECT COUNT(*), MAX(ref_count) FROM blob_objects`).Scan(&objects, &refCount)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, objects)
+	assert.Equal(t, 2, refCount)
+
+	req, _ := http.NewRequest("GET", "/api/blob/"+second["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, string(content), response.Body.String())
+
+	// The object survives as long as there is a reference
+	req, _ = http.NewRequest("DELETE", "/api/blob/"+first["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+second["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/blob/"+second["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	err = app.DB.QueryRow(`SELECT COUNT(*) FROM blob_objects`).Scan(&objects)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, objects)
+
+	// A removal delayed past a new upload of the content keeps it
+	response = uploadTestFile(testUserToken1, "third.png", content)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var third map[string]string
+	json.Unmarshal(response.Body.Bytes(), &third)
+	o := blobObject{}
+	err = app.DB.QueryRow(`SELECT bucket, hash FROM blob_objects`).Scan(&o.Bucket, &o.Hash)
+	assert.Equal(t, nil, err)
+	app.deleteStoredObject(context.Background(), o)
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+third["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, string(content), response.Body.String())
+}
+
+func TestBlobFailedUpload(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	// The blob cannot be recorded, the object stored for it goes away
+	req := &BlobData{Filename: "lost.png", ContentType: "image/png", Size: 4, ProjectID: "invalid-id"}
+	_, err := app.PutBlob(context.Background(), req, bytes.NewReader([]byte("lost")))
+	assert.NotEqual(t, nil, err)
+	_, err = app.Storage.Stat(context.Background(), req.Bucket, req.Hash)
+	assert.Equal(t, ErrObjectNotFound, err)
+	var objects int
+	app.DB.QueryRow(`SELECT COUNT(*) FROM blob_objects`).Scan(&objects)
+	assert.Equal(t, 0, objects)
+}
+
+func TestBlobThumbnail(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 600, 400)))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="screenshot.png"`)
+	header.Set("Content-Type", "image/png")
+	part, _ := writer.CreatePart(header)
+	part.Write(buf.Bytes())
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/api/blob", body)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var m map[string]string
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+m["ID"]+"?variant=thumb", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	thumb, err := png.DecodeConfig(response.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 256, thumb.Width)
+	assert.Equal(t, 170, thumb.Height)
+
+	// Ranges are checked against the size of the content served
+	var thumbSize int
+	app.DB.QueryRow(`SELECT size FROM blob_variants WHERE blob_id::text=$1`, m["ID"]).Scan(&thumbSize)
+	req, _ = http.NewRequest("GET", "/api/blob/"+m["ID"]+"?variant=thumb", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", thumbSize))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+m["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", buf.Len()))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+m["ID"], nil)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", buf.Len()-1))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusPartialContent, response.Code)
+	assert.Equal(t, 1, response.Body.Len())
+
+	// Non-image uploads have no variants
+	response = uploadTestFile(testUserToken1, "notes.txt", []byte("not an image"))
+	assert.Equal(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &m)
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+m["ID"]+"?variant=thumb", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestBlobResizeImage(t *testing.T) {
+	dst := resizeImage(image.NewRGBA(image.Rect(0, 0, 400, 1000)), 256)
+	assert.Equal(t, 102, dst.Bounds().Dx())
+	assert.Equal(t, 256, dst.Bounds().Dy())
+
+	// Smaller images are kept as is
+	dst = resizeImage(image.NewRGBA(image.Rect(0, 0, 100, 50)), 256)
+	assert.Equal(t, 100, dst.Bounds().Dx())
+}
+
+func TestBlobGarbageCollection(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	jsonStr := []byte(`{"name":"test project"}`)
+	req, _ := http.NewRequest("POST", "/api/project", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var project map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &project)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	content := []byte("recording of a deleted project")
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("projectId", projectId)
+	part, _ := writer.CreateFormFile("file", "recording.webm")
+	part.Write(content)
+	writer.Close()
+	req, _ = http.NewRequest("POST", "/api/blob", body)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// Deleted long ago
+	_, err := app.DB.Exec(`UPDATE projects SET deleted_at=NOW() - INTERVAL '1000 HOURS' WHERE id=$1`, projectId)
+	assert.Equal(t, nil, err)
+
+	req, _ = http.NewRequest("GET", "/api/admin/blob-gc", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/admin/blob-gc", nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var report BlobGCReport
+	json.Unmarshal(response.Body.Bytes(), &report)
+	assert.Equal(t, true, report.DryRun)
+	assert.Equal(t, 1, report.ExpiredBlobs)
+	assert.Equal(t, int64(len(content)), report.ReclaimedBytes)
+
+	// Dry run keeps everything
+	var objects int
+	app.DB.QueryRow(`SELECT COUNT(*) FROM blob_objects`).Scan(&objects)
+	assert.Equal(t, 1, objects)
+
+	_, err = app.collectBlobGarbage(context.Background(), false)
+	assert.Equal(t, nil, err)
+	app.DB.QueryRow(`SELECT COUNT(*) FROM blob_objects`).Scan(&objects)
+	assert.Equal(t, 0, objects)
+
+	req, _ = http.NewRequest("GET", "/api/admin/blob-gc/metrics", nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var metrics BlobGCMetrics
+	json.Unmarshal(response.Body.Bytes(), &metrics)
+	assert.Equal(t, 1, metrics.Runs)
+	assert.Equal(t, int64(len(content)), metrics.ReclaimedBytes)
+
+	// An object listed as collectible but uploaded again before its removal
+	// is kept
+	response = uploadTestFile(testUserToken1, "recording.webm", content)
+	assert.Equal(t, http.StatusOK, response.Code)
+	o := blobObject{}
+	err = app.DB.QueryRow(`SELECT bucket, hash, size FROM blob_objects`).Scan(&o.Bucket, &o.Hash, &o.Size)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, app.removeBlobObject(context.Background(), o))
+	app.DB.QueryRow(`SELECT COUNT(*) FROM blob_objects`).Scan(&objects)
+	assert.Equal(t, 1, objects)
+}
+
+func TestBlobStorageQuota(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	content := []byte("a screenshot")
+	response := uploadTestFile(testUserToken1, "screenshot.png", content)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var u User
+	json.Unmarshal(response.Body.Bytes(), &u)
+	assert.Equal(t, int64(len(content)), u.Quotas.Storage)
+	assert.Equal(t, STORAGE_LIMITS["free"], u.Quotas.StorageLimit)
+
+	isEligible, err := isEligibleToUpload(u.ID, "", 1024)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, isEligible)
+
+	isEligible, err = isEligibleToUpload(u.ID, "", STORAGE_LIMITS["free"])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, isEligible)
+}
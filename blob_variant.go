@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+type BlobVariant struct {
+	Name    string
+	MaxSize int // Longest side in pixels
+}
+
+// Variants generated for every uploaded image
+var BLOB_VARIANTS = [...]BlobVariant{
+	{Name: "thumb", MaxSize: 256},
+	{Name: "preview", MaxSize: 1024},
+}
+
+// Images bigger than this are not decoded to avoid exhausting the memory
+const MAX_VARIANT_SOURCE_PIXELS = 50000000
+
+func isImage(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+func isBlobVariant(name string) bool {
+	for _, v := range BLOB_VARIANTS {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// createBlobVariants scales the image down to every variant size and
+// stores them as derived blobs of the original.
+func (app *App) createBlobVariants(ctx context.Context, req *BlobData, data io.ReadSeeker) error {
+	config, _, err := image.DecodeConfig(data)
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > MAX_VARIANT_SOURCE_PIXELS {
+		return errors.New("image-too-large")
+	}
+	if _, err = data.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	src, _, err := image.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range BLOB_VARIANTS {
+		var buf bytes.Buffer
+		contentType := "image/png"
+		dst := resizeImage(src, v.MaxSize)
+		if req.ContentType == "image/jpeg" {
+			contentType = "image/jpeg"
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80})
+		} else {
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(buf.Bytes())
+		variant := BlobData{
+			ID:          req.ID,
+			Bucket:      req.Bucket,
+			Hash:        hex.EncodeToString(hash[:]),
+			ContentType: contentType,
+			Size:        int64(buf.Len()),
+		}
+		if err = app.putBlobVariant(ctx, &variant, v.Name, &buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) putBlobVariant(ctx context.Context, v *BlobData, name string, data io.Reader) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stored, err := app.retainObject(ctx, tx, v.Bucket, v.Hash, v.Size, v.ContentType, data)
+	if err == nil {
+		_, err = tx.Exec(`
+		INSERT INTO blob_variants (blob_id, variant, bucket, hash, content_type, size)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`, v.ID, name, v.Bucket, v.Hash, v.ContentType, v.Size)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		if stored {
+			app.discardStoredObject(ctx, v)
+		}
+	}
+	return err
+}
+
+// getBlobVariant replaces the content of the blob with its variant.
+func (b *BlobData) getBlobVariant(name string) error {
+	return app.DB.QueryRow(`
+	SELECT v.bucket, v.hash, v.content_type, v.size
+	FROM blob_variants v, blobs b
+	WHERE v.blob_id=b.id AND b.id::text=$1 AND v.variant=$2
+	AND b.deleted_at IS NULL
+	`,
+		b.ID, name).Scan(
+		&b.Bucket,
+		&b.Hash,
+		&b.ContentType,
+		&b.Size,
+	)
+}
+
+// resizeImage scales the image down so that its longest side fits in
+// maxSize, averaging the source pixels covered by each target pixel.
+// Smaller images keep their size.
+func resizeImage(src image.Image, maxSize int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSize && height <= maxSize {
+		return src
+	}
+	dstWidth, dstHeight := maxSize, maxSize
+	if width > height {
+		dstHeight = height * maxSize / width
+	} else {
+		dstWidth = width * maxSize / height
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0 := bounds.Min.Y + y*height/dstHeight
+		y1 := bounds.Min.Y + (y+1)*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0 := bounds.Min.X + x*width/dstWidth
+			x1 := bounds.Min.X + (x+1)*width/dstWidth
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
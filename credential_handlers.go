@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// register signs up a user of the local provider and sends the link to
+// verify the email address.
+func (app *App) register(w http.ResponseWriter, r *http.Request) {
+	if app.localAuthenticator() == nil {
+		respondError(w, http.StatusNotFound, "auth-provider-disabled")
+		return
+	}
+
+	var p Credential
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	verificationToken, err := p.registerCredential()
+	if err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-email-address", "invalid-password":
+			respondError(w, http.StatusBadRequest, err.Error())
+		case "email-address-taken":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	link := os.Getenv("APP_URL") + "/verify-email?token=" + url.QueryEscape(verificationToken)
+	err = sendEmailMessage(
+		[]string{p.EmailAddress},
+		"Verify your email address",
+		"Open this link to verify your email address at "+os.Getenv("APP_NAME")+": "+link,
+	)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusCreated, map[string]string{"result": "success"})
+}
+
+func (app *App) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	if app.localAuthenticator() == nil {
+		respondError(w, http.StatusNotFound, "auth-provider-disabled")
+		return
+	}
+
+	var p struct {
+		Token string `json:"token"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := verifyCredential(p.Token); err != nil {
+		log.Println(err)
+		if err.Error() == "invalid-verification-token" {
+			respondError(w, http.StatusBadRequest, err.Error())
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// login exchanges the email address and the password for a token of the
+// local provider.
+func (app *App) login(w http.ResponseWriter, r *http.Request) {
+	local := app.localAuthenticator()
+	if local == nil {
+		respondError(w, http.StatusNotFound, "auth-provider-disabled")
+		return
+	}
+
+	var p Credential
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if err := p.checkCredential(); err != nil {
+		log.Println(err)
+		switch {
+		case err == sql.ErrNoRows, err.Error() == "invalid-credentials":
+			respondError(w, http.StatusUnauthorized, "invalid-credentials")
+		case err.Error() == "email-not-verified":
+			respondError(w, http.StatusForbidden, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	token, expiresAt, err := local.issueToken(p.EmailAddress)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]interface{}{
+		"token":     token,
+		"expiresAt": expiresAt,
+	})
+}
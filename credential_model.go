@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MIN_PASSWORD_LENGTH = 8
+	// bcrypt ignores the bytes beyond
+	MAX_PASSWORD_LENGTH = 72
+)
+
+type Credential struct {
+	EmailAddress string `json:"emailAddress"`
+	Password     string `json:"password"`
+}
+
+func (c *Credential) validate() error {
+	c.EmailAddress = strings.ToLower(strings.TrimSpace(c.EmailAddress))
+	if !strings.Contains(c.EmailAddress, "@") {
+		return errors.New("invalid-email-address")
+	}
+	if len(c.Password) < MIN_PASSWORD_LENGTH || len(c.Password) > MAX_PASSWORD_LENGTH {
+		return errors.New("invalid-password")
+	}
+	return nil
+}
+
+// registerCredential stores the password hash and returns the token to
+// verify the email address with. Addresses that are not verified yet can be
+// registered again.
+func (c *Credential) registerCredential() (string, error) {
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(c.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	verificationToken, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := app.DB.Exec(`
+	INSERT INTO local_credentials (email_address, password_hash, verification_key, verification_sent_at)
+	VALUES ($1, $2, $3, NOW())
+	ON CONFLICT (email_address) DO UPDATE
+	SET password_hash=$2, verification_key=$3, verification_sent_at=NOW(), updated_at=NOW()
+	WHERE local_credentials.verified_at IS NULL
+	`,
+		c.EmailAddress, string(hash), hashToken(verificationToken))
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return "", errors.New("email-address-taken")
+	}
+	return verificationToken, nil
+}
+
+// verifyCredential marks the email address matching the token as verified.
+// Tokens are valid for a day.
+func verifyCredential(verificationToken string) error {
+	res, err := app.DB.Exec(`
+	UPDATE local_credentials SET verified_at=NOW(), verification_key=NULL, updated_at=NOW()
+	WHERE verification_key=$1 AND verified_at IS NULL
+	AND verification_sent_at > NOW() - INTERVAL '24 HOURS'
+	`, hashToken(verificationToken))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n < 1 {
+		return errors.New("invalid-verification-token")
+	}
+	return nil
+}
+
+// checkCredential compares the password with the stored hash.
+func (c *Credential) checkCredential() error {
+	c.EmailAddress = strings.ToLower(strings.TrimSpace(c.EmailAddress))
+	var hash string
+	var isVerified bool
+	err := app.DB.QueryRow(`
+	SELECT password_hash, verified_at IS NOT NULL FROM local_credentials WHERE email_address=$1
+	`, c.EmailAddress).Scan(&hash, &isVerified)
+	if err != nil {
+		return err
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(c.Password)); err != nil {
+		return errors.New("invalid-credentials")
+	}
+	if !isVerified {
+		return errors.New("email-not-verified")
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
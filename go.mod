@@ -18,5 +18,6 @@ require (
 	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.5.1
 	github.com/xendit/xendit-go v1.0.22
+	golang.org/x/crypto v0.0.0-20201216223049-8b5274cf687f
 	google.golang.org/api v0.40.0
 )
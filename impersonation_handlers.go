@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+// startImpersonation lets an admin act as a user, by sending the ID of the
+// impersonation in the X-Impersonate header until it expires.
+func (app *App) startImpersonation(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	var p Impersonation
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if _, err := uuidParser.Parse(p.UserID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	user := User{ID: p.UserID}
+	if err := user.getUser(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "user-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	p.AdminID = currentUser.ID
+
+	if err := p.createImpersonation(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-reason", "invalid-duration", "invalid-user":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+func (app *App) endImpersonation(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	p := Impersonation{ID: id}
+	if err = p.getImpersonation(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if err = p.endImpersonation(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getAuditLogs lists the impersonated requests, filtered by impersonationId
+// or adminId.
+func (app *App) getAuditLogs(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	count, _ := strconv.Atoi(r.FormValue("count"))
+	start, _ := strconv.Atoi(r.FormValue("start"))
+
+	if count > 100 || count < 1 {
+		count = 100
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	logs, err := getAuditLogs(r.FormValue("impersonationId"), r.FormValue("adminId"), count, start)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, logs)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// Duration of the impersonations, in minutes
+const (
+	IMPERSONATION_DEFAULT_MINUTES = 30
+	IMPERSONATION_MAX_MINUTES     = 240
+)
+
+// Methods allowed while impersonating, the others are destructive and
+// rejected unless allowed when starting it
+var IMPERSONATION_SAFE_METHODS = [...]string{
+	"GET",
+	"HEAD",
+}
+
+type Impersonation struct {
+	ID               string `json:"id"`
+	AdminID          string `json:"adminId"`
+	UserID           string `json:"userId"`
+	Reason           string `json:"reason"`
+	Minutes          int    `json:"minutes,omitempty"`
+	AllowDestructive bool   `json:"allowDestructive"`
+	ExpiresAt        string `json:"expiresAt"`
+	EndedAt          string `json:"endedAt"`
+	CreatedAt        string `json:"createdAt"`
+}
+
+type AuditLog struct {
+	ID              string `json:"id"`
+	ImpersonationID string `json:"impersonationId"`
+	AdminID         string `json:"adminId"`
+	UserID          string `json:"userId"`
+	Method          string `json:"method"`
+	Path            string `json:"path"`
+	Status          int    `json:"status"`
+	CreatedAt       string `json:"createdAt"`
+}
+
+func (p *Impersonation) validate() error {
+	if len(p.Reason) < 1 {
+		return errors.New("invalid-reason")
+	}
+	if p.Minutes == 0 {
+		p.Minutes = IMPERSONATION_DEFAULT_MINUTES
+	}
+	if p.Minutes < 0 || p.Minutes > IMPERSONATION_MAX_MINUTES {
+		return errors.New("invalid-duration")
+	}
+	if p.UserID == p.AdminID {
+		return errors.New("invalid-user")
+	}
+	return nil
+}
+
+func (p *Impersonation) createImpersonation() error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	return app.DB.QueryRow(`
+	INSERT INTO impersonations (admin_id, user_id, reason, allow_destructive, expires_at)
+	VALUES ($1, $2, $3, $4, NOW() + make_interval(mins => $5))
+	RETURNING id, expires_at, created_at
+	`,
+		p.AdminID, p.UserID, p.Reason, p.AllowDestructive, p.Minutes,
+	).Scan(&p.ID, &p.ExpiresAt, &p.CreatedAt)
+}
+
+func (p *Impersonation) getImpersonation() error {
+	var endedAt sql.NullString
+	err := app.DB.QueryRow(`
+	SELECT admin_id, user_id, reason, allow_destructive, expires_at, ended_at, created_at
+	FROM impersonations WHERE id::text=$1
+	`,
+		p.ID).Scan(
+		&p.AdminID,
+		&p.UserID,
+		&p.Reason,
+		&p.AllowDestructive,
+		&p.ExpiresAt,
+		&endedAt,
+		&p.CreatedAt,
+	)
+	p.EndedAt = endedAt.String
+	return err
+}
+
+// getActiveImpersonation loads an impersonation the admin has started, as
+// long as it has neither ended nor expired.
+func (p *Impersonation) getActiveImpersonation(adminID string) error {
+	var isActive bool
+	err := app.DB.QueryRow(`
+	SELECT user_id, allow_destructive, expires_at, created_at,
+	ended_at IS NULL AND expires_at > NOW()
+	FROM impersonations WHERE id::text=$1 AND admin_id=$2
+	`,
+		p.ID, adminID).Scan(
+		&p.UserID,
+		&p.AllowDestructive,
+		&p.ExpiresAt,
+		&p.CreatedAt,
+		&isActive,
+	)
+	if err == sql.ErrNoRows {
+		return errors.New("invalid-impersonation")
+	}
+	if err != nil {
+		return err
+	}
+	if !isActive {
+		return errors.New("impersonation-ended")
+	}
+	p.AdminID = adminID
+	return nil
+}
+
+func (p *Impersonation) endImpersonation() error {
+	_, err := app.DB.Exec(`
+	UPDATE impersonations SET ended_at=NOW() WHERE id=$1 AND ended_at IS NULL
+	`, p.ID)
+	return err
+}
+
+func (p *Impersonation) isBlocked(method string) bool {
+	if p.AllowDestructive {
+		return false
+	}
+	for _, m := range IMPERSONATION_SAFE_METHODS {
+		if m == method {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *AuditLog) createAuditLog() error {
+	_, err := app.DB.Exec(`
+	INSERT INTO audit_logs (impersonation_id, admin_id, user_id, method, path, status)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`,
+		l.ImpersonationID, l.AdminID, l.UserID, l.Method, l.Path, l.Status)
+	return err
+}
+
+// getAuditLogs lists the requests made during an impersonation, or by an
+// admin when impersonationId is empty.
+func getAuditLogs(impersonationId, adminId string, limit, offset int) ([]AuditLog, error) {
+	rows, err := app.DB.Query(`
+	SELECT id, impersonation_id, admin_id, user_id, method, path, status, created_at
+	FROM audit_logs
+	WHERE (NULLIF($1, '') IS NULL OR impersonation_id::text=$1)
+	AND (NULLIF($2, '') IS NULL OR admin_id=$2)
+	ORDER BY created_at DESC
+	LIMIT $3 OFFSET $4
+	`,
+		impersonationId, adminId, limit, offset)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	logs := []AuditLog{}
+
+	for rows.Next() {
+		var l AuditLog
+		if err := rows.Scan(
+			&l.ID,
+			&l.ImpersonationID,
+			&l.AdminID,
+			&l.UserID,
+			&l.Method,
+			&l.Path,
+			&l.Status,
+			&l.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (app *App) getInvitations(w http.ResponseWriter, r *http.Request) {
+	projectId := r.FormValue("projectId")
+	if !authorizeReference(w, r, projectId, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	invitations, err := getInvitations(projectId)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, invitations)
+}
+
+// createInvitation sends the link to accept the invitation to the invited
+// email address.
+func (app *App) createInvitation(w http.ResponseWriter, r *http.Request) {
+	var p Invitation
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !authorizeReference(w, r, p.ProjectID, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	p.InvitedBy = currentUser.ID
+
+	token, err := p.createInvitation()
+	if err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-email-address", "invalid-expiry", "invalid-access-level", "invalid-role":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if err = sendInvitation(&p, token, currentUser.EmailAddress); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+// sendInvitation emails the link to accept the invitation.
+func sendInvitation(i *Invitation, token, inviterEmail string) error {
+	link := os.Getenv("APP_URL") + "/invitation?token=" + url.QueryEscape(token)
+	return sendEmailMessage(
+		[]string{i.EmailAddress},
+		"You are invited to a project",
+		inviterEmail+" invited you to a project at "+os.Getenv("APP_NAME")+
+			". Open this link to join it before "+i.ExpiresAt+": "+link,
+	)
+}
+
+func (app *App) revokeInvitation(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	p := Invitation{ID: id}
+	if err = p.getInvitation(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if !authorize(w, r, p.ProjectID, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	if err = p.revokeInvitation(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invitation-used":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// acceptEmailInvitation grants the access of the invitation to the current
+// user, the token comes from the link sent by email.
+func (app *App) acceptEmailInvitation(w http.ResponseWriter, r *http.Request) {
+	var p struct {
+		Token string `json:"token"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	invitation, err := acceptInvitation(p.Token, currentUser)
+	if err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-invitation":
+			respondError(w, http.StatusNotFound, err.Error())
+		case "invitation-expired", "invitation-used", "invalid-role":
+			respondError(w, http.StatusGone, err.Error())
+		case "email-mismatch":
+			respondError(w, http.StatusForbidden, err.Error())
+		case "already-owner":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	recordActivity(r, invitation.ProjectID, ACTIVITY_COLLABORATOR, currentUser.ID, ACTIVITY_JOINED,
+		currentUser.EmailAddress+" ("+invitation.Access+")")
+
+	respond(w, http.StatusOK, invitation)
+}
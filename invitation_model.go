@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+)
+
+// Validity of the invitations, in days
+const (
+	INVITATION_DEFAULT_DAYS = 7
+	INVITATION_MAX_DAYS     = 30
+)
+
+type Invitation struct {
+	ID            string `json:"id"`
+	ProjectID     string `json:"projectId"`
+	EmailAddress  string `json:"emailAddress"`
+	Access        string `json:"access"`
+	RoleID        string `json:"roleId"`
+	InvitedBy     string `json:"invitedBy"`
+	ExpiresInDays int    `json:"expiresInDays,omitempty"`
+	ExpiresAt     string `json:"expiresAt"`
+	AcceptedAt    string `json:"acceptedAt"`
+	AcceptedBy    string `json:"acceptedBy"`
+	RevokedAt     string `json:"revokedAt"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+func (i *Invitation) validate() error {
+	i.EmailAddress = strings.ToLower(strings.TrimSpace(i.EmailAddress))
+	if !strings.Contains(i.EmailAddress, "@") {
+		return errors.New("invalid-email-address")
+	}
+	if i.ExpiresInDays == 0 {
+		i.ExpiresInDays = INVITATION_DEFAULT_DAYS
+	}
+	if i.ExpiresInDays < 0 || i.ExpiresInDays > INVITATION_MAX_DAYS {
+		return errors.New("invalid-expiry")
+	}
+	// Ownership is not given away by invitation
+	if i.Access == "OWNER" {
+		return errors.New("invalid-access-level")
+	}
+	access := Acl{
+		ObjectID: i.ProjectID,
+		Access:   i.Access,
+		RoleID:   i.RoleID,
+	}
+	if err := access.validate(); err != nil {
+		return err
+	}
+	i.RoleID = access.RoleID
+	return nil
+}
+
+// createInvitation returns the token to send, only its hash is stored.
+func (i *Invitation) createInvitation() (string, error) {
+	if err := i.validate(); err != nil {
+		return "", err
+	}
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	err = app.DB.QueryRow(`
+	INSERT INTO project_invitations
+	(project_id, email_address, access, role_id, token_hash, invited_by, expires_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NOW() + make_interval(days => $7))
+	RETURNING id, expires_at, created_at
+	`,
+		i.ProjectID, i.EmailAddress, i.Access, i.RoleID, hashToken(token), i.InvitedBy, i.ExpiresInDays,
+	).Scan(&i.ID, &i.ExpiresAt, &i.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+const invitationColumns = `
+	id, project_id, email_address, access, COALESCE(role_id, ''), invited_by,
+	expires_at, COALESCE(accepted_at::text, ''), COALESCE(accepted_by, ''),
+	COALESCE(revoked_at::text, ''), created_at
+`
+
+func (i *Invitation) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(
+		&i.ID,
+		&i.ProjectID,
+		&i.EmailAddress,
+		&i.Access,
+		&i.RoleID,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.AcceptedBy,
+		&i.RevokedAt,
+		&i.CreatedAt,
+	)
+}
+
+func (i *Invitation) getInvitation() error {
+	return i.scan(app.DB.QueryRow(`
+	SELECT `+invitationColumns+`
+	FROM project_invitations WHERE id::text=$1
+	`, i.ID))
+}
+
+// getInvitations lists the invitations of the project, the newest first.
+func getInvitations(projectId string) ([]Invitation, error) {
+	rows, err := app.DB.Query(`
+	SELECT `+invitationColumns+`
+	FROM project_invitations WHERE project_id=$1
+	ORDER BY created_at DESC
+	`, projectId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	invitations := []Invitation{}
+
+	for rows.Next() {
+		var i Invitation
+		if err := i.scan(rows); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	return invitations, nil
+}
+
+func (i *Invitation) revokeInvitation() error {
+	res, err := app.DB.Exec(`
+	UPDATE project_invitations SET revoked_at=NOW()
+	WHERE id=$1 AND revoked_at IS NULL AND accepted_at IS NULL
+	`, i.ID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked < 1 {
+		return errors.New("invitation-used")
+	}
+	return nil
+}
+
+// acceptInvitation grants the access of the invitation to the user, whose
+// email address must be the invited one. The invitation is claimed in the
+// same transaction, it can only be used once.
+func acceptInvitation(token string, user *User) (*Invitation, error) {
+	i := Invitation{}
+	var isExpired bool
+	err := app.DB.QueryRow(`
+	SELECT `+invitationColumns+`, expires_at <= NOW()
+	FROM project_invitations WHERE token_hash=$1
+	`, hashToken(token)).Scan(
+		&i.ID,
+		&i.ProjectID,
+		&i.EmailAddress,
+		&i.Access,
+		&i.RoleID,
+		&i.InvitedBy,
+		&i.ExpiresAt,
+		&i.AcceptedAt,
+		&i.AcceptedBy,
+		&i.RevokedAt,
+		&i.CreatedAt,
+		&isExpired,
+	)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("invalid-invitation")
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(i.RevokedAt) > 0:
+		return nil, errors.New("invalid-invitation")
+	case len(i.AcceptedAt) > 0:
+		return nil, errors.New("invitation-used")
+	case isExpired:
+		return nil, errors.New("invitation-expired")
+	case i.EmailAddress != strings.ToLower(user.EmailAddress):
+		return nil, errors.New("email-mismatch")
+	}
+
+	current := Acl{ObjectID: i.ProjectID, UserID: user.ID}
+	if err = current.getAccess(); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if current.Access == "OWNER" {
+		return nil, errors.New("already-owner")
+	}
+
+	// The role may have been deleted since
+	access := Acl{
+		ObjectID:   i.ProjectID,
+		ObjectType: "project",
+		UserID:     user.ID,
+		Access:     i.Access,
+		RoleID:     i.RoleID,
+	}
+	if err = access.validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	UPDATE project_invitations SET accepted_at=NOW(), accepted_by=$2
+	WHERE id=$1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+	`, i.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if claimed < 1 {
+		return nil, errors.New("invitation-used")
+	}
+	if err = access.setAccessTx(tx); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	i.AcceptedBy = user.ID
+	return &i, nil
+}
@@ -23,6 +23,8 @@ type Invoice struct {
 	PaymentDestination string    `json:"Payment_destination"`
 	PaidAt             time.Time `json:"paid_at"`
 	SubscriptionType   string    `json:"subscription_type"`
+	// Set when the invoice pays for an organization
+	OrganizationID string `json:"organization_id"`
 }
 
 func (i *Invoice) getInvoice() error {
@@ -46,6 +48,16 @@ func (i *Invoice) getInvoice() error {
 	)
 }
 
+func getInvoicePayerID(externalID string) (string, error) {
+	var userID string
+	err := app.DB.QueryRow(`
+	SELECT user_id FROM invoices WHERE external_id=$1
+	AND deleted_at IS NULL
+	`,
+		externalID).Scan(&userID)
+	return userID, err
+}
+
 func (i *Invoice) updateInvoice() error {
 	tx, err := app.DB.Begin()
 	if err != nil {
@@ -64,7 +76,7 @@ func (i *Invoice) updateInvoice() error {
 		paid_at=NOW(),
 		updated_at=NOW()
 	  WHERE (id::text=$1 OR external_id=$2)
-	  RETURNING user_id, description
+	  RETURNING user_id, description, COALESCE(organization_id, '')
 		`,
 			i.ID,
 			i.ExternalID,
@@ -73,19 +85,31 @@ func (i *Invoice) updateInvoice() error {
 			i.PaymentMethod,
 			i.PaymentChannel,
 			i.PaymentDestination,
-		).Scan(&i.UserID, &i.SubscriptionType)
+		).Scan(&i.UserID, &i.SubscriptionType, &i.OrganizationID)
 	if err != nil {
 		return err
 	}
-	_, err =
-		tx.Exec(`
-		UPDATE users SET
-		subscription_type=$2
-	  WHERE id::text=$1
-		`,
-			i.UserID,
-			i.SubscriptionType,
-		)
+	if len(i.OrganizationID) > 0 {
+		_, err =
+			tx.Exec(`
+			UPDATE organizations SET
+			subscription_type=$2
+		  WHERE id::text=$1
+			`,
+				i.OrganizationID,
+				i.SubscriptionType,
+			)
+	} else {
+		_, err =
+			tx.Exec(`
+			UPDATE users SET
+			subscription_type=$2
+		  WHERE id::text=$1
+			`,
+				i.UserID,
+				i.SubscriptionType,
+			)
+	}
 	if err != nil {
 		return err
 	}
@@ -108,8 +132,8 @@ func (i *Invoice) createInvoice() error {
 	items := string(jsonByte)
 	err := app.DB.QueryRow(`
 	INSERT INTO 
-	invoices(id, external_id, user_id, email_address, description, url, amount, status, items)
-	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+	invoices(id, external_id, user_id, email_address, description, url, amount, status, items, organization_id)
+	VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, '')) RETURNING id
 	`,
 		i.ID,
 		i.ExternalID,
@@ -120,6 +144,7 @@ func (i *Invoice) createInvoice() error {
 		i.Amount,
 		i.Status,
 		items,
+		i.OrganizationID,
 	).Scan(&i.ID)
 	if err != nil {
 		log.Println(err)
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Keys are fetched again after this delay, or earlier when a token is
+// signed by an unknown key
+const (
+	JWKS_CACHE_TTL       = time.Hour
+	JWKS_MIN_REFRESH_GAP = time.Minute
+)
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS is a set of public keys, RSA or ECDSA, loaded from a JSON Web Key
+// Set URL or file.
+type JWKS struct {
+	Source    string // URL or local path
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func NewJWKS(source string) *JWKS {
+	return &JWKS{Source: source}
+}
+
+// getKey returns the public key identified by kid, refreshing the set
+// when it is stale or does not know the key.
+func (s *JWKS) getKey(kid string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[kid]
+	isStale := time.Since(s.fetchedAt) > JWKS_CACHE_TTL
+	if ok && !isStale {
+		return key, nil
+	}
+	if isStale || time.Since(s.fetchedAt) > JWKS_MIN_REFRESH_GAP {
+		if err := s.refresh(); err != nil {
+			log.Println(err)
+			if !ok {
+				return nil, err
+			}
+			// Keep using the known key until the set can be fetched
+			return key, nil
+		}
+		key, ok = s.keys[kid]
+	}
+	if !ok {
+		return nil, errors.New("unknown-signing-key")
+	}
+	return key, nil
+}
+
+func (s *JWKS) refresh() error {
+	data, err := s.load()
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func (s *JWKS) load() ([]byte, error) {
+	if !strings.HasPrefix(s.Source, "http://") && !strings.HasPrefix(s.Source, "https://") {
+		return ioutil.ReadFile(s.Source)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(s.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("jwks-unavailable")
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseJWKS decodes the signing keys of a JSON Web Key Set by their ID.
+// Keys of other types or uses are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeJWKNumber(k.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeJWKNumber(k.E)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeJWKNumber(k.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeJWKNumber(k.Y)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	if len(keys) < 1 {
+		return nil, errors.New("empty-jwks")
+	}
+	return keys, nil
+}
+
+func decodeJWKNumber(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// verifyJWT checks a RS256 or ES256 token against the key set, along with
+// its issuer, audience and expiry, and returns its claims.
+func verifyJWT(idToken string, keys *JWKS, issuer, audience string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method {
+		case jwt.SigningMethodRS256, jwt.SigningMethodES256:
+		default:
+			return nil, errors.New("invalid-signing-method")
+		}
+		kid, _ := token.Header["kid"].(string)
+		return keys.getKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Expiry is checked by the parser, it is required here
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("invalid-token")
+	}
+	if !claims.VerifyIssuer(issuer, true) {
+		return nil, errors.New("invalid-issuer")
+	}
+	if !hasAudience(claims, audience) {
+		return nil, errors.New("invalid-audience")
+	}
+	return claims, nil
+}
+
+// hasAudience accepts a single audience or a list of audiences.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func claimTime(claims jwt.MapClaims, name string) int64 {
+	switch v := claims[name].(type) {
+	case float64:
+		return int64(v)
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	}
+	return 0
+}
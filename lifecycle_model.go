@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Soft-deletes cascade from an object to what depends on it, in a single
+// transaction:
+//
+//   - a project takes its scopes, scenarios, sessions and tests, the ACL
+//     rows on them and the ACL rows of its collaborators. The owner keeps
+//     their row to restore the project. Its attachments are hidden while it
+//     is deleted, and reclaimed by the blob GC once the retention is over.
+//   - a scope takes its scenarios.
+//   - a scenario is detached from the open sessions, with its tests there.
+//     Closed sessions are history, they keep the scenario and its results.
+//   - a session takes its tests. On restore, it is synced with what happened
+//     to its scenarios meanwhile, as if it had been open all along.
+//
+// Everything deleted along shares the deleted_at of the object, a restore
+// brings back what shares it. What was deleted on its own before stays in
+// the recycle bin.
+
+// SESSION_STATUS_OPEN is the status of the sessions still being tested.
+const SESSION_STATUS_OPEN = 0
+
+// lifecycleDependent selects rows deleted and restored along with an
+// object, by a condition on the ID of the object, $1. The condition may use
+// the deletion time, $2.
+type lifecycleDependent struct {
+	table string
+	where string
+}
+
+var LIFECYCLE_DEPENDENTS = map[string][]lifecycleDependent{
+	"project": {
+		{"scopes", "project_id::text=$1"},
+		{"scenarios", "project_id::text=$1"},
+		{"sessions", "project_id::text=$1"},
+		{"tests", "session_id IN (SELECT id FROM sessions WHERE project_id::text=$1)"},
+		{"access_control_lists", `(object_id=$1 AND access!='OWNER') OR object_id IN (
+		  SELECT id::text FROM scopes WHERE project_id::text=$1
+		  UNION ALL SELECT id::text FROM scenarios WHERE project_id::text=$1
+		  UNION ALL SELECT id::text FROM sessions WHERE project_id::text=$1
+		  UNION ALL SELECT t.id::text FROM tests t, sessions s WHERE t.session_id=s.id AND s.project_id::text=$1
+		)`},
+	},
+	"scope": {
+		{"scenarios", "scope_id::text=$1"},
+		{"access_control_lists", "object_id=$1"},
+	},
+	"scenario": {
+		{"tests", `scenario_id::text=$1 AND session_id IN (
+		  SELECT session_id FROM session_detached_scenarios WHERE scenario_id=$1 AND detached_at=$2
+		)`},
+		{"access_control_lists", `object_id=$1 OR object_id IN (
+		  SELECT id::text FROM tests WHERE scenario_id::text=$1 AND session_id IN (
+		    SELECT session_id FROM session_detached_scenarios WHERE scenario_id=$1 AND detached_at=$2
+		  )
+		)`},
+	},
+	"session": {
+		{"tests", "session_id::text=$1"},
+		{"access_control_lists", "object_id=$1 OR object_id IN (SELECT id::text FROM tests WHERE session_id::text=$1)"},
+	},
+}
+
+type lifecycle struct {
+	tx        *sql.Tx
+	deletedAt time.Time
+	deletedBy string
+	restoring bool
+}
+
+// deleteObject moves the object to the recycle bin with its dependents.
+// Objects already deleted are sql.ErrNoRows.
+func deleteObject(objectType, objectID, deletedBy string) error {
+	if _, ok := LIFECYCLE_DEPENDENTS[objectType]; !ok {
+		return errors.New("invalid-object-type")
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	l := lifecycle{tx: tx, deletedBy: deletedBy}
+	err = tx.QueryRow(`
+	UPDATE `+objectType+`s SET deleted_at=NOW(), deleted_by=$2
+	WHERE id::text=$1 AND deleted_at IS NULL
+	RETURNING deleted_at
+	`, objectID, deletedBy).Scan(&l.deletedAt)
+	if err != nil {
+		return err
+	}
+	if err = l.cascade(objectType, objectID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// restoreObject restores a deleted item with the dependents deleted along
+// with it. Items of a deleted parent are "parent-deleted", the parent is
+// restored first.
+func restoreObject(objectID string) error {
+	objectType, parentID, err := getParentObject(objectID)
+	if err == sql.ErrNoRows {
+		objectType = "project"
+	} else if err != nil {
+		return err
+	}
+	if _, ok := LIFECYCLE_DEPENDENTS[objectType]; !ok {
+		return sql.ErrNoRows
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var parentDeleted bool
+	switch objectType {
+	case "scope", "session":
+		err = tx.QueryRow(`
+		SELECT deleted_at IS NOT NULL FROM projects WHERE id::text=$1
+		`, parentID).Scan(&parentDeleted)
+	case "scenario":
+		err = tx.QueryRow(`
+		SELECT deleted_at IS NOT NULL FROM scopes WHERE id::text=$1
+		`, parentID).Scan(&parentDeleted)
+	}
+	if err != nil {
+		return err
+	}
+	if parentDeleted {
+		return errors.New("parent-deleted")
+	}
+
+	l := lifecycle{tx: tx, restoring: true}
+	err = tx.QueryRow(`
+	SELECT deleted_at FROM `+objectType+`s WHERE id::text=$1 AND deleted_at IS NOT NULL
+	FOR UPDATE
+	`, objectID).Scan(&l.deletedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE `+objectType+`s SET deleted_at=NULL, deleted_by=NULL WHERE id::text=$1
+	`, objectID)
+	if err != nil {
+		return err
+	}
+	if err = l.cascade(objectType, objectID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// cascade deletes or restores the dependents of the object.
+func (l *lifecycle) cascade(objectType, objectID string) error {
+	// The scenarios of a scope are detached from the sessions one by one,
+	// listed before they change state
+	var scenarioIDs []string
+	if objectType == "scope" {
+		var err error
+		if scenarioIDs, err = l.getScopeScenarios(objectID); err != nil {
+			return err
+		}
+	}
+
+	if objectType == "scenario" && !l.restoring {
+		if err := l.detachScenario(objectID); err != nil {
+			return err
+		}
+	}
+	for _, d := range LIFECYCLE_DEPENDENTS[objectType] {
+		if err := l.update(d, objectID); err != nil {
+			return err
+		}
+	}
+	if objectType == "scenario" && l.restoring {
+		if err := l.attachScenario(objectID); err != nil {
+			return err
+		}
+	}
+	if objectType == "session" && l.restoring {
+		if err := l.syncScenarios(objectID); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range scenarioIDs {
+		if err := l.cascade("scenario", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *lifecycle) update(d lifecycleDependent, objectID string) error {
+	var err error
+	if l.restoring {
+		_, err = l.tx.Exec(`
+		UPDATE `+d.table+` SET deleted_at=NULL, deleted_by=NULL
+		WHERE deleted_at=$2 AND (`+d.where+`)
+		`, objectID, l.deletedAt)
+	} else {
+		_, err = l.tx.Exec(`
+		UPDATE `+d.table+` SET deleted_at=$2, deleted_by=$3
+		WHERE deleted_at IS NULL AND (`+d.where+`)
+		`, objectID, l.deletedAt, l.deletedBy)
+	}
+	return err
+}
+
+// getScopeScenarios lists the scenarios of the scope changing along with it,
+// the live ones on delete and the ones deleted along on restore.
+func (l *lifecycle) getScopeScenarios(scopeID string) ([]string, error) {
+	var deletedAt interface{}
+	if l.restoring {
+		deletedAt = l.deletedAt
+	}
+	rows, err := l.tx.Query(`
+	SELECT id::text FROM scenarios WHERE scope_id::text=$1 AND deleted_at IS NOT DISTINCT FROM $2
+	`, scopeID, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// detachScenario removes the scenario from the open sessions, remembering
+// them to put it back on restore.
+func (l *lifecycle) detachScenario(scenarioID string) error {
+	_, err := l.tx.Exec(`
+	INSERT INTO session_detached_scenarios (session_id, scenario_id, detached_at)
+	SELECT id, $1, $2 FROM sessions
+	WHERE $1=ANY(scenarios) AND status=$3 AND deleted_at IS NULL
+	ON CONFLICT (session_id, scenario_id) DO UPDATE SET detached_at=$2
+	`, scenarioID, l.deletedAt, SESSION_STATUS_OPEN)
+	if err != nil {
+		return err
+	}
+	_, err = l.tx.Exec(`
+	UPDATE sessions SET scenarios=array_remove(scenarios, $1), updated_at=NOW()
+	WHERE id IN (
+	  SELECT session_id FROM session_detached_scenarios WHERE scenario_id=$1 AND detached_at=$2
+	)
+	`, scenarioID, l.deletedAt)
+	return err
+}
+
+// attachScenario puts the scenario back in the sessions it was detached
+// from, those still open. Deleted sessions keep the record, they take the
+// scenario back when restored.
+func (l *lifecycle) attachScenario(scenarioID string) error {
+	_, err := l.tx.Exec(`
+	UPDATE sessions SET scenarios=array_append(scenarios, $1), updated_at=NOW()
+	WHERE status=$3 AND deleted_at IS NULL AND NOT COALESCE($1=ANY(scenarios), false) AND id IN (
+	  SELECT session_id FROM session_detached_scenarios WHERE scenario_id=$1 AND detached_at=$2
+	)
+	`, scenarioID, l.deletedAt, SESSION_STATUS_OPEN)
+	if err != nil {
+		return err
+	}
+	_, err = l.tx.Exec(`
+	DELETE FROM session_detached_scenarios WHERE scenario_id=$1 AND detached_at=$2
+	AND session_id NOT IN (SELECT id FROM sessions WHERE deleted_at IS NOT NULL)
+	`, scenarioID, l.deletedAt)
+	return err
+}
+
+// syncScenarios catches a restored session up with its scenarios: the ones
+// deleted while it was deleted are detached with their tests, as on the
+// deletion of the scenario, and the ones restored meanwhile are attached.
+func (l *lifecycle) syncScenarios(sessionID string) error {
+	_, err := l.tx.Exec(`
+	INSERT INTO session_detached_scenarios (session_id, scenario_id, detached_at)
+	SELECT s.id, c.id::text, c.deleted_at FROM sessions s, scenarios c
+	WHERE s.id::text=$1 AND s.status=$2 AND c.id::text=ANY(s.scenarios) AND c.deleted_at IS NOT NULL
+	ON CONFLICT (session_id, scenario_id) DO UPDATE SET detached_at=EXCLUDED.detached_at
+	`, sessionID, SESSION_STATUS_OPEN)
+	if err != nil {
+		return err
+	}
+
+	// The tests share the deletion of their scenario, to come back with it
+	_, err = l.tx.Exec(`
+	UPDATE access_control_lists a SET deleted_at=c.deleted_at, deleted_by=c.deleted_by
+	FROM tests t, scenarios c, session_detached_scenarios d
+	WHERE a.object_id=t.id::text AND a.deleted_at IS NULL AND t.deleted_at IS NULL
+	AND t.session_id::text=$1 AND t.scenario_id=c.id AND c.deleted_at IS NOT NULL
+	AND d.session_id=t.session_id AND d.scenario_id=c.id::text AND d.detached_at=c.deleted_at
+	`, sessionID)
+	if err != nil {
+		return err
+	}
+	_, err = l.tx.Exec(`
+	UPDATE tests t SET deleted_at=c.deleted_at, deleted_by=c.deleted_by
+	FROM scenarios c, session_detached_scenarios d
+	WHERE t.deleted_at IS NULL AND t.session_id::text=$1 AND t.scenario_id=c.id AND c.deleted_at IS NOT NULL
+	AND d.session_id=t.session_id AND d.scenario_id=c.id::text AND d.detached_at=c.deleted_at
+	`, sessionID)
+	if err != nil {
+		return err
+	}
+
+	// The scenarios restored meanwhile come back, those deleted again stay
+	// detached
+	_, err = l.tx.Exec(`
+	UPDATE sessions s SET scenarios=ARRAY(
+	  SELECT x FROM unnest(s.scenarios) x WHERE x NOT IN (
+	    SELECT d.scenario_id FROM session_detached_scenarios d, scenarios c
+	    WHERE d.session_id=s.id AND c.id::text=d.scenario_id AND c.deleted_at IS NOT NULL
+	  )
+	) || ARRAY(
+	  SELECT d.scenario_id FROM session_detached_scenarios d, scenarios c
+	  WHERE d.session_id=s.id AND c.id::text=d.scenario_id AND c.deleted_at IS NULL
+	  AND NOT COALESCE(d.scenario_id=ANY(s.scenarios), false)
+	), updated_at=NOW()
+	WHERE s.id::text=$1 AND s.status=$2
+	`, sessionID, SESSION_STATUS_OPEN)
+	if err != nil {
+		return err
+	}
+	_, err = l.tx.Exec(`
+	DELETE FROM session_detached_scenarios d USING scenarios c
+	WHERE d.session_id::text=$1 AND c.id::text=d.scenario_id AND c.deleted_at IS NULL
+	`, sessionID)
+	return err
+}
@@ -8,32 +8,231 @@ import (
 	"net/http"
 	"strings"
 
-	"github.com/gorilla/mux"
+	uuidParser "github.com/docker/distribution/uuid"
 )
 
 var PUBLIC_ENDPOINTS = [...]string{
 	"/api/payments/callback", // Called by Xendit payment
-	"/api/invite",
+	"/api/invite/",
+	"/api/auth/", // Local email/password provider
 	"/static",
 }
 
-var PRIVATE_ENDPOINTS = [...]string{
-	"/",
-	"/api/invite",
+func getBearerToken(r *http.Request) string {
+	token := r.Header.Get("Authorization")
+	if strings.Contains(token, "earer") {
+		token = strings.Split(token, "earer ")[1]
+	}
+	return token
+}
+
+// statusRecorder keeps the status of the response, for the audit log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func isAdmin(r *http.Request) bool {
+	currentUser := r.Context().Value("currentUser")
+	return currentUser != nil && currentUser.(*User).Role == "ADMIN"
+}
+
+// authorize checks that the current user has the permission on the object,
+// inherited from its project or granted directly. It responds with an error
+// and returns false when the permission is not granted. Archived projects
+// are read-only, only the view permissions are granted on them.
+func authorize(w http.ResponseWriter, r *http.Request, objectID, permission string) bool {
+	if !authorizeAccess(w, r, objectID, permission) {
+		return false
+	}
+	if permission == PERMISSION_VIEW || permission == PERMISSION_VIEW_BILLING {
+		return true
+	}
+
+	projectID, err := getProjectOf(objectID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	p := Project{ID: projectID}
+	isArchived, err := p.isArchived()
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if isArchived {
+		log.Println("ARCHIVED", permission, objectID)
+		respondError(w, http.StatusForbidden, "project-archived")
+		return false
+	}
+	return true
+}
+
+// authorizeAccess checks the permission like authorize, archived projects
+// included.
+func authorizeAccess(w http.ResponseWriter, r *http.Request, objectID, permission string) bool {
+	currentUser := r.Context().Value("currentUser")
+	if currentUser == nil {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	if apiKey := getRequestApiKey(r); apiKey != nil && len(apiKey.ProjectID) > 0 {
+		// Project keys are bound to their project, even for admins
+		projectID, err := getProjectOf(objectID)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return false
+		}
+		if projectID != apiKey.ProjectID {
+			log.Println("FORBIDDEN", "api key of", apiKey.ProjectID, objectID)
+			respondError(w, http.StatusForbidden, "forbidden")
+			return false
+		}
+	}
+	if currentUser.(*User).Role == "ADMIN" {
+		// Admin can do anything, skip ACL
+		return true
+	}
+
+	access := Acl{
+		ObjectID: objectID,
+		UserID:   currentUser.(*User).ID,
+	}
+	isGranted, err := access.hasPermission(permission)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !isGranted {
+		log.Println("FORBIDDEN", permission, objectID)
+		respondError(w, http.StatusForbidden, "forbidden")
+		return false
+	}
+	return true
+}
+
+// authorizeGrant checks that the current user holds every permission on the
+// project before granting them to someone, themselves included, so that
+// managing the collaborators does not give more than the manager has.
+// Admins hold them all.
+func authorizeGrant(w http.ResponseWriter, r *http.Request, projectID string, permissions []string) bool {
+	currentUser := r.Context().Value("currentUser").(*User)
+	if currentUser.Role == "ADMIN" {
+		return true
+	}
+
+	access := Acl{
+		ObjectID: projectID,
+		UserID:   currentUser.ID,
+	}
+	held := []string{}
+	err := access.resolveAccess()
+	if err == nil {
+		held, err = access.getPermissions()
+	}
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	for _, permission := range permissions {
+		if !isValidPermission(permission) {
+			// Left for the validation to reject
+			continue
+		}
+		isHeld := false
+		for _, p := range held {
+			if p == permission {
+				isHeld = true
+				break
+			}
+		}
+		if !isHeld {
+			log.Println("FORBIDDEN", "grant", permission, projectID)
+			respondError(w, http.StatusForbidden, "permission-not-held")
+			return false
+		}
+	}
+	return true
+}
+
+// authorizeOrganization checks that the current user is a member of the
+// organization with one of the roles, and returns the role. Admins act as
+// owners.
+func authorizeOrganization(w http.ResponseWriter, r *http.Request, organizationID string, roles ...string) (string, bool) {
+	if _, err := uuidParser.Parse(organizationID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return "", false
+	}
+	currentUser := r.Context().Value("currentUser")
+	if currentUser == nil {
+		respondError(w, http.StatusUnauthorized, "unauthorized")
+		return "", false
+	}
+	if apiKey := getRequestApiKey(r); apiKey != nil && len(apiKey.ProjectID) > 0 {
+		log.Println("FORBIDDEN", "api key of", apiKey.ProjectID, organizationID)
+		respondError(w, http.StatusForbidden, "forbidden")
+		return "", false
+	}
+	if currentUser.(*User).Role == "ADMIN" {
+		return ORG_ROLE_OWNER, true
+	}
+
+	role, err := getOrganizationRole(organizationID, currentUser.(*User).ID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return "", false
+	}
+	for _, r := range roles {
+		if r == role {
+			return role, true
+		}
+	}
+	log.Println("FORBIDDEN", "organization", organizationID)
+	respondError(w, http.StatusForbidden, "forbidden")
+	return "", false
+}
+
+// authorizeReference checks the permission on an object referenced by a
+// query parameter or the request body, where the ID is not validated by the
+// route.
+func authorizeReference(w http.ResponseWriter, r *http.Request, objectID, permission string) bool {
+	if _, err := uuidParser.Parse(objectID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return false
+	}
+	return authorize(w, r, objectID, permission)
 }
 
 // Generic middleware
 func Middleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, SCIM_PATH_PREFIX) {
+			// The identity provider has its own token, see authorizeSCIM()
+			h.ServeHTTP(w, r)
+			return
+		}
 
-		isForbidden := false
 		isUnauthorized := false
-		// Authentication
-		token := r.Header.Get("Authorization")
-		if strings.Contains(token, "earer") {
-			token = strings.Split(token, "earer ")[1]
+		// Authentication, with an ID token or an API key
+		var currentUser *User
+		var apiKey *ApiKey
+		var err error
+		if token := getBearerToken(r); isApiKey(token) {
+			currentUser, apiKey, err = authenticateApiKey(token)
+		} else {
+			currentUser, err = app.authenticateIDToken(token)
 		}
-		currentUser, err := app.authenticateIDToken(token)
 		if err != nil {
 			log.Println(err)
 			isUnauthorized = true
@@ -53,6 +252,7 @@ func Middleware(h http.Handler) http.Handler {
 			if currentUser != nil {
 				log.Println("with user context")
 				ctx := context.WithValue(r.Context(), "currentUser", currentUser)
+				ctx = context.WithValue(ctx, "apiKey", apiKey)
 				h.ServeHTTP(w, r.WithContext(ctx))
 			} else {
 				log.Println("without user context")
@@ -62,94 +262,46 @@ func Middleware(h http.Handler) http.Handler {
 			return
 		}
 
-		if isUnauthorized {
-			respondError(w, http.StatusUnauthorized, "unauthorized")
+		if isUnauthorized || currentUser == nil {
+			// Failed attempts count against the IP address, guessing
+			// credentials is limited like any anonymous request
+			if app.RateLimiter != nil && !app.RateLimiter.takeAnonymous(w, r) {
+				return
+			}
+			// Let the client know it has to sign in again
+			if err != nil && (err.Error() == "expired-token" || err.Error() == "revoked-token" ||
+				err.Error() == "expired-api-key" || err.Error() == "invalid-api-key") {
+				respondError(w, http.StatusUnauthorized, err.Error())
+			} else {
+				respondError(w, http.StatusUnauthorized, "unauthorized")
+			}
 			return
 		}
 
-		// Access control list by object id
-		vars := mux.Vars(r)
-		id := vars["id"]
-		if currentUser == nil {
-			respondError(w, http.StatusUnauthorized, "unauthorized")
+		if apiKey != nil && !apiKey.allows(r) {
+			log.Println("FORBIDDEN", r.Method, r.URL.Path, "with api key", apiKey.ID)
+			respondError(w, http.StatusForbidden, "api-key-scope")
 			return
 		}
-		if currentUser.Role == "ADMIN" {
-			// Admin can do anything, skip ACL
-			log.Println(r.Method, r.URL.Path, "as", currentUser.EmailAddress, currentUser.Role) // Route log
-		} else if currentUser.Role == "USER" && len(id) > 0 &&
-			// Applied ACL
-			(r.Method == "GET" ||
-				r.Method == "PUT" ||
-				r.Method == "DELETE") {
-
-			access := Acl{
-				ObjectID: id,
-				UserID:   currentUser.ID,
-			}
-			err := access.getAccess()
-			if err != nil {
-				log.Println(err)
-				if err == sql.ErrNoRows {
-					log.Println("Forbidden 1")
-					isForbidden = true
-				} else {
-					respondError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-			}
 
-			log.Println(r.Method, r.URL.Path, "as", currentUser.EmailAddress, currentUser.Role, access.Access) // Route log
-
-			switch r.Method {
-			case "GET":
-				if !(access.Access == "READ" ||
-					access.Access == "MODIFY" ||
-					access.Access == "OWNER") {
-					log.Println("Forbidden 2")
-					isForbidden = true
-				}
-			case "PUT":
-				if !(access.Access == "MODIFY" ||
-					access.Access == "OWNER") {
-					log.Println("Forbidden 3")
-					isForbidden = true
-				}
-			case "DELETE":
-				if !(access.Access == "OWNER") {
-					log.Println("Forbidden 4")
-					isForbidden = true
-				}
-			default:
-				log.Println(r.Method)
+		// An admin acting as another user, with the ACL of that user
+		if impersonationID := r.Header.Get("X-Impersonate"); len(impersonationID) > 0 {
+			if apiKey != nil || currentUser.Role != "ADMIN" {
+				respondError(w, http.StatusForbidden, "forbidden")
+				return
 			}
-		} else {
-			// Other cases those are not covered by ACL
-			log.Println(r.Method, r.URL.Path, "as", currentUser.EmailAddress, currentUser.Role) // Route log
+			impersonate(h, w, r, currentUser, impersonationID)
+			return
 		}
 
+		// Access control is applied by the handlers, see authorize()
+		log.Println(r.Method, r.URL.Path, "as", currentUser.EmailAddress, currentUser.Role) // Route log
+
 		jsonBytes, _ := json.Marshal(currentUser)
 		log.Println(string(jsonBytes))
 		// Pass current user into the context
 		ctx := context.WithValue(r.Context(), "currentUser", currentUser)
-
-		ignore := false
-		for _, endpoint := range PRIVATE_ENDPOINTS {
-			if strings.Contains(r.URL.Path, endpoint) {
-				ignore = true
-				break
-			}
-		}
-
-		if ignore {
-			log.Println(r.Method, r.URL.Path) // Route log
-			h.ServeHTTP(w, r.WithContext(ctx))
-			return
-		} else if isForbidden {
-			log.Println("FORBIDDEN")
-			respondError(w, http.StatusForbidden, "forbidden")
-			return
-		}
+		ctx = context.WithValue(ctx, "apiKey", apiKey)
 
 		/* Example on how to consume the context
 
@@ -165,3 +317,55 @@ func Middleware(h http.Handler) http.Handler {
 
 	})
 }
+
+// impersonate serves the request as the impersonated user and records it in
+// the audit log, along with the admin.
+func impersonate(h http.Handler, w http.ResponseWriter, r *http.Request, admin *User, impersonationID string) {
+	impersonation := Impersonation{ID: impersonationID}
+	if err := impersonation.getActiveImpersonation(admin.ID); err != nil {
+		log.Println(err)
+		if err.Error() == "invalid-impersonation" || err.Error() == "impersonation-ended" {
+			respondError(w, http.StatusForbidden, err.Error())
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	user := User{ID: impersonation.UserID}
+	if err := user.getUser(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusForbidden, "invalid-impersonation")
+		return
+	}
+	quotas, err := getUserQuotas(user.ID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	user.Quotas = quotas
+
+	log.Println(r.Method, r.URL.Path, "as", user.EmailAddress, "impersonated by", admin.EmailAddress) // Route log
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if impersonation.isBlocked(r.Method) {
+		respondError(recorder, http.StatusForbidden, "impersonation-read-only")
+	} else {
+		ctx := context.WithValue(r.Context(), "currentUser", &user)
+		ctx = context.WithValue(ctx, "impersonator", admin)
+		h.ServeHTTP(recorder, r.WithContext(ctx))
+	}
+
+	auditLog := AuditLog{
+		ImpersonationID: impersonation.ID,
+		AdminID:         admin.ID,
+		UserID:          user.ID,
+		Method:          r.Method,
+		Path:            r.URL.RequestURI(),
+		Status:          recorder.status,
+	}
+	if err := auditLog.createAuditLog(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	ROUTE_PUBLIC = "public" // Anyone
+	ROUTE_USER   = "user"   // Any signed in user
+	ROUTE_MEMBER = "member" // Users with a permission on the object
+)
+
+func createTestObject(t *testing.T, token, path, body string) map[string]interface{} {
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer([]byte(body)))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code, path)
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+	return m
+}
+
+func TestAuthorizationRoutes(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	// Everything belongs to the first user
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	scope := createTestObject(t, testUserToken1, "/api/scope",
+		`{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	scenario := createTestObject(t, testUserToken1, "/api/scenario",
+		`{"name":"test scenario","projectId":"`+projectId+`","scopeId":"`+scopeId+`","steps":[]}`)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+	session := createTestObject(t, testUserToken1, "/api/session",
+		`{"version":"1.0","projectId":"`+projectId+`","scenarios":[{"id":"`+scenarioId+`"}]}`)
+	sessionId := fmt.Sprintf("%s", session["id"])
+	test := createTestObject(t, testUserToken1, "/api/test",
+		`{"sessionId":"`+sessionId+`","scenarioId":"`+scenarioId+`"}`)
+	testId := fmt.Sprintf("%s", test["id"])
+	role := createTestObject(t, testUserToken1, "/api/role",
+		`{"name":"Tester","projectId":"`+projectId+`","permissions":["view"]}`)
+	roleId := fmt.Sprintf("%s", role["id"])
+	apiKey := createTestObject(t, testUserToken1, "/api/api-key", `{"name":"ci","scope":"read-only"}`)
+	apiKeyId := fmt.Sprintf("%s", apiKey["id"])
+	invitation := createTestObject(t, testUserToken1, "/api/invitation",
+		`{"emailAddress":"invitee@example.com","access":"READ","projectId":"`+projectId+`"}`)
+	invitationId := fmt.Sprintf("%s", invitation["id"])
+	organization := createTestObject(t, testUserToken1, "/api/organization", `{"name":"test organization"}`)
+	organizationId := fmt.Sprintf("%s", organization["id"])
+	team := createTestObject(t, testUserToken1, "/api/team", `{"name":"Mobile QA"}`)
+	teamId := fmt.Sprintf("%s", team["id"])
+
+	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	var owner map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &owner)
+	userId := fmt.Sprintf("%s", owner["id"])
+
+	response = uploadTestFile(testUserToken1, "screenshot.png", []byte("screenshot"))
+	assert.Equal(t, http.StatusOK, response.Code)
+	var blob map[string]string
+	json.Unmarshal(response.Body.Bytes(), &blob)
+	blobId := blob["ID"]
+
+	_, err := app.DB.Exec(`
+	INSERT INTO invoices (external_id, user_id, url, status) VALUES ('test-invoice', $1, '', 'PENDING')
+	`, userId)
+	assert.Equal(t, nil, err)
+
+	var transferId string
+	err = app.DB.QueryRow(`
+	INSERT INTO ownership_transfers (project_id, from_user_id, to_user_id) VALUES ($1, $2, $2) RETURNING id
+	`, projectId, userId).Scan(&transferId)
+	assert.Equal(t, nil, err)
+
+	// Upload into the project
+	upload := &bytes.Buffer{}
+	writer := multipart.NewWriter(upload)
+	writer.WriteField("projectId", projectId)
+	part, _ := writer.CreateFormFile("file", "recording.webm")
+	part.Write([]byte("recording"))
+	writer.Close()
+
+	routes := []struct {
+		Method      string
+		Template    string
+		Path        string
+		Body        string
+		ContentType string
+		Access      string
+	}{
+		{"GET", "/api/projects", "/api/projects", "", "", ROUTE_USER},
+		{"POST", "/api/project", "/api/project", "", "", ROUTE_USER},
+		{"GET", "/api/project/{id}", "/api/project/" + projectId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/project/{id}", "/api/project/" + projectId, `{"name":"renamed"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/project/{id}", "/api/project/" + projectId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/project-archive/{id}", "/api/project-archive/" + projectId, "", "", ROUTE_MEMBER},
+		{"DELETE", "/api/project-archive/{id}", "/api/project-archive/" + projectId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/recycle-bin/{id}", "/api/recycle-bin/" + projectId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/activities", "/api/activities?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/restore/{id}", "/api/restore/" + scopeId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/project-export/{id}", "/api/project-export/" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/project-import", "/api/project-import", "", "", ROUTE_USER},
+		{"PUT", "/api/project-template/{id}", "/api/project-template/" + projectId, "", "", ROUTE_MEMBER},
+		{"DELETE", "/api/project-template/{id}", "/api/project-template/" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/project-duplicate/{id}", "/api/project-duplicate/" + projectId, "{}", "", ROUTE_MEMBER},
+		{"GET", "/api/invite/{id}", "/api/invite/" + projectId, "", "", ROUTE_PUBLIC},
+		{"PUT", "/api/invite/{id}", "/api/invite/" + projectId, "", "", ROUTE_PUBLIC},
+		{"PUT", "/api/invite-settings/{id}", "/api/invite-settings/" + projectId, `{"inviteEnabled":true,"inviteAccess":"READ"}`, "", ROUTE_MEMBER},
+		{"PUT", "/api/rotate-invite/{id}", "/api/rotate-invite/" + projectId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/collaborators/{id}", "/api/collaborators/" + projectId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/revoke/{projectId}/{userId}", "/api/revoke/" + projectId + "/" + userId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/collaborator-access/{projectId}/{userId}", "/api/collaborator-access/" + projectId + "/" + userId, `{"access":"READ"}`, "", ROUTE_MEMBER},
+		{"GET", "/api/ownership-transfers", "/api/ownership-transfers?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/ownership-transfer", "/api/ownership-transfer", `{"projectId":"` + projectId + `","toUserId":"` + userId + `"}`, "", ROUTE_MEMBER},
+		{"PUT", "/api/ownership-transfer/{id}", "/api/ownership-transfer/" + transferId, "", "", ROUTE_MEMBER},
+		{"DELETE", "/api/ownership-transfer/{id}", "/api/ownership-transfer/" + transferId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/access-override/{id}/{userId}", "/api/access-override/" + scenarioId + "/" + userId, `{"access":"READ"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/access-override/{id}/{userId}", "/api/access-override/" + scenarioId + "/" + userId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/invitations", "/api/invitations?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/invitation", "/api/invitation", `{"emailAddress":"invitee@example.com","access":"READ","projectId":"` + projectId + `"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/invitation/{id}", "/api/invitation/" + invitationId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/accept-invitation", "/api/accept-invitation", `{"token":"invalid"}`, "", ROUTE_USER},
+		{"GET", "/api/organizations", "/api/organizations", "", "", ROUTE_USER},
+		{"POST", "/api/organization", "/api/organization", `{"name":"organization"}`, "", ROUTE_USER},
+		{"GET", "/api/organization/{id}", "/api/organization/" + organizationId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/organization/{id}", "/api/organization/" + organizationId, `{"name":"renamed"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/organization/{id}", "/api/organization/" + organizationId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/organization-members/{id}", "/api/organization-members/" + organizationId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/organization-member/{id}", "/api/organization-member/" + organizationId, `{"emailAddress":"masepindrayana@gmail.com","role":"OWNER"}`, "", ROUTE_MEMBER},
+		{"PUT", "/api/organization-member/{id}/{userId}", "/api/organization-member/" + organizationId + "/" + userId, `{"role":"MEMBER"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/organization-member/{id}/{userId}", "/api/organization-member/" + organizationId + "/" + userId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/project-organization/{id}", "/api/project-organization/" + projectId, `{"organizationId":"` + organizationId + `"}`, "", ROUTE_MEMBER},
+		{"GET", "/api/teams", "/api/teams", "", "", ROUTE_USER},
+		{"POST", "/api/team", "/api/team", `{"name":"team"}`, "", ROUTE_USER},
+		{"GET", "/api/team/{id}", "/api/team/" + teamId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/team/{id}", "/api/team/" + teamId, `{"name":"renamed"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/team/{id}", "/api/team/" + teamId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/team-member/{id}", "/api/team-member/" + teamId, `{"emailAddress":"masepindrayana@gmail.com"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/team-member/{id}/{userId}", "/api/team-member/" + teamId + "/" + userId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/team-access/{projectId}/{teamId}", "/api/team-access/" + projectId + "/" + teamId, `{"access":"MODIFY"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/team-access/{projectId}/{teamId}", "/api/team-access/" + projectId + "/" + teamId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/roles", "/api/roles?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/role", "/api/role", `{"name":"Viewer","projectId":"` + projectId + `","permissions":["view"]}`, "", ROUTE_MEMBER},
+		{"PUT", "/api/role/{id}", "/api/role/" + roleId, `{"name":"Viewer","permissions":["view"]}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/role/{id}", "/api/role/" + roleId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/assign-role/{projectId}/{userId}", "/api/assign-role/" + projectId + "/" + userId, `{"roleId":"` + roleId + `"}`, "", ROUTE_MEMBER},
+		{"GET", "/api/scopes", "/api/scopes?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/scope", "/api/scope", `{"name":"scope","projectId":"` + projectId + `"}`, "", ROUTE_MEMBER},
+		{"GET", "/api/scope/{id}", "/api/scope/" + scopeId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/scope/{id}", "/api/scope/" + scopeId, `{"name":"renamed"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/scope/{id}", "/api/scope/" + scopeId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/scenarios", "/api/scenarios?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/scenario", "/api/scenario", `{"name":"scenario","projectId":"` + projectId + `","scopeId":"` + scopeId + `","steps":[]}`, "", ROUTE_MEMBER},
+		{"GET", "/api/scenario/{id}", "/api/scenario/" + scenarioId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/scenario/{id}", "/api/scenario/" + scenarioId, `{"name":"renamed","steps":[]}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/scenario/{id}", "/api/scenario/" + scenarioId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/sessions", "/api/sessions?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/session", "/api/session", `{"version":"2.0","projectId":"` + projectId + `","scenarios":[]}`, "", ROUTE_MEMBER},
+		{"GET", "/api/session/{id}", "/api/session/" + sessionId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/session/{id}", "/api/session/" + sessionId, `{"version":"2.0","status":1,"scenarios":[]}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/session/{id}", "/api/session/" + sessionId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/reset-session/{id}", "/api/reset-session/" + sessionId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/test", "/api/test", `{"sessionId":"` + sessionId + `","scenarioId":"` + scenarioId + `"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/test/{id}", "/api/test/" + testId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/test/{id}", "/api/test/" + testId, `{"status":2}`, "", ROUTE_MEMBER},
+		{"GET", "/api/users", "/api/users", "", "", ROUTE_USER},
+		{"POST", "/api/user", "/api/user", "", "", ROUTE_USER},
+		{"GET", "/api/user", "/api/user", "", "", ROUTE_USER},
+		{"GET", "/api/user/{id}", "/api/user/" + userId, "", "", ROUTE_MEMBER},
+		{"PUT", "/api/user/{id}", "/api/user/" + userId, `{"userName":"renamed"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/user/{id}", "/api/user/" + userId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/logout", "/api/logout", "", "", ROUTE_USER},
+		{"GET", "/api/api-keys", "/api/api-keys?projectId=" + projectId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/api-key", "/api/api-key", `{"name":"ci","scope":"full","projectId":"` + projectId + `"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/api-key/{id}", "/api/api-key/" + apiKeyId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/auth/register", "/api/auth/register", "", "", ROUTE_PUBLIC},
+		{"POST", "/api/auth/verify-email", "/api/auth/verify-email", "", "", ROUTE_PUBLIC},
+		{"POST", "/api/auth/login", "/api/auth/login", "", "", ROUTE_PUBLIC},
+		{"POST", "/api/payments/callback", "/api/payments/callback", "", "", ROUTE_PUBLIC},
+		{"POST", "/api/payments/invoice", "/api/payments/invoice", "", "", ROUTE_USER},
+		{"GET", "/api/payments/invoice/{externalId}", "/api/payments/invoice/test-invoice", "", "", ROUTE_MEMBER},
+		{"GET", "/api/payments/invoice-by-user-id/{userId}", "/api/payments/invoice-by-user-id/" + userId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/blob", "/api/blob", upload.String(), writer.FormDataContentType(), ROUTE_MEMBER},
+		{"GET", "/api/blob/{id}", "/api/blob/" + blobId, "", "", ROUTE_MEMBER},
+		{"DELETE", "/api/blob/{id}", "/api/blob/" + blobId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/admin/blob-gc", "/api/admin/blob-gc", "", "", ROUTE_MEMBER},
+		{"GET", "/api/admin/blob-gc/metrics", "/api/admin/blob-gc/metrics", "", "", ROUTE_MEMBER},
+		{"PUT", "/api/admin/revoke-tokens/{userId}", "/api/admin/revoke-tokens/" + userId, "", "", ROUTE_MEMBER},
+		{"POST", "/api/admin/impersonation", "/api/admin/impersonation", `{"userId":"` + userId + `","reason":"test"}`, "", ROUTE_MEMBER},
+		{"DELETE", "/api/admin/impersonation/{id}", "/api/admin/impersonation/" + userId, "", "", ROUTE_MEMBER},
+		{"GET", "/api/admin/audit-logs", "/api/admin/audit-logs", "", "", ROUTE_MEMBER},
+	}
+
+	// Every route must be covered
+	app.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, "/api/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			isCovered := false
+			for _, r := range routes {
+				if r.Method == method && r.Template == template {
+					isCovered = true
+					break
+				}
+			}
+			assert.True(t, isCovered, "not covered: "+method+" "+template)
+		}
+		return nil
+	})
+
+	for _, r := range routes {
+		if r.Access == ROUTE_PUBLIC {
+			continue
+		}
+		name := r.Method + " " + r.Template
+		contentType := r.ContentType
+		if len(contentType) < 1 {
+			contentType = "application/json"
+		}
+
+		req, _ := http.NewRequest(r.Method, r.Path, bytes.NewBuffer([]byte(r.Body)))
+		req.Header.Set("Content-Type", contentType)
+		response := executeRequest(req)
+		assert.Equal(t, http.StatusUnauthorized, response.Code, name)
+
+		if r.Access != ROUTE_MEMBER {
+			continue
+		}
+		req, _ = http.NewRequest(r.Method, r.Path, bytes.NewBuffer([]byte(r.Body)))
+		req.Header.Set("Authorization", testUserToken2)
+		req.Header.Set("Content-Type", contentType)
+		response = executeRequest(req)
+		assert.Equal(t, http.StatusForbidden, response.Code, name)
+	}
+
+	// Nothing has been touched by the second user
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "test project")
+}
+
+func TestRateLimitStores(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	limit := RateLimit{Capacity: 2, Period: time.Second}
+	now := time.Now().Truncate(time.Second)
+	stores := map[string]RateLimitStore{
+		RATE_LIMIT_STORE_MEMORY:   NewMemoryRateLimitStore(),
+		RATE_LIMIT_STORE_POSTGRES: NewPostgresRateLimitStore(),
+	}
+	for name, store := range stores {
+		result, err := store.Take("user:test", limit, now)
+		assert.Equal(t, nil, err, name)
+		assert.True(t, result.Allowed, name)
+		assert.Equal(t, float64(1), result.Remaining, name)
+
+		result, _ = store.Take("user:test", limit, now)
+		assert.True(t, result.Allowed, name)
+		result, _ = store.Take("user:test", limit, now)
+		assert.False(t, result.Allowed, name)
+
+		// Other keys have their own bucket
+		result, _ = store.Take("user:other", limit, now)
+		assert.True(t, result.Allowed, name)
+
+		// One token is refilled every half second
+		result, _ = store.Take("user:test", limit, now.Add(500*time.Millisecond))
+		assert.True(t, result.Allowed, name)
+		assert.Equal(t, float64(0), result.Remaining, name)
+
+		purged, err := store.Purge(now.Add(time.Millisecond))
+		assert.Equal(t, nil, err, name)
+		assert.Equal(t, int64(1), purged, name)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	limits, store := app.RateLimiter.Limits, app.RateLimiter.Store
+	defer func() {
+		app.RateLimiter.Limits, app.RateLimiter.Store = limits, store
+	}()
+	app.RateLimiter.Limits, _ = parseRateLimits(RATE_LIMITS, "heavy:free=2/1m,heavy:anonymous=2/1m")
+	app.RateLimiter.Store = NewMemoryRateLimitStore()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/api/projects", nil)
+		req.Header.Set("Authorization", testUserToken1)
+		response := executeRequest(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "2", response.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprint(1-i), response.Header().Get("RateLimit-Remaining"))
+	}
+
+	req, _ := http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.Contains(t, response.Body.String(), "rate-limited")
+	assert.Equal(t, "30", response.Header().Get("Retry-After"))
+	assert.Equal(t, "60", response.Header().Get("RateLimit-Reset"))
+
+	// Other route groups are not affected
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "300", response.Header().Get("RateLimit-Limit"))
+
+	// Nor the other users
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// API keys have their own bucket
+	apiKey := createTestObject(t, testUserToken1, "/api/api-key", `{"name":"ci","scope":"read-only"}`)
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", "Bearer "+fmt.Sprintf("%s", apiKey["key"]))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// Admins are not limited
+	for i := 0; i < 3; i++ {
+		req, _ = http.NewRequest("GET", "/api/projects", nil)
+		req.Header.Set("Authorization", testAdminToken1)
+		response = executeRequest(req)
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "", response.Header().Get("RateLimit-Limit"))
+	}
+
+	// Invalid credentials are limited by IP address
+	for i := 0; i < 2; i++ {
+		req, _ = http.NewRequest("GET", "/api/projects", nil)
+		req.Header.Set("Authorization", "Bearer invalid")
+		response = executeRequest(req)
+		assert.Equal(t, http.StatusUnauthorized, response.Code)
+		assert.Equal(t, fmt.Sprint(1-i), response.Header().Get("RateLimit-Remaining"))
+	}
+
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", "Bearer invalid")
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+}
+
+func TestRateLimitParse(t *testing.T) {
+	limits, err := parseRateLimits(RATE_LIMITS, "heavy:free=60/1m, upload:enterprise=1000/1h")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, RateLimit{60, time.Minute}, limits[RATE_LIMIT_GROUP_HEAVY]["free"])
+	assert.Equal(t, RateLimit{1000, time.Hour}, limits[RATE_LIMIT_GROUP_UPLOAD]["enterprise"])
+	// The defaults are untouched
+	assert.Equal(t, RateLimit{30, time.Minute}, RATE_LIMITS[RATE_LIMIT_GROUP_HEAVY]["free"])
+
+	for _, invalid := range []string{"heavy=60/1m", "heavy:free=0/1m", "heavy:free=60/forever"} {
+		_, err = parseRateLimits(RATE_LIMITS, invalid)
+		assert.Equal(t, "invalid-rate-limit", err.Error(), invalid)
+	}
+}
@@ -0,0 +1,13 @@
+CREATE TABLE blob_objects (
+  bucket TEXT NOT NULL,
+  hash TEXT NOT NULL, /* SHA-256 of the content, also the object key */
+  size NUMERIC NOT NULL,
+  content_type TEXT NOT NULL,
+  ref_count INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP,
+  PRIMARY KEY (bucket, hash)
+);
+
+ALTER TABLE blobs ADD COLUMN hash TEXT;
+ALTER TABLE blobs ADD COLUMN metadata TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE blob_variants (
+  blob_id UUID NOT NULL,
+  variant TEXT NOT NULL,
+  bucket TEXT NOT NULL,
+  hash TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  size NUMERIC NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (blob_id, variant),
+  FOREIGN KEY (blob_id) REFERENCES blobs(id) ON UPDATE CASCADE
+);
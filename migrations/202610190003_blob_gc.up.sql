@@ -0,0 +1,11 @@
+ALTER TABLE blobs ADD COLUMN project_id UUID;
+
+CREATE TABLE blob_gc_runs (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  expired_blobs INT NOT NULL DEFAULT 0,
+  orphaned_objects INT NOT NULL DEFAULT 0,
+  purged_records INT NOT NULL DEFAULT 0,
+  reclaimed_bytes NUMERIC NOT NULL DEFAULT 0,
+  started_at TIMESTAMP NOT NULL,
+  finished_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
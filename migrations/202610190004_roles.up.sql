@@ -0,0 +1,13 @@
+CREATE TABLE roles (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  project_id UUID NOT NULL,
+  name TEXT NOT NULL,
+  permissions TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP,
+  deleted_at TIMESTAMP,
+  FOREIGN KEY (project_id) REFERENCES projects(id) ON UPDATE CASCADE
+);
+
+/* Set when access is CUSTOM */
+ALTER TABLE access_control_lists ADD COLUMN role_id TEXT;
@@ -0,0 +1,10 @@
+/* Copied from the exp claim of the verified token */
+ALTER TABLE tokens ADD COLUMN expires_at TIMESTAMP;
+
+/* Firebase ID tokens are valid for an hour */
+UPDATE tokens SET expires_at=created_at + INTERVAL '1 HOUR';
+
+CREATE INDEX tokens_key ON tokens (key);
+
+/* Tokens issued before are rejected, set when all tokens of the user are revoked */
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP;
@@ -0,0 +1,10 @@
+/* Users of the local email/password provider */
+CREATE TABLE local_credentials (
+  email_address TEXT NOT NULL PRIMARY KEY,
+  password_hash TEXT NOT NULL,
+  verification_key TEXT, /* SHA-256 of the token sent by email */
+  verification_sent_at TIMESTAMP,
+  verified_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP
+);
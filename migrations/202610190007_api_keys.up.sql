@@ -0,0 +1,18 @@
+/* Personal access tokens (tsp_) and project API keys (tsk_) */
+CREATE TABLE api_keys (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  user_id TEXT NOT NULL, /* Owner of personal tokens, creator of project keys */
+  project_id TEXT, /* Set for project keys */
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL, /* Shown to recognize the key */
+  key_hash TEXT NOT NULL UNIQUE, /* SHA-256 of the key */
+  scope TEXT NOT NULL,
+  expires_at TIMESTAMP,
+  last_used_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE INDEX api_keys_user_id ON api_keys (user_id);
+CREATE INDEX api_keys_project_id ON api_keys (project_id);
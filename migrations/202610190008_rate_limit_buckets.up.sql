@@ -0,0 +1,9 @@
+/* Token buckets shared by the instances, see RATE_LIMIT_STORE */
+CREATE TABLE rate_limit_buckets (
+  key TEXT NOT NULL PRIMARY KEY, /* Caller and route group */
+  tokens DOUBLE PRECISION NOT NULL,
+  allowed BOOLEAN NOT NULL, /* Whether the last request was allowed */
+  updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
@@ -0,0 +1,28 @@
+/* Admins acting as a user, see the X-Impersonate header */
+CREATE TABLE impersonations (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  admin_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  reason TEXT NOT NULL, /* e.g. the support ticket */
+  allow_destructive BOOLEAN NOT NULL DEFAULT false,
+  expires_at TIMESTAMP NOT NULL,
+  ended_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX impersonations_admin_id ON impersonations (admin_id);
+
+/* Every request made while impersonating */
+CREATE TABLE audit_logs (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  impersonation_id UUID NOT NULL REFERENCES impersonations (id),
+  admin_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  method TEXT NOT NULL,
+  path TEXT NOT NULL,
+  status INTEGER NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_logs_impersonation_id ON audit_logs (impersonation_id);
+CREATE INDEX audit_logs_admin_id ON audit_logs (admin_id);
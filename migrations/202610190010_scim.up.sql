@@ -0,0 +1,20 @@
+/* Users and groups provisioned by an identity provider through SCIM */
+ALTER TABLE users ADD COLUMN scim_external_id TEXT;
+
+CREATE TABLE groups (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  display_name TEXT NOT NULL,
+  external_id TEXT, /* ID of the group in the identity provider */
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE TABLE group_members (
+  group_id UUID NOT NULL REFERENCES groups (id),
+  user_id TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX group_members_user_id ON group_members (user_id);
@@ -0,0 +1,17 @@
+/* Single-use invitations addressed to an email address */
+CREATE TABLE project_invitations (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  project_id TEXT NOT NULL,
+  email_address TEXT NOT NULL,
+  access TEXT NOT NULL,
+  role_id TEXT, /* Set for the CUSTOM access */
+  token_hash TEXT NOT NULL UNIQUE, /* SHA-256 of the token sent by email */
+  invited_by TEXT NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  accepted_at TIMESTAMP,
+  accepted_by TEXT,
+  revoked_at TIMESTAMP,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX project_invitations_project_id ON project_invitations (project_id);
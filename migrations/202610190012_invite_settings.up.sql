@@ -0,0 +1,13 @@
+/* Settings of the invite link of the projects */
+ALTER TABLE projects ADD COLUMN invite_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE projects ADD COLUMN invite_access TEXT NOT NULL DEFAULT 'MODIFY';
+ALTER TABLE projects ADD COLUMN invite_role_id TEXT; /* Set for the CUSTOM access */
+ALTER TABLE projects ADD COLUMN invite_max_uses INTEGER NOT NULL DEFAULT 0; /* 0 is unlimited */
+ALTER TABLE projects ADD COLUMN invite_uses INTEGER NOT NULL DEFAULT 0;
+
+/* Codes replaced by a rotation, kept to tell they expired */
+CREATE TABLE retired_invite_codes (
+  invite_code UUID NOT NULL PRIMARY KEY,
+  project_id TEXT NOT NULL,
+  retired_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,14 @@
+/* Offers to hand a project over, accepted by the new owner */
+CREATE TABLE ownership_transfers (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  project_id TEXT NOT NULL,
+  from_user_id TEXT NOT NULL,
+  to_user_id TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  accepted_at TIMESTAMP,
+  cancelled_at TIMESTAMP
+);
+
+/* A single pending offer per project */
+CREATE UNIQUE INDEX ownership_transfers_pending ON ownership_transfers (project_id)
+WHERE accepted_at IS NULL AND cancelled_at IS NULL;
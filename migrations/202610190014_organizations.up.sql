@@ -0,0 +1,26 @@
+/* Organizations own projects and pay for them */
+CREATE TABLE organizations (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  subscription_type TEXT NOT NULL DEFAULT 'free',
+  default_access TEXT NOT NULL DEFAULT 'READ', /* Of the members on the projects */
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE TABLE organization_members (
+  organization_id UUID NOT NULL,
+  user_id TEXT NOT NULL,
+  role TEXT NOT NULL, /* OWNER, ADMIN or MEMBER */
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX organization_members_user_id ON organization_members (user_id);
+
+ALTER TABLE projects ADD COLUMN organization_id UUID;
+CREATE INDEX projects_organization_id ON projects (organization_id);
+
+/* Invoices paid for an organization upgrade its subscription */
+ALTER TABLE invoices ADD COLUMN organization_id TEXT;
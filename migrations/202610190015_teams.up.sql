@@ -0,0 +1,30 @@
+/* Named groups of users granted an access on projects in one go */
+CREATE TABLE teams (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  name TEXT NOT NULL,
+  organization_id UUID, /* Managed by the admins of the organization too */
+  created_by TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP,
+  deleted_at TIMESTAMP
+);
+
+CREATE TABLE team_members (
+  team_id UUID NOT NULL,
+  user_id TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (team_id, user_id)
+);
+
+CREATE INDEX team_members_user_id ON team_members (user_id);
+
+CREATE TABLE team_grants (
+  team_id UUID NOT NULL,
+  project_id TEXT NOT NULL,
+  access TEXT NOT NULL,
+  role_id TEXT, /* Set for the CUSTOM access */
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (team_id, project_id)
+);
+
+CREATE INDEX team_grants_project_id ON team_grants (project_id);
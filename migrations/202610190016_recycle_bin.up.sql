@@ -0,0 +1,9 @@
+/* Who deleted the items of the recycle bin */
+ALTER TABLE projects ADD COLUMN deleted_by TEXT;
+ALTER TABLE scopes ADD COLUMN deleted_by TEXT;
+ALTER TABLE scenarios ADD COLUMN deleted_by TEXT;
+ALTER TABLE sessions ADD COLUMN deleted_by TEXT;
+
+/* Archived projects are read-only */
+ALTER TABLE projects ADD COLUMN archived_at TIMESTAMP;
+ALTER TABLE projects ADD COLUMN archived_by TEXT;
@@ -0,0 +1,24 @@
+/* Everything deleted along with a project, scope, scenario or session */
+ALTER TABLE tests ADD COLUMN deleted_by TEXT;
+ALTER TABLE access_control_lists ADD COLUMN deleted_by TEXT;
+
+/* Deleted scenarios removed from open sessions, put back on restore */
+CREATE TABLE session_detached_scenarios (
+  session_id UUID NOT NULL,
+  scenario_id TEXT NOT NULL,
+  detached_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (session_id, scenario_id),
+  FOREIGN KEY (session_id) REFERENCES sessions(id) ON UPDATE CASCADE
+);
+
+INSERT INTO session_detached_scenarios (session_id, scenario_id, detached_at)
+SELECT s.id, scen.id::text, scen.deleted_at FROM sessions s, scenarios scen
+WHERE scen.id::text=ANY(s.scenarios) AND scen.deleted_at IS NOT NULL
+AND s.status=0 AND s.deleted_at IS NULL;
+
+UPDATE sessions s SET scenarios=ARRAY(
+  SELECT x FROM unnest(s.scenarios) x WHERE x NOT IN (
+    SELECT d.scenario_id FROM session_detached_scenarios d WHERE d.session_id=s.id
+  )
+)
+WHERE s.id IN (SELECT session_id FROM session_detached_scenarios);
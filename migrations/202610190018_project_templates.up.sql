@@ -0,0 +1,2 @@
+/* Templates are listed to create new projects from */
+ALTER TABLE projects ADD COLUMN is_template BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,27 @@
+/* Append-only log of what changed in the projects */
+CREATE TABLE project_activities (
+  id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+  project_id TEXT NOT NULL,
+  actor_id TEXT NOT NULL,
+  object_type TEXT NOT NULL,
+  object_id TEXT NOT NULL,
+  action TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX project_activities_project_id ON project_activities (project_id, created_at);
+
+CREATE FUNCTION project_activities_append_only() RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'project_activities is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER project_activities_append_only
+BEFORE UPDATE OR DELETE ON project_activities
+FOR EACH ROW EXECUTE FUNCTION project_activities_append_only();
+
+CREATE TRIGGER project_activities_no_truncate
+BEFORE TRUNCATE ON project_activities
+FOR EACH STATEMENT EXECUTE FUNCTION project_activities_append_only();
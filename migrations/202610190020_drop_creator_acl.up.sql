@@ -0,0 +1,4 @@
+/* Creators no longer own what they create in a project, the access to the
+   project applies to everything in it. OWNER is not an override either. */
+DELETE FROM access_control_lists
+WHERE object_type IN ('scope', 'scenario', 'session', 'test') AND access='OWNER';
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (app *App) getOrganizations(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	organizations, err := getOrganizations(currentUser.ID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, organizations)
+}
+
+// createOrganization creates an organization owned by the current user.
+func (app *App) createOrganization(w http.ResponseWriter, r *http.Request) {
+	var p Organization
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	if err := p.createOrganization(currentUser.ID); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-name", "invalid-access-level":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+// getOrganization responds with the quotas of the organization, its
+// projects count for them instead of their owners.
+func (app *App) getOrganization(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	role, ok := authorizeOrganization(w, r, id, ORG_ROLES[:]...)
+	if !ok {
+		return
+	}
+
+	p := Organization{ID: id, Role: role}
+	if err := p.getOrganization(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	account := BillingAccount{OrganizationID: p.ID, SubscriptionType: p.SubscriptionType}
+	quotas, err := account.getQuotas()
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	p.Quotas = &quotas
+
+	respond(w, http.StatusOK, p)
+}
+
+func (app *App) updateOrganization(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	role, ok := authorizeOrganization(w, r, id, ORG_ROLE_OWNER, ORG_ROLE_ADMIN)
+	if !ok {
+		return
+	}
+
+	var p Organization
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.ID = id
+	p.Role = role
+
+	if err := p.updateOrganization(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+			return
+		}
+		switch err.Error() {
+		case "invalid-name", "invalid-access-level":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, p)
+}
+
+// deleteOrganization deletes an organization once its projects have been
+// deleted or moved out.
+func (app *App) deleteOrganization(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := authorizeOrganization(w, r, id, ORG_ROLE_OWNER); !ok {
+		return
+	}
+
+	p := Organization{ID: id}
+	if err := p.deleteOrganization(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "organization-not-empty":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (app *App) getOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := authorizeOrganization(w, r, id, ORG_ROLES[:]...); !ok {
+		return
+	}
+
+	members, err := getOrganizationMembers(id)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, members)
+}
+
+// addOrganizationMember adds a user by email address. Only owners make
+// other owners.
+func (app *App) addOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	role, ok := authorizeOrganization(w, r, id, ORG_ROLE_OWNER, ORG_ROLE_ADMIN)
+	if !ok {
+		return
+	}
+
+	var p OrganizationMember
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.OrganizationID = id
+
+	if p.Role == ORG_ROLE_OWNER && role != ORG_ROLE_OWNER {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	if err := p.addOrganizationMember(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-role":
+			respondError(w, http.StatusBadRequest, err.Error())
+		case "user-not-found":
+			respondError(w, http.StatusNotFound, err.Error())
+		case "already-member":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+// managedOrganizationMember checks that the current user can change the
+// member of the request, owners are changed by owners only.
+func managedOrganizationMember(w http.ResponseWriter, r *http.Request) (OrganizationMember, string, bool) {
+	vars := mux.Vars(r)
+	p := OrganizationMember{OrganizationID: vars["id"], UserID: vars["userId"]}
+	role, ok := authorizeOrganization(w, r, p.OrganizationID, ORG_ROLE_OWNER, ORG_ROLE_ADMIN)
+	if !ok {
+		return p, "", false
+	}
+	if _, err := uuidParser.Parse(p.UserID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return p, "", false
+	}
+
+	memberRole, err := getOrganizationRole(p.OrganizationID, p.UserID)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "member-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return p, "", false
+	}
+	if memberRole == ORG_ROLE_OWNER && role != ORG_ROLE_OWNER {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return p, "", false
+	}
+	return p, role, true
+}
+
+func respondOrganizationMemberError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "member-not-found")
+		return
+	}
+	switch err.Error() {
+	case "invalid-role":
+		respondError(w, http.StatusBadRequest, err.Error())
+	case "last-owner":
+		respondError(w, http.StatusConflict, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func (app *App) updateOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	p, role, ok := managedOrganizationMember(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Role string `json:"role"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.Role = payload.Role
+
+	if p.Role == ORG_ROLE_OWNER && role != ORG_ROLE_OWNER {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	if err := p.updateOrganizationMember(); err != nil {
+		log.Println(err)
+		respondOrganizationMemberError(w, err)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (app *App) removeOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	p, _, ok := managedOrganizationMember(w, r)
+	if !ok {
+		return
+	}
+
+	if err := p.removeOrganizationMember(); err != nil {
+		log.Println(err)
+		respondOrganizationMemberError(w, err)
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// setProjectOrganization moves a project of the current user into an
+// organization, where it counts for the quotas of the organization, or back
+// out with an empty organizationId.
+func (app *App) setProjectOrganization(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
+	var p struct {
+		OrganizationID string `json:"organizationId"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	// Admins of both organizations move projects between them
+	current, err := getProjectOrganization(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if len(current) > 0 {
+		if _, ok := authorizeOrganization(w, r, current, ORG_ROLE_OWNER, ORG_ROLE_ADMIN); !ok {
+			return
+		}
+	}
+	if len(p.OrganizationID) > 0 {
+		if _, ok := authorizeOrganization(w, r, p.OrganizationID, ORG_ROLE_OWNER, ORG_ROLE_ADMIN); !ok {
+			return
+		}
+	}
+
+	gb := NewGrowthBook(app.GBFeatures, "")
+	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
+	if isContentCreationLimiterEnabled && p.OrganizationID != current {
+		owner, err := getProjectOwner(id)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		isEligible, err := isEligibleToCreateProject(owner, p.OrganizationID)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !isEligible {
+			respondError(w, 429, "too-many-projects")
+			return
+		}
+	}
+
+	if err = setProjectOrganization(id, p.OrganizationID); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+)
+
+// Roles of the members of an organization. Owners manage the billing and
+// the organization itself, admins manage the members and projects.
+const (
+	ORG_ROLE_OWNER  = "OWNER"
+	ORG_ROLE_ADMIN  = "ADMIN"
+	ORG_ROLE_MEMBER = "MEMBER"
+)
+
+var ORG_ROLES = [...]string{ORG_ROLE_OWNER, ORG_ROLE_ADMIN, ORG_ROLE_MEMBER}
+
+// Access of the owners and admins of the organization on its projects, the
+// members get the default access of the organization.
+const ORG_ADMIN_ACCESS = "OWNER"
+
+// Default access of the members that can be chosen
+var ORG_DEFAULT_ACCESS_LEVELS = [...]string{"MODIFY", "READ"}
+
+type Organization struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	SubscriptionType string  `json:"subscriptionType"`
+	DefaultAccess    string  `json:"defaultAccess"`
+	Role             string  `json:"role"`
+	Quotas           *Quotas `json:"quotas,omitempty"`
+	CreatedAt        string  `json:"createdAt"`
+}
+
+type OrganizationMember struct {
+	OrganizationID string `json:"organizationId"`
+	UserID         string `json:"userId"`
+	EmailAddress   string `json:"emailAddress"`
+	Username       string `json:"username"`
+	Role           string `json:"role"`
+	CreatedAt      string `json:"createdAt"`
+}
+
+func isValidOrganizationRole(role string) bool {
+	for _, r := range ORG_ROLES {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Organization) validate() error {
+	o.Name = strings.TrimSpace(o.Name)
+	if len(o.Name) < 1 {
+		return errors.New("invalid-name")
+	}
+	if len(o.DefaultAccess) < 1 {
+		o.DefaultAccess = "READ"
+	}
+	for _, level := range ORG_DEFAULT_ACCESS_LEVELS {
+		if level == o.DefaultAccess {
+			return nil
+		}
+	}
+	return errors.New("invalid-access-level")
+}
+
+// createOrganization creates the organization with the user as its owner.
+func (o *Organization) createOrganization(userID string) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+	INSERT INTO organizations (name, default_access) VALUES ($1, $2)
+	RETURNING id, subscription_type, created_at
+	`, o.Name, o.DefaultAccess).Scan(&o.ID, &o.SubscriptionType, &o.CreatedAt)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)
+	`, o.ID, userID, ORG_ROLE_OWNER)
+	if err != nil {
+		return err
+	}
+	o.Role = ORG_ROLE_OWNER
+	return tx.Commit()
+}
+
+func (o *Organization) getOrganization() error {
+	return app.DB.QueryRow(`
+	SELECT name, subscription_type, default_access, created_at
+	FROM organizations WHERE id::text=$1 AND deleted_at IS NULL
+	`, o.ID).Scan(&o.Name, &o.SubscriptionType, &o.DefaultAccess, &o.CreatedAt)
+}
+
+func (o *Organization) updateOrganization() error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	return app.DB.QueryRow(`
+	UPDATE organizations SET name=$1, default_access=$2, updated_at=NOW()
+	WHERE id=$3 AND deleted_at IS NULL
+	RETURNING subscription_type, created_at
+	`, o.Name, o.DefaultAccess, o.ID).Scan(&o.SubscriptionType, &o.CreatedAt)
+}
+
+// deleteOrganization deletes an organization without projects.
+func (o *Organization) deleteOrganization() error {
+	var hasProjects bool
+	err := app.DB.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM projects WHERE organization_id=$1 AND deleted_at IS NULL)
+	`, o.ID).Scan(&hasProjects)
+	if err != nil {
+		return err
+	}
+	if hasProjects {
+		return errors.New("organization-not-empty")
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE organizations SET deleted_at=NOW() WHERE id=$1
+	`, o.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM organization_members WHERE organization_id=$1
+	`, o.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getOrganizations lists the organizations the user is member of.
+func getOrganizations(userID string) ([]Organization, error) {
+	rows, err := app.DB.Query(`
+	SELECT o.id, o.name, o.subscription_type, o.default_access, m.role, o.created_at
+	FROM organizations o, organization_members m
+	WHERE o.id=m.organization_id AND m.user_id=$1 AND o.deleted_at IS NULL
+	ORDER BY o.name
+	`, userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	organizations := []Organization{}
+
+	for rows.Next() {
+		var o Organization
+		if err := rows.Scan(
+			&o.ID,
+			&o.Name,
+			&o.SubscriptionType,
+			&o.DefaultAccess,
+			&o.Role,
+			&o.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		organizations = append(organizations, o)
+	}
+
+	return organizations, nil
+}
+
+// getOrganizationRole returns the role of the user in the organization, or
+// sql.ErrNoRows for non members.
+func getOrganizationRole(organizationID, userID string) (string, error) {
+	var role string
+	err := app.DB.QueryRow(`
+	SELECT m.role FROM organization_members m, organizations o
+	WHERE o.id=m.organization_id AND o.deleted_at IS NULL
+	AND m.organization_id::text=$1 AND m.user_id=$2
+	`, organizationID, userID).Scan(&role)
+	return role, err
+}
+
+func getOrganizationMembers(organizationID string) ([]OrganizationMember, error) {
+	rows, err := app.DB.Query(`
+	SELECT m.organization_id, m.user_id, users.email_address, users.user_name, m.role, m.created_at
+	FROM organization_members m, users
+	WHERE users.id::text=m.user_id AND m.organization_id=$1
+	ORDER BY m.created_at
+	`, organizationID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+
+	for rows.Next() {
+		var m OrganizationMember
+		if err := rows.Scan(
+			&m.OrganizationID,
+			&m.UserID,
+			&m.EmailAddress,
+			&m.Username,
+			&m.Role,
+			&m.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+// addOrganizationMember adds an existing user, found by email address.
+func (m *OrganizationMember) addOrganizationMember() error {
+	if !isValidOrganizationRole(m.Role) {
+		return errors.New("invalid-role")
+	}
+	user := User{EmailAddress: strings.TrimSpace(m.EmailAddress)}
+	if err := user.getUser(); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user-not-found")
+		}
+		return err
+	}
+	m.UserID = user.ID
+	m.EmailAddress = user.EmailAddress
+	m.Username = user.UserName
+
+	res, err := app.DB.Exec(`
+	INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING
+	`, m.OrganizationID, m.UserID, m.Role)
+	if err != nil {
+		return err
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if added < 1 {
+		return errors.New("already-member")
+	}
+	return nil
+}
+
+// updateOrganizationMembers runs the change of the members in a transaction
+// that fails when no owner would remain.
+func updateOrganizationMembers(organizationID, query string, args ...interface{}) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated < 1 {
+		return sql.ErrNoRows
+	}
+
+	var owners int
+	err = tx.QueryRow(`
+	SELECT COUNT(*) FROM organization_members WHERE organization_id=$1 AND role=$2
+	`, organizationID, ORG_ROLE_OWNER).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners < 1 {
+		return errors.New("last-owner")
+	}
+	return tx.Commit()
+}
+
+func (m *OrganizationMember) updateOrganizationMember() error {
+	if !isValidOrganizationRole(m.Role) {
+		return errors.New("invalid-role")
+	}
+	return updateOrganizationMembers(m.OrganizationID, `
+	UPDATE organization_members SET role=$3 WHERE organization_id=$1 AND user_id=$2
+	`, m.OrganizationID, m.UserID, m.Role)
+}
+
+func (m *OrganizationMember) removeOrganizationMember() error {
+	return updateOrganizationMembers(m.OrganizationID, `
+	DELETE FROM organization_members WHERE organization_id=$1 AND user_id=$2
+	`, m.OrganizationID, m.UserID)
+}
+
+// getOrganizationAccess returns the access the user has on the project as a
+// member of its organization, or sql.ErrNoRows.
+func getOrganizationAccess(projectID, userID string) (string, error) {
+	var role, defaultAccess string
+	err := app.DB.QueryRow(`
+	SELECT m.role, o.default_access
+	FROM projects p, organizations o, organization_members m
+	WHERE p.organization_id=o.id AND o.id=m.organization_id
+	AND o.deleted_at IS NULL AND p.id::text=$1 AND m.user_id=$2
+	`, projectID, userID).Scan(&role, &defaultAccess)
+	if err != nil {
+		return "", err
+	}
+	if role == ORG_ROLE_OWNER || role == ORG_ROLE_ADMIN {
+		return ORG_ADMIN_ACCESS, nil
+	}
+	return defaultAccess, nil
+}
+
+// getProjectOrganization returns the ID of the organization of the project,
+// empty for projects of users.
+func getProjectOrganization(projectID string) (string, error) {
+	var organizationID string
+	err := app.DB.QueryRow(`
+	SELECT COALESCE(organization_id::text, '') FROM projects WHERE id::text=$1 AND deleted_at IS NULL
+	`, projectID).Scan(&organizationID)
+	return organizationID, err
+}
+
+// setProjectOrganization moves the project into the organization, or back
+// to its owner with an empty organizationID.
+func setProjectOrganization(projectID, organizationID string) error {
+	_, err := app.DB.Exec(`
+	UPDATE projects SET organization_id=NULLIF($1, '')::uuid, updated_at=NOW()
+	WHERE id=$2 AND deleted_at IS NULL
+	`, organizationID, projectID)
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrganizationProjects(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	organization := createTestObject(t, testUserToken1, "/api/organization", `{"name":"QA team"}`)
+	organizationId := fmt.Sprintf("%s", organization["id"])
+	assert.Equal(t, "OWNER", organization["role"])
+	assert.Equal(t, "READ", organization["defaultAccess"])
+
+	// Strangers see nothing
+	req, _ := http.NewRequest("GET", "/api/organization/"+organizationId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	member := createTestObject(t, testUserToken1, "/api/organization-member/"+organizationId,
+		`{"emailAddress":"masepindrayana@gmail.com","role":"MEMBER"}`)
+	memberId := fmt.Sprintf("%s", member["userId"])
+
+	project := createTestObject(t, testUserToken1, "/api/project",
+		`{"name":"org project","organizationId":"`+organizationId+`"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	// Members get the default access of the organization
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), projectId)
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/organization/"+organizationId, bytes.NewBuffer([]byte(`{"name":"QA team","defaultAccess":"MODIFY"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/organization/"+organizationId, bytes.NewBuffer([]byte(`{"name":"QA team","defaultAccess":"MODIFY"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// The project counts for the organization, not for its creator
+	req, _ = http.NewRequest("GET", "/api/organization/"+organizationId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &organization)
+	assert.Equal(t, float64(1), organization["quotas"].(map[string]interface{})["project"])
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	var owner map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &owner)
+	ownerId := fmt.Sprintf("%s", owner["id"])
+	assert.Equal(t, float64(0), owner["quotas"].(map[string]interface{})["project"])
+
+	// Members manage nothing
+	req, _ = http.NewRequest("PUT", "/api/organization-member/"+organizationId+"/"+memberId, bytes.NewBuffer([]byte(`{"role":"ADMIN"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// An organization keeps an owner
+	req, _ = http.NewRequest("PUT", "/api/organization-member/"+organizationId+"/"+ownerId, bytes.NewBuffer([]byte(`{"role":"ADMIN"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), "last-owner")
+
+	req, _ = http.NewRequest("DELETE", "/api/organization/"+organizationId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// Former members lose the access
+	req, _ = http.NewRequest("DELETE", "/api/organization-member/"+organizationId+"/"+memberId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Back to the owner
+	req, _ = http.NewRequest("PUT", "/api/project-organization/"+projectId, bytes.NewBuffer([]byte(`{"organizationId":""}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/organization/"+organizationId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+// getOwnershipTransfers lists the pending offers of a project, or the ones
+// made to the current user without the projectId parameter.
+func (app *App) getOwnershipTransfers(w http.ResponseWriter, r *http.Request) {
+	projectId := r.FormValue("projectId")
+	if len(projectId) > 0 {
+		if !authorizeReference(w, r, projectId, PERMISSION_VIEW) {
+			return
+		}
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	transfers, err := getOwnershipTransfers(projectId, currentUser.ID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, transfers)
+}
+
+// createOwnershipTransfer offers the project to a collaborator, the owner
+// stays until the offer is accepted.
+func (app *App) createOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	var p OwnershipTransfer
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if !authorizeReference(w, r, p.ProjectID, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+	if _, err := uuidParser.Parse(p.ToUserID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	owner, err := getProjectOwner(p.ProjectID)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// Only the owner gives the project away
+	currentUser := r.Context().Value("currentUser").(*User)
+	if currentUser.ID != owner && !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	p.FromUserID = owner
+
+	if err = p.createOwnershipTransfer(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "already-owner":
+			respondError(w, http.StatusBadRequest, err.Error())
+		case "collaborator-not-found":
+			respondError(w, http.StatusNotFound, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+// getPendingTransfer loads the ownership transfer of the request, it must
+// still be pending.
+func getPendingTransfer(w http.ResponseWriter, r *http.Request) (OwnershipTransfer, bool) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	p := OwnershipTransfer{ID: id}
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return p, false
+	}
+
+	if err := p.getOwnershipTransfer(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return p, false
+	}
+
+	return p, true
+}
+
+// acceptOwnershipTransfer is called by the new owner.
+func (app *App) acceptOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	p, ok := getPendingTransfer(w, r)
+	if !ok {
+		return
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	if currentUser.ID != p.ToUserID {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if !p.isPending() {
+		respondError(w, http.StatusConflict, "transfer-not-pending")
+		return
+	}
+
+	// The project counts for the quotas of the new owner, unless it belongs
+	// to an organization
+	organizationId, err := getProjectOrganization(p.ProjectID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	gb := NewGrowthBook(app.GBFeatures, "")
+	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
+	if isContentCreationLimiterEnabled && len(organizationId) < 1 {
+		isEligible, err := isEligibleToCreateProject(currentUser.ID, "")
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !isEligible {
+			respondError(w, 429, "too-many-projects")
+			return
+		}
+	}
+
+	if err = p.acceptOwnershipTransfer(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "transfer-not-pending":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// cancelOwnershipTransfer withdraws the offer by the owner, or declines it
+// by the offered user.
+func (app *App) cancelOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	p, ok := getPendingTransfer(w, r)
+	if !ok {
+		return
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	if currentUser.ID != p.ToUserID && currentUser.ID != p.FromUserID && !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	if err := p.cancelOwnershipTransfer(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "transfer-not-pending":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// The previous owner stays collaborator with this access
+const OWNERSHIP_PREVIOUS_OWNER_ACCESS = "MODIFY"
+
+type OwnershipTransfer struct {
+	ID          string `json:"id"`
+	ProjectID   string `json:"projectId"`
+	FromUserID  string `json:"fromUserId"`
+	ToUserID    string `json:"toUserId"`
+	CreatedAt   string `json:"createdAt"`
+	AcceptedAt  string `json:"acceptedAt"`
+	CancelledAt string `json:"cancelledAt"`
+}
+
+// getProjectOwner returns the ID of the user with the OWNER access.
+func getProjectOwner(projectID string) (string, error) {
+	var userID string
+	err := app.DB.QueryRow(`
+	SELECT user_id FROM access_control_lists
+	WHERE object_id=$1 AND object_type='project' AND access='OWNER'
+	AND deleted_at IS NULL
+	LIMIT 1
+	`, projectID).Scan(&userID)
+	return userID, err
+}
+
+// createOwnershipTransfer offers the project to a collaborator, replacing
+// the pending offer if any.
+func (o *OwnershipTransfer) createOwnershipTransfer() error {
+	if o.ToUserID == o.FromUserID {
+		return errors.New("already-owner")
+	}
+	access := Acl{ObjectID: o.ProjectID, UserID: o.ToUserID}
+	if err := access.getAccess(); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("collaborator-not-found")
+		}
+		return err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE ownership_transfers SET cancelled_at=NOW()
+	WHERE project_id=$1 AND accepted_at IS NULL AND cancelled_at IS NULL
+	`, o.ProjectID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`
+	INSERT INTO ownership_transfers (project_id, from_user_id, to_user_id)
+	VALUES ($1, $2, $3)
+	RETURNING id, created_at
+	`, o.ProjectID, o.FromUserID, o.ToUserID).Scan(&o.ID, &o.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const ownershipTransferColumns = `
+	id, project_id, from_user_id, to_user_id, created_at,
+	COALESCE(accepted_at::text, ''), COALESCE(cancelled_at::text, '')
+`
+
+func (o *OwnershipTransfer) scan(row interface{ Scan(...interface{}) error }) error {
+	return row.Scan(
+		&o.ID,
+		&o.ProjectID,
+		&o.FromUserID,
+		&o.ToUserID,
+		&o.CreatedAt,
+		&o.AcceptedAt,
+		&o.CancelledAt,
+	)
+}
+
+func (o *OwnershipTransfer) getOwnershipTransfer() error {
+	return o.scan(app.DB.QueryRow(`
+	SELECT `+ownershipTransferColumns+`
+	FROM ownership_transfers WHERE id=$1
+	`, o.ID))
+}
+
+// getOwnershipTransfers lists the pending offers of the project, or the
+// pending offers made to the user without projectId.
+func getOwnershipTransfers(projectId, userId string) ([]OwnershipTransfer, error) {
+	rows, err := app.DB.Query(`
+	SELECT `+ownershipTransferColumns+`
+	FROM ownership_transfers
+	WHERE accepted_at IS NULL AND cancelled_at IS NULL
+	AND (($1 <> '' AND project_id=$1) OR ($1 = '' AND to_user_id=$2))
+	ORDER BY created_at DESC
+	`, projectId, userId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	transfers := []OwnershipTransfer{}
+
+	for rows.Next() {
+		var o OwnershipTransfer
+		if err := o.scan(rows); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		transfers = append(transfers, o)
+	}
+
+	return transfers, nil
+}
+
+func (o *OwnershipTransfer) isPending() bool {
+	return len(o.AcceptedAt) < 1 && len(o.CancelledAt) < 1
+}
+
+func (o *OwnershipTransfer) cancelOwnershipTransfer() error {
+	res, err := app.DB.Exec(`
+	UPDATE ownership_transfers SET cancelled_at=NOW()
+	WHERE id=$1 AND accepted_at IS NULL AND cancelled_at IS NULL
+	`, o.ID)
+	if err != nil {
+		return err
+	}
+	cancelled, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if cancelled < 1 {
+		return errors.New("transfer-not-pending")
+	}
+	return nil
+}
+
+// cancelOwnershipTransfers cancels the pending offers made to the user, of
+// the project or of every project with an empty projectID.
+func cancelOwnershipTransfers(projectID, userID string) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = cancelOwnershipTransfersTx(tx, projectID, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func cancelOwnershipTransfersTx(tx *sql.Tx, projectID, userID string) error {
+	_, err := tx.Exec(`
+	UPDATE ownership_transfers SET cancelled_at=NOW()
+	WHERE to_user_id=$2 AND ($1='' OR project_id=$1)
+	AND accepted_at IS NULL AND cancelled_at IS NULL
+	`, projectID, userID)
+	return err
+}
+
+// acceptOwnershipTransfer moves OWNER to the new owner, so the quotas of the
+// project count for them, and leaves the previous owner with MODIFY.
+func (o *OwnershipTransfer) acceptOwnershipTransfer() error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	UPDATE ownership_transfers SET accepted_at=NOW()
+	WHERE id=$1 AND accepted_at IS NULL AND cancelled_at IS NULL
+	`, o.ID)
+	if err != nil {
+		return err
+	}
+	accepted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if accepted < 1 {
+		return errors.New("transfer-not-pending")
+	}
+
+	// The offer is void once the owner changed
+	var isOwner bool
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+	  SELECT 1 FROM access_control_lists
+	  WHERE object_id=$1 AND object_type='project' AND user_id=$2 AND access='OWNER'
+	  AND deleted_at IS NULL
+	)
+	`, o.ProjectID, o.FromUserID).Scan(&isOwner)
+	if err != nil {
+		return err
+	}
+	if !isOwner {
+		return errors.New("transfer-not-pending")
+	}
+
+	// So is the offer to someone no longer collaborating
+	var isCollaborator bool
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+	  SELECT 1 FROM access_control_lists
+	  WHERE object_id=$1 AND object_type='project' AND user_id=$2
+	  AND deleted_at IS NULL
+	)
+	`, o.ProjectID, o.ToUserID).Scan(&isCollaborator)
+	if err != nil {
+		return err
+	}
+	if !isCollaborator {
+		return errors.New("transfer-not-pending")
+	}
+
+	previous := Acl{
+		ObjectID:   o.ProjectID,
+		ObjectType: "project",
+		UserID:     o.FromUserID,
+		Access:     OWNERSHIP_PREVIOUS_OWNER_ACCESS,
+	}
+	if err = previous.setAccessTx(tx); err != nil {
+		return err
+	}
+	owner := Acl{
+		ObjectID:   o.ProjectID,
+		ObjectType: "project",
+		UserID:     o.ToUserID,
+		Access:     "OWNER",
+	}
+	if err = owner.setAccessTx(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
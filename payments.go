@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"log"
@@ -45,6 +46,13 @@ func (app *App) createInvoice(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// Owners pay for their organization
+	if len(i.OrganizationID) > 0 {
+		if _, ok := authorizeOrganization(w, r, i.OrganizationID, ORG_ROLE_OWNER); !ok {
+			return
+		}
+	}
+
 	// Validate product and get the real amount
 	// TODO iterate Items instead of picking only the first
 	productItem := Product{ID: i.Items[0].ID}
@@ -146,6 +154,21 @@ func (app *App) getInvoice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	invoiceExternalID := vars["externalId"]
 
+	// Invoices are visible to the users allowed to see the billing of the payer
+	payerID, err := getInvoicePayerID(invoiceExternalID)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if !authorize(w, r, payerID, PERMISSION_VIEW_BILLING) {
+		return
+	}
+
 	xenditInvoice, err := app.getXenditInvoice(&XenditInvoice.GetParams{ID: invoiceExternalID})
 	if err != nil {
 		log.Println(err)
@@ -179,6 +202,9 @@ func (app *App) getInvoiceByUserID(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	userID := vars["userId"]
+	if !authorizeReference(w, r, userID, PERMISSION_VIEW_BILLING) {
+		return
+	}
 
 	i := Invoice{UserID: userID}
 	err = i.getInvoice()
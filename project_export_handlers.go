@@ -0,0 +1,206 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+// exportProject responds with the project as a zip archive, to back it up
+// or to move it to another instance.
+func (app *App) exportProject(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
+	archive, err := getProjectArchive(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	if err = app.dropMissingBlobs(r.Context(), &archive); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="project-`+id+`.zip"`)
+	w.WriteHeader(http.StatusOK)
+	if err = app.writeProjectArchive(r.Context(), w, id, &archive); err != nil {
+		log.Println(err)
+	}
+}
+
+// importProject creates a new project owned by the current user from an
+// archive uploaded as "file", in the organization of "organizationId" when
+// set. It responds with the IDs created and the conflicts met.
+func (app *App) importProject(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, projectImportMaxSize())
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		log.Println(err)
+		if err.Error() == "http: request body too large" {
+			respondError(w, http.StatusRequestEntityTooLarge, "archive-too-large")
+		} else {
+			respondError(w, http.StatusBadRequest, "invalid-payload")
+		}
+		return
+	}
+	defer file.Close()
+
+	organizationID := r.FormValue("organizationId")
+	if len(organizationID) > 0 {
+		if _, ok := authorizeOrganization(w, r, organizationID, ORG_ROLES[:]...); !ok {
+			return
+		}
+	}
+
+	z, err := zip.NewReader(file, handler.Size)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-archive")
+		return
+	}
+	archive, err := readProjectArchive(z)
+	if err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-archive", "unsupported-archive-version":
+			respondError(w, http.StatusBadRequest, err.Error())
+		case "archive-too-large":
+			respondError(w, http.StatusRequestEntityTooLarge, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	gb := NewGrowthBook(app.GBFeatures, "")
+	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
+	if isContentCreationLimiterEnabled {
+		account, err := getNewProjectBillingAccount(currentUser.ID, organizationID)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		exceeded, err := archive.exceededQuota(account, archive.importedSize(z))
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(exceeded) > 0 {
+			respondError(w, 429, exceeded)
+			return
+		}
+	}
+
+	report, err := app.importProjectArchive(r.Context(), z, &archive, currentUser.ID, organizationID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusCreated, report)
+}
+
+// duplicateProject copies the project as a new project owned by the current
+// user, with the sessions and collaborators when asked. Templates are
+// duplicated to create new projects from them.
+func (app *App) duplicateProject(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
+	var options ProjectDuplicateOptions
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&options); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	// The collaborators are invited in the name of the current user
+	if options.IncludeCollaborators && !authorize(w, r, id, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	if len(options.OrganizationID) > 0 {
+		if _, ok := authorizeOrganization(w, r, options.OrganizationID, ORG_ROLES[:]...); !ok {
+			return
+		}
+	}
+
+	archive, err := getProjectArchive(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	archive.applyDuplicateOptions(options)
+
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	gb := NewGrowthBook(app.GBFeatures, "")
+	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
+	if isContentCreationLimiterEnabled {
+		account, err := getNewProjectBillingAccount(currentUser.ID, options.OrganizationID)
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		exceeded, err := archive.exceededQuota(account, archive.storedSize())
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(exceeded) > 0 {
+			respondError(w, 429, exceeded)
+			return
+		}
+	}
+
+	report, err := app.duplicateProjectArchive(r.Context(), &archive, currentUser.ID, options.OrganizationID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusCreated, report)
+}
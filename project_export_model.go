@@ -0,0 +1,780 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Project archives are zip files holding the manifest, the content of the
+// project and the blobs it references, stored by their ID in the archive.
+const (
+	PROJECT_ARCHIVE_VERSION  = 1
+	PROJECT_ARCHIVE_MANIFEST = "manifest.json"
+	PROJECT_ARCHIVE_CONTENT  = "project.json"
+	PROJECT_ARCHIVE_BLOBS    = "blobs/"
+)
+
+// Conflicts reported by imports
+const (
+	IMPORT_CONFLICT_USER_NOT_FOUND = "user-not-found"
+	IMPORT_CONFLICT_OWNER          = "owner"
+	IMPORT_CONFLICT_ROLE_NOT_FOUND = "role-not-found"
+	IMPORT_CONFLICT_ACCESS_LEVEL   = "invalid-access-level"
+	IMPORT_CONFLICT_BLOB_MISSING   = "blob-missing"
+	IMPORT_CONFLICT_INVITED        = "invited"
+)
+
+type ProjectArchiveManifest struct {
+	Version    int    `json:"version"`
+	ProjectID  string `json:"projectId"`
+	ExportedAt string `json:"exportedAt"`
+}
+
+// ProjectArchive is the content of a project, with the IDs of the instance
+// it was exported from. Users are referenced by email address.
+type ProjectArchive struct {
+	Project       ArchivedProject        `json:"project"`
+	Roles         []Role                 `json:"roles"`
+	Collaborators []ArchivedCollaborator `json:"collaborators"`
+	Scopes        []Scope                `json:"scopes"`
+	Scenarios     []Scenario             `json:"scenarios"`
+	Sessions      []ArchivedSession      `json:"sessions"`
+	Tests         []ArchivedTest         `json:"tests"`
+	Blobs         []ArchivedBlob         `json:"blobs"`
+}
+
+type ArchivedProject struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ArchivedCollaborator struct {
+	EmailAddress string `json:"emailAddress"`
+	Access       string `json:"access"`
+	RoleID       string `json:"roleId"`
+}
+
+type ArchivedSession struct {
+	ID          string   `json:"id"`
+	AuthorEmail string   `json:"authorEmail"`
+	Version     string   `json:"version"`
+	Description string   `json:"description"`
+	Status      int      `json:"status"`
+	Scenarios   []string `json:"scenarios"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+type ArchivedTest struct {
+	ID            string `json:"id"`
+	SessionID     string `json:"sessionId"`
+	ScenarioID    string `json:"scenarioId"`
+	AssigneeEmail string `json:"assigneeEmail"`
+	Steps         []Step `json:"steps"`
+	Status        int    `json:"status"`
+	Notes         string `json:"notes"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+type ArchivedBlob struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Metadata    string `json:"metadata"`
+}
+
+type ProjectImportConflict struct {
+	Type       string `json:"type"`
+	Reference  string `json:"reference"`
+	Resolution string `json:"resolution"`
+}
+
+// ProjectImportReport maps the IDs of the archive to the IDs created, and
+// lists what could not be imported as it was.
+type ProjectImportReport struct {
+	ProjectID string                  `json:"projectId"`
+	IDs       map[string]string       `json:"ids"`
+	Conflicts []ProjectImportConflict `json:"conflicts"`
+}
+
+func (r *ProjectImportReport) conflict(conflictType, reference, resolution string) {
+	r.Conflicts = append(r.Conflicts, ProjectImportConflict{
+		Type:       conflictType,
+		Reference:  reference,
+		Resolution: resolution,
+	})
+}
+
+// getProjectArchive loads the live content of the project.
+func getProjectArchive(projectID string) (ProjectArchive, error) {
+	a := ProjectArchive{
+		Roles:         []Role{},
+		Collaborators: []ArchivedCollaborator{},
+		Scopes:        []Scope{},
+		Scenarios:     []Scenario{},
+		Sessions:      []ArchivedSession{},
+		Tests:         []ArchivedTest{},
+		Blobs:         []ArchivedBlob{},
+	}
+
+	err := app.DB.QueryRow(`
+	SELECT name, description FROM projects WHERE id::text=$1 AND deleted_at IS NULL
+	`, projectID).Scan(&a.Project.Name, &a.Project.Description)
+	if err != nil {
+		return a, err
+	}
+
+	if a.Roles, err = getRoles(projectID); err != nil {
+		return a, err
+	}
+
+	rows, err := app.DB.Query(`
+	SELECT users.email_address, acl.access, COALESCE(acl.role_id, '')
+	FROM access_control_lists acl, users
+	WHERE users.id::text=acl.user_id AND acl.object_id=$1 AND acl.deleted_at IS NULL
+	ORDER BY acl.created_at
+	`, projectID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var c ArchivedCollaborator
+		if err = rows.Scan(&c.EmailAddress, &c.Access, &c.RoleID); err != nil {
+			rows.Close()
+			return a, err
+		}
+		a.Collaborators = append(a.Collaborators, c)
+	}
+	rows.Close()
+
+	rows, err = app.DB.Query(`
+	SELECT id, name FROM scopes WHERE project_id::text=$1 AND deleted_at IS NULL
+	ORDER BY created_at
+	`, projectID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var s Scope
+		if err = rows.Scan(&s.ID, &s.Name); err != nil {
+			rows.Close()
+			return a, err
+		}
+		a.Scopes = append(a.Scopes, s)
+	}
+	rows.Close()
+
+	rows, err = app.DB.Query(`
+	SELECT id, scope_id, name, steps FROM scenarios WHERE project_id::text=$1 AND deleted_at IS NULL
+	ORDER BY created_at
+	`, projectID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var s Scenario
+		var steps sql.NullString
+		if err = rows.Scan(&s.ID, &s.ScopeID, &s.Name, &steps); err != nil {
+			rows.Close()
+			return a, err
+		}
+		if steps.Valid {
+			json.Unmarshal([]byte(steps.String), &s.Steps)
+		}
+		a.Scenarios = append(a.Scenarios, s)
+	}
+	rows.Close()
+
+	rows, err = app.DB.Query(`
+	SELECT s.id, users.email_address, s.version, COALESCE(s.description, ''), s.status,
+	COALESCE(s.scenarios, '{}'), s.created_at::text
+	FROM sessions s, users
+	WHERE users.id=s.author_id AND s.project_id::text=$1 AND s.deleted_at IS NULL
+	ORDER BY s.created_at
+	`, projectID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var s ArchivedSession
+		if err = rows.Scan(
+			&s.ID,
+			&s.AuthorEmail,
+			&s.Version,
+			&s.Description,
+			&s.Status,
+			pq.Array(&s.Scenarios),
+			&s.CreatedAt,
+		); err != nil {
+			rows.Close()
+			return a, err
+		}
+		a.Sessions = append(a.Sessions, s)
+	}
+	rows.Close()
+
+	rows, err = app.DB.Query(`
+	SELECT t.id, t.session_id, t.scenario_id, users.email_address, t.steps, t.status,
+	COALESCE(t.notes, ''), t.created_at::text
+	FROM tests t, sessions s, users
+	WHERE t.session_id=s.id AND users.id=t.assignee_id
+	AND s.project_id::text=$1 AND s.deleted_at IS NULL AND t.deleted_at IS NULL
+	ORDER BY t.created_at
+	`, projectID)
+	if err != nil {
+		return a, err
+	}
+	for rows.Next() {
+		var t ArchivedTest
+		var steps sql.NullString
+		if err = rows.Scan(
+			&t.ID,
+			&t.SessionID,
+			&t.ScenarioID,
+			&t.AssigneeEmail,
+			&steps,
+			&t.Status,
+			&t.Notes,
+			&t.CreatedAt,
+		); err != nil {
+			rows.Close()
+			return a, err
+		}
+		if steps.Valid {
+			json.Unmarshal([]byte(steps.String), &t.Steps)
+		}
+		a.Tests = append(a.Tests, t)
+	}
+	rows.Close()
+
+	rows, err = app.DB.Query(`
+	SELECT id, filename, content_type, size, metadata FROM blobs
+	WHERE project_id::text=$1 AND deleted_at IS NULL
+	ORDER BY created_at
+	`, projectID)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b ArchivedBlob
+		if err = rows.Scan(&b.ID, &b.Filename, &b.ContentType, &b.Size, &b.Metadata); err != nil {
+			return a, err
+		}
+		a.Blobs = append(a.Blobs, b)
+	}
+
+	return a, rows.Err()
+}
+
+// dropMissingBlobs leaves the blobs missing from the storage out of the
+// archive, imports report them. It is called before the response starts,
+// objects are otherwise only found missing half way through the archive.
+func (app *App) dropMissingBlobs(ctx context.Context, a *ProjectArchive) error {
+	blobs := []ArchivedBlob{}
+	for _, b := range a.Blobs {
+		req := BlobData{ID: b.ID}
+		err := req.getBlob()
+		if err == nil {
+			_, err = app.Storage.Stat(ctx, req.Bucket, req.objectKey())
+		}
+		if err == sql.ErrNoRows || err == ErrObjectNotFound {
+			log.Println("missing blob", b.ID, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, b)
+	}
+	a.Blobs = blobs
+	return nil
+}
+
+// writeProjectArchive writes the project as a zip archive, after
+// dropMissingBlobs.
+func (app *App) writeProjectArchive(ctx context.Context, w io.Writer, projectID string, a *ProjectArchive) error {
+	z := zip.NewWriter(w)
+
+	manifest := ProjectArchiveManifest{
+		Version:    PROJECT_ARCHIVE_VERSION,
+		ProjectID:  projectID,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	for name, content := range map[string]interface{}{
+		PROJECT_ARCHIVE_MANIFEST: manifest,
+		PROJECT_ARCHIVE_CONTENT:  a,
+	} {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		if err = json.NewEncoder(f).Encode(content); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range a.Blobs {
+		obj, err := app.GetBlob(ctx, &BlobData{ID: b.ID}, "", 0, -1)
+		if err != nil {
+			log.Println("missing blob", b.ID, err)
+			continue
+		}
+		f, err := z.Create(PROJECT_ARCHIVE_BLOBS + b.ID)
+		if err == nil {
+			_, err = io.Copy(f, obj)
+		}
+		obj.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return z.Close()
+}
+
+// readProjectArchive reads the content of the archive, after checking its
+// version.
+func readProjectArchive(z *zip.Reader) (ProjectArchive, error) {
+	var manifest ProjectArchiveManifest
+	var a ProjectArchive
+	if err := readArchiveFile(z, PROJECT_ARCHIVE_MANIFEST, &manifest); err != nil {
+		return a, err
+	}
+	if manifest.Version < 1 || manifest.Version > PROJECT_ARCHIVE_VERSION {
+		return a, errors.New("unsupported-archive-version")
+	}
+	err := readArchiveFile(z, PROJECT_ARCHIVE_CONTENT, &a)
+	return a, err
+}
+
+// readArchiveFile decodes a file of the archive, no larger than the archive
+// itself may be, whatever the compression ratio.
+func readArchiveFile(z *zip.Reader, name string, v interface{}) error {
+	maxSize := projectImportMaxSize()
+	for _, f := range z.File {
+		if f.Name != name {
+			continue
+		}
+		if f.UncompressedSize64 > uint64(maxSize) {
+			return errors.New("archive-too-large")
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		if err = json.NewDecoder(io.LimitReader(rc, maxSize)).Decode(v); err != nil {
+			log.Println(err)
+			return errors.New("invalid-archive")
+		}
+		return nil
+	}
+	return errors.New("invalid-archive")
+}
+
+// projectImportMaxSize is the largest archive accepted by imports, set in
+// PROJECT_IMPORT_MAX_MB.
+func projectImportMaxSize() int64 {
+	mb, err := strconv.Atoi(os.Getenv("PROJECT_IMPORT_MAX_MB"))
+	if err != nil || mb < 1 {
+		mb = 1024
+	}
+	return int64(mb) << 20
+}
+
+// importedSize is the size of the blobs stored by an import of the archive,
+// as found in the zip entries rather than as declared in the archive.
+func (a *ProjectArchive) importedSize(z *zip.Reader) int64 {
+	entries := map[string]int64{}
+	for _, f := range z.File {
+		entries[f.Name] = int64(f.UncompressedSize64)
+	}
+	var size int64
+	for _, b := range a.Blobs {
+		size += entries[PROJECT_ARCHIVE_BLOBS+b.ID]
+	}
+	return size
+}
+
+// storedSize is the size of the blobs of a project of this instance, as
+// loaded by getProjectArchive.
+func (a *ProjectArchive) storedSize() int64 {
+	var size int64
+	for _, b := range a.Blobs {
+		size += b.Size
+	}
+	return size
+}
+
+// exceededQuota returns the error of the quota the archive, with blobs of
+// the given size, does not fit in, or an empty string.
+func (a *ProjectArchive) exceededQuota(account BillingAccount, size int64) (string, error) {
+	checks := []struct {
+		table string
+		n     int
+		code  string
+	}{
+		{"projects", 1, "too-many-projects"},
+		{"scopes", len(a.Scopes), "too-many-scopes"},
+		{"scenarios", len(a.Scenarios), "too-many-scenarios"},
+		{"sessions", len(a.Sessions), "too-many-sessions"},
+	}
+	for _, c := range checks {
+		isEligible, err := account.isEligibleToCreate(c.table, c.n)
+		if err != nil || !isEligible {
+			return c.code, err
+		}
+	}
+	isEligible, err := account.isEligibleToStore(size)
+	if err != nil || !isEligible {
+		return "storage-quota-exceeded", err
+	}
+	return "", nil
+}
+
+// ProjectDuplicateOptions select what a duplicate of a project takes along.
+// The scopes, scenarios, roles and attachments always are.
+type ProjectDuplicateOptions struct {
+	Name                 string `json:"name"`
+	OrganizationID       string `json:"organizationId"`
+	IncludeSessions      bool   `json:"includeSessions"`
+	IncludeCollaborators bool   `json:"includeCollaborators"`
+}
+
+// applyDuplicateOptions leaves out of the archive what the duplicate does
+// not take along.
+func (a *ProjectArchive) applyDuplicateOptions(options ProjectDuplicateOptions) {
+	if len(options.Name) > 0 {
+		a.Project.Name = options.Name
+	}
+	if !options.IncludeSessions {
+		a.Sessions = []ArchivedSession{}
+		a.Tests = []ArchivedTest{}
+	}
+	if !options.IncludeCollaborators {
+		a.Collaborators = []ArchivedCollaborator{}
+	}
+}
+
+// archivedBlobReader opens the content of a blob of the archive, with its
+// size.
+type archivedBlobReader func(b ArchivedBlob) (io.ReadCloser, int64, error)
+
+// importProjectArchive recreates the archive as a new project owned by the
+// user, under new IDs.
+func (app *App) importProjectArchive(ctx context.Context, z *zip.Reader, a *ProjectArchive, userID, organizationID string) (ProjectImportReport, error) {
+	return app.createFromArchive(ctx, a, userID, organizationID, func(b ArchivedBlob) (io.ReadCloser, int64, error) {
+		for _, f := range z.File {
+			if f.Name == PROJECT_ARCHIVE_BLOBS+b.ID {
+				rc, err := f.Open()
+				return rc, int64(f.UncompressedSize64), err
+			}
+		}
+		return nil, 0, errors.New("blob-missing")
+	})
+}
+
+// duplicateProjectArchive creates a copy of a project of this instance, as
+// loaded by getProjectArchive, owned by the user.
+func (app *App) duplicateProjectArchive(ctx context.Context, a *ProjectArchive, userID, organizationID string) (ProjectImportReport, error) {
+	return app.createFromArchive(ctx, a, userID, organizationID, func(b ArchivedBlob) (io.ReadCloser, int64, error) {
+		rc, err := app.GetBlob(ctx, &BlobData{ID: b.ID}, "", 0, -1)
+		return rc, b.Size, err
+	})
+}
+
+// createFromArchive creates the project of the archive. Blobs are stored
+// first, and released if the project cannot be created.
+func (app *App) createFromArchive(ctx context.Context, a *ProjectArchive, userID, organizationID string, open archivedBlobReader) (ProjectImportReport, error) {
+	report := ProjectImportReport{
+		IDs:       map[string]string{},
+		Conflicts: []ProjectImportConflict{},
+	}
+
+	blobIDs := []string{}
+	for _, b := range a.Blobs {
+		id, err := app.copyArchivedBlob(ctx, b, open)
+		if err != nil {
+			log.Println(err)
+			report.conflict(IMPORT_CONFLICT_BLOB_MISSING, b.ID, "skipped")
+			continue
+		}
+		report.IDs[b.ID] = id
+		blobIDs = append(blobIDs, id)
+	}
+
+	err := report.importArchive(a, userID, organizationID, blobIDs)
+	if err != nil {
+		for _, id := range blobIDs {
+			if err := app.DeleteBlob(ctx, &BlobData{ID: id}); err != nil {
+				log.Println(err)
+			}
+		}
+		return report, err
+	}
+
+	report.inviteCollaborators(a, userID)
+	return report, nil
+}
+
+// inviteCollaborators invites the collaborators of the archive to the
+// project by email. They are not granted any access until they accept, an
+// archive cannot give access to the accounts of others.
+func (r *ProjectImportReport) inviteCollaborators(a *ProjectArchive, userID string) {
+	inviter := User{ID: userID}
+	if err := inviter.getUser(); err != nil {
+		log.Println(err)
+	}
+	for _, c := range a.Collaborators {
+		if strings.EqualFold(c.EmailAddress, inviter.EmailAddress) {
+			continue
+		}
+		invitation := Invitation{
+			ProjectID:    r.ProjectID,
+			EmailAddress: c.EmailAddress,
+			Access:       c.Access,
+			InvitedBy:    userID,
+		}
+		switch c.Access {
+		case "OWNER":
+			// The project has a single owner, the user importing it
+			invitation.Access = "MODIFY"
+			r.conflict(IMPORT_CONFLICT_OWNER, c.EmailAddress, invitation.Access)
+		case ACL_CUSTOM:
+			invitation.RoleID = r.IDs[c.RoleID]
+			if len(invitation.RoleID) < 1 {
+				invitation.Access = "READ"
+				r.conflict(IMPORT_CONFLICT_ROLE_NOT_FOUND, c.EmailAddress, invitation.Access)
+			}
+		default:
+			access := Acl{ObjectID: r.ProjectID, Access: c.Access}
+			if err := access.validate(); err != nil {
+				invitation.Access = "READ"
+				r.conflict(IMPORT_CONFLICT_ACCESS_LEVEL, c.EmailAddress, invitation.Access)
+			}
+		}
+		token, err := invitation.createInvitation()
+		if err == nil {
+			err = sendInvitation(&invitation, token, inviter.EmailAddress)
+		}
+		if err != nil {
+			log.Println(err)
+			r.conflict(IMPORT_CONFLICT_INVITED, c.EmailAddress, "skipped")
+			continue
+		}
+		r.conflict(IMPORT_CONFLICT_INVITED, c.EmailAddress, invitation.Access)
+	}
+}
+
+func (app *App) copyArchivedBlob(ctx context.Context, b ArchivedBlob, open archivedBlobReader) (string, error) {
+	rc, size, err := open(b)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	req := &BlobData{
+		Filename:    b.Filename,
+		ContentType: b.ContentType,
+		Size:        size,
+		Metadata:    b.Metadata,
+		Bucket:      DEFAULT_BUCKET,
+	}
+	id, err := app.PutBlob(ctx, req, rc)
+	if err != nil {
+		return "", err
+	}
+	return id.ID, nil
+}
+
+// remapText replaces the IDs of the archive found in free text, such as
+// links to blobs in steps and notes.
+func (r *ProjectImportReport) remapText(text string) string {
+	for oldID, newID := range r.IDs {
+		if strings.Contains(text, oldID) {
+			text = strings.ReplaceAll(text, oldID, newID)
+		}
+	}
+	return text
+}
+
+func (r *ProjectImportReport) remapSteps(steps []Step) string {
+	if steps == nil {
+		steps = []Step{}
+	}
+	for i := range steps {
+		steps[i].Step = r.remapText(steps[i].Step)
+		steps[i].Expectation = r.remapText(steps[i].Expectation)
+	}
+	jsonBytes, _ := json.Marshal(steps)
+	return string(jsonBytes)
+}
+
+// importArchive creates the content of the project in one transaction.
+func (r *ProjectImportReport) importArchive(a *ProjectArchive, userID, organizationID string, blobIDs []string) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+	INSERT INTO projects (name, description, organization_id) VALUES ($1, $2, NULLIF($3, '')::uuid)
+	RETURNING id
+	`, a.Project.Name, a.Project.Description, organizationID).Scan(&r.ProjectID)
+	if err != nil {
+		return err
+	}
+	owner := Acl{ObjectID: r.ProjectID, ObjectType: "project", UserID: userID, Access: "OWNER"}
+	if err = owner.setAccessTx(tx); err != nil {
+		return err
+	}
+
+	for _, role := range a.Roles {
+		permissions := []string{}
+		for _, permission := range role.Permissions {
+			if isValidPermission(permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+		var id string
+		err = tx.QueryRow(`
+		INSERT INTO roles (project_id, name, permissions) VALUES ($1, $2, $3) RETURNING id
+		`, r.ProjectID, role.Name, pq.Array(permissions)).Scan(&id)
+		if err != nil {
+			return err
+		}
+		r.IDs[role.ID] = id
+	}
+
+	// Users are matched by email address
+	users := map[string]string{}
+	getUserID := func(email string) (string, bool) {
+		if id, ok := users[email]; ok {
+			return id, len(id) > 0
+		}
+		user := User{EmailAddress: email}
+		if err := user.getUser(); err != nil {
+			users[email] = ""
+			return "", false
+		}
+		users[email] = user.ID
+		return user.ID, true
+	}
+
+	for _, s := range a.Scopes {
+		var id string
+		err = tx.QueryRow(`
+		INSERT INTO scopes (name, project_id) VALUES ($1, $2) RETURNING id
+		`, s.Name, r.ProjectID).Scan(&id)
+		if err != nil {
+			return err
+		}
+		r.IDs[s.ID] = id
+	}
+
+	for _, s := range a.Scenarios {
+		scopeID, ok := r.IDs[s.ScopeID]
+		if !ok {
+			continue
+		}
+		var id string
+		err = tx.QueryRow(`
+		INSERT INTO scenarios (name, scope_id, project_id, steps) VALUES ($1, $2, $3, $4) RETURNING id
+		`, s.Name, scopeID, r.ProjectID, r.remapSteps(s.Steps)).Scan(&id)
+		if err != nil {
+			return err
+		}
+		r.IDs[s.ID] = id
+	}
+
+	for _, s := range a.Sessions {
+		authorID, ok := getUserID(s.AuthorEmail)
+		if !ok {
+			authorID = userID
+			r.conflict(IMPORT_CONFLICT_USER_NOT_FOUND, s.AuthorEmail, "author replaced")
+		}
+		scenarios := []string{}
+		for _, scenarioID := range s.Scenarios {
+			if id, ok := r.IDs[scenarioID]; ok {
+				scenarios = append(scenarios, id)
+			}
+		}
+		var id string
+		err = tx.QueryRow(`
+		INSERT INTO sessions (project_id, author_id, version, description, status, scenarios, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, '')::timestamp, NOW()))
+		RETURNING id
+		`,
+			r.ProjectID,
+			authorID,
+			s.Version,
+			s.Description,
+			s.Status,
+			pq.Array(scenarios),
+			s.CreatedAt,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		r.IDs[s.ID] = id
+	}
+
+	for _, t := range a.Tests {
+		// Closed sessions keep the tests of deleted scenarios, left out
+		sessionID, ok := r.IDs[t.SessionID]
+		scenarioID, found := r.IDs[t.ScenarioID]
+		if !ok || !found {
+			continue
+		}
+		assigneeID, ok := getUserID(t.AssigneeEmail)
+		if !ok {
+			assigneeID = userID
+			r.conflict(IMPORT_CONFLICT_USER_NOT_FOUND, t.AssigneeEmail, "assignee replaced")
+		}
+		var id string
+		err = tx.QueryRow(`
+		INSERT INTO tests (session_id, assignee_id, scenario_id, steps, status, notes, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, '')::timestamp, NOW()))
+		RETURNING id
+		`,
+			sessionID,
+			assigneeID,
+			scenarioID,
+			r.remapSteps(t.Steps),
+			t.Status,
+			r.remapText(t.Notes),
+			t.CreatedAt,
+		).Scan(&id)
+		if err != nil {
+			return err
+		}
+		r.IDs[t.ID] = id
+	}
+
+	for _, id := range blobIDs {
+		_, err = tx.Exec(`
+		UPDATE blobs SET project_id=$2 WHERE id::text=$1
+		`, id, r.ProjectID)
+		if err != nil {
+			return err
+		}
+		access := Acl{ObjectID: id, ObjectType: "blob", UserID: userID, Access: "OWNER"}
+		if err = access.setAccessTx(tx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
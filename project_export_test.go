@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func importTestArchive(token string, archive []byte) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "project.zip")
+	part.Write(archive)
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/api/project-import", body)
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return executeRequest(req)
+}
+
+func TestProjectExportImport(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ := http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	upload := &bytes.Buffer{}
+	writer := multipart.NewWriter(upload)
+	writer.WriteField("projectId", projectId)
+	part, _ := writer.CreateFormFile("file", "screenshot.png")
+	part.Write([]byte("screenshot"))
+	writer.Close()
+	req, _ = http.NewRequest("POST", "/api/blob", upload)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var blob map[string]string
+	json.Unmarshal(response.Body.Bytes(), &blob)
+	blobId := blob["ID"]
+
+	scope := createTestObject(t, testUserToken1, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	scenario := createTestObject(t, testUserToken1, "/api/scenario",
+		`{"name":"test scenario","projectId":"`+projectId+`","scopeId":"`+scopeId+`",`+
+			`"steps":[{"step":"Open /api/blob/`+blobId+`","expectation":"Shown"}]}`)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+	session := createTestObject(t, testUserToken1, "/api/session",
+		`{"version":"1.0","projectId":"`+projectId+`","scenarios":[{"id":"`+scenarioId+`"}]}`)
+	sessionId := fmt.Sprintf("%s", session["id"])
+	createTestObject(t, testUserToken1, "/api/test", `{"sessionId":"`+sessionId+`","scenarioId":"`+scenarioId+`"}`)
+
+	req, _ = http.NewRequest("GET", "/api/project-export/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/zip", response.Header().Get("Content-Type"))
+	archive := response.Body.Bytes()
+
+	z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	assert.Equal(t, nil, err)
+	var manifest ProjectArchiveManifest
+	assert.Equal(t, nil, readArchiveFile(z, PROJECT_ARCHIVE_MANIFEST, &manifest))
+	assert.Equal(t, PROJECT_ARCHIVE_VERSION, manifest.Version)
+	content, err := readProjectArchive(z)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(content.Scenarios))
+	assert.Equal(t, 1, len(content.Tests))
+	assert.Equal(t, 2, len(content.Collaborators))
+
+	// The collaborator imports it as a new project of their own
+	response = importTestArchive(testUserToken2, archive)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var report ProjectImportReport
+	json.Unmarshal(response.Body.Bytes(), &report)
+	assert.NotEqual(t, projectId, report.ProjectID)
+	assert.Equal(t, 2, len(report.Conflicts))
+	assert.Equal(t, IMPORT_CONFLICT_OWNER, report.Conflicts[0].Type)
+	assert.Equal(t, "padfoot.tgz@gmail.com", report.Conflicts[0].Reference)
+	assert.Equal(t, IMPORT_CONFLICT_INVITED, report.Conflicts[1].Type)
+	assert.Equal(t, "MODIFY", report.Conflicts[1].Resolution)
+
+	// The previous owner is invited, without any access until accepting
+	invitations, err := getInvitations(report.ProjectID)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(invitations))
+	assert.Equal(t, "padfoot.tgz@gmail.com", invitations[0].EmailAddress)
+	req, _ = http.NewRequest("GET", "/api/project/"+report.ProjectID, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/scenario/"+report.IDs[scenarioId], nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "/api/blob/"+report.IDs[blobId])
+
+	req, _ = http.NewRequest("GET", "/api/session/"+report.IDs[sessionId], nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), report.IDs[scenarioId])
+
+	req, _ = http.NewRequest("GET", "/api/blob/"+report.IDs[blobId], nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "screenshot", response.Body.String())
+
+	// Archives of a later version are refused
+	var future bytes.Buffer
+	w := zip.NewWriter(&future)
+	f, _ := w.Create(PROJECT_ARCHIVE_MANIFEST)
+	f.Write([]byte(`{"version":99}`))
+	w.Close()
+	response = importTestArchive(testUserToken2, future.Bytes())
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), "unsupported-archive-version")
+}
+
+func TestProjectTemplateDuplicate(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"template"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	scope := createTestObject(t, testUserToken1, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	scenario := createTestObject(t, testUserToken1, "/api/scenario",
+		`{"name":"test scenario","projectId":"`+projectId+`","scopeId":"`+scopeId+`","steps":[]}`)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+	createTestObject(t, testUserToken1, "/api/session",
+		`{"version":"1.0","projectId":"`+projectId+`","scenarios":[{"id":"`+scenarioId+`"}]}`)
+
+	req, _ := http.NewRequest("PUT", "/api/project-template/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// Templates are listed to those with access to them
+	var projects []Project
+	req, _ = http.NewRequest("GET", "/api/projects?template=true", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &projects)
+	assert.Equal(t, 1, len(projects))
+	assert.Equal(t, true, projects[0].IsTemplate)
+
+	req, _ = http.NewRequest("GET", "/api/projects?template=true", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	projects = nil
+	json.Unmarshal(response.Body.Bytes(), &projects)
+	assert.Equal(t, 0, len(projects))
+
+	req, _ = http.NewRequest("POST", "/api/project-duplicate/"+projectId, bytes.NewBuffer([]byte(`{}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Readers duplicate it, but cannot invite its collaborators
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	req, _ = http.NewRequest("PUT", "/api/invite-settings/"+projectId,
+		bytes.NewBuffer([]byte(`{"inviteEnabled":true,"inviteAccess":"READ"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/project-duplicate/"+projectId, bytes.NewBuffer([]byte(`{"includeCollaborators":true}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("POST", "/api/project-duplicate/"+projectId, bytes.NewBuffer([]byte(`{}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+
+	// Created from the template, without its sessions
+	req, _ = http.NewRequest("POST", "/api/project-duplicate/"+projectId, bytes.NewBuffer([]byte(`{"name":"new product"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var report ProjectImportReport
+	json.Unmarshal(response.Body.Bytes(), &report)
+	assert.NotEqual(t, projectId, report.ProjectID)
+	assert.NotEqual(t, "", report.IDs[scenarioId])
+
+	req, _ = http.NewRequest("GET", "/api/project/"+report.ProjectID, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	var copied Project
+	json.Unmarshal(response.Body.Bytes(), &copied)
+	assert.Equal(t, "new product", copied.Name)
+	assert.Equal(t, false, copied.IsTemplate)
+
+	var sessions []map[string]interface{}
+	req, _ = http.NewRequest("GET", "/api/sessions?projectId="+report.ProjectID, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &sessions)
+	assert.Equal(t, 0, len(sessions))
+
+	// A duplicate with the sessions keeps them, on the copied scenarios
+	req, _ = http.NewRequest("POST", "/api/project-duplicate/"+projectId, bytes.NewBuffer([]byte(`{"includeSessions":true}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	report = ProjectImportReport{}
+	json.Unmarshal(response.Body.Bytes(), &report)
+
+	req, _ = http.NewRequest("GET", "/api/sessions?projectId="+report.ProjectID, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	sessions = nil
+	json.Unmarshal(response.Body.Bytes(), &sessions)
+	assert.Equal(t, 1, len(sessions))
+	assert.Contains(t, response.Body.String(), report.IDs[scenarioId])
+}
+
+func TestProjectImportSize(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	// The declared sizes are not trusted, the entries are measured
+	buf := &bytes.Buffer{}
+	z := zip.NewWriter(buf)
+	f, _ := z.Create(PROJECT_ARCHIVE_BLOBS + "blob")
+	f.Write(bytes.Repeat([]byte("x"), 1000))
+	z.Close()
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Equal(t, nil, err)
+	archive := ProjectArchive{Blobs: []ArchivedBlob{{ID: "blob", Size: 1}, {ID: "missing", Size: 1}}}
+	assert.Equal(t, int64(1000), archive.importedSize(r))
+
+	os.Setenv("PROJECT_IMPORT_MAX_MB", "1")
+	defer os.Unsetenv("PROJECT_IMPORT_MAX_MB")
+	response := importTestArchive(testUserToken1, bytes.Repeat([]byte("x"), 2<<20))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	assert.Contains(t, response.Body.String(), "archive-too-large")
+
+	// Small once compressed, the content is still too large
+	buf.Reset()
+	z = zip.NewWriter(buf)
+	f, _ = z.Create(PROJECT_ARCHIVE_MANIFEST)
+	f.Write([]byte(`{"version":1}`))
+	f, _ = z.Create(PROJECT_ARCHIVE_CONTENT)
+	f.Write(bytes.Repeat([]byte(" "), 2<<20))
+	z.Close()
+	assert.Less(t, buf.Len(), 1<<20)
+	response = importTestArchive(testUserToken1, buf.Bytes())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	assert.Contains(t, response.Body.String(), "archive-too-large")
+}
+
+func TestProjectExportMissingBlob(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	upload := &bytes.Buffer{}
+	writer := multipart.NewWriter(upload)
+	writer.WriteField("projectId", projectId)
+	part, _ := writer.CreateFormFile("file", "screenshot.png")
+	part.Write([]byte("lost screenshot"))
+	writer.Close()
+	req, _ := http.NewRequest("POST", "/api/blob", upload)
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var blob map[string]string
+	json.Unmarshal(response.Body.Bytes(), &blob)
+
+	// The object is lost from the storage, the archive is still complete
+	data := BlobData{ID: blob["ID"]}
+	assert.Equal(t, nil, data.getBlob())
+	assert.Equal(t, nil, app.Storage.Delete(context.Background(), data.Bucket, data.objectKey()))
+
+	req, _ = http.NewRequest("GET", "/api/project-export/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	archive := response.Body.Bytes()
+	z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	assert.Equal(t, nil, err)
+	content, err := readProjectArchive(z)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(content.Blobs))
+	for _, f := range z.File {
+		assert.NotEqual(t, PROJECT_ARCHIVE_BLOBS+blob["ID"], f.Name)
+	}
+}
@@ -22,6 +22,10 @@ func (app *App) getProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
 	p := Project{ID: id}
 	if err = p.getProject(); err != nil {
 		switch err {
@@ -55,7 +59,8 @@ func (app *App) getProjects(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	projects, err := getProjects(start, count, currentUser.(*User).ID)
+	templatesOnly := r.FormValue("template") == "true"
+	projects, err := getProjects(start, count, currentUser.(*User).ID, templatesOnly)
 	if err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
@@ -78,10 +83,17 @@ func (app *App) createProject(w http.ResponseWriter, r *http.Request) {
 
 	currentUser := r.Context().Value("currentUser").(*User)
 
+	// Members of the organization create its projects
+	if len(p.OrganizationID) > 0 {
+		if _, ok := authorizeOrganization(w, r, p.OrganizationID, ORG_ROLES[:]...); !ok {
+			return
+		}
+	}
+
 	gb := NewGrowthBook(app.GBFeatures, "")
 	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
 	if isContentCreationLimiterEnabled {
-		isEligible, err := isEligibleToCreateProject(currentUser.ID)
+		isEligible, err := isEligibleToCreateProject(currentUser.ID, p.OrganizationID)
 		if err != nil {
 			log.Println(err)
 			respondError(w, http.StatusInternalServerError, err.Error())
@@ -125,6 +137,10 @@ func (app *App) updateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_EDIT) {
+		return
+	}
+
 	var p Project
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&p); err != nil {
@@ -154,8 +170,65 @@ func (app *App) deleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
 	p := Project{ID: id}
-	if err := p.deleteProject(); err != nil {
+	currentUser := r.Context().Value("currentUser").(*User)
+	if err := p.deleteProject(currentUser.ID); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// archiveProject makes a finished project read-only, unlike deleting it.
+func (app *App) archiveProject(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorizeAccess(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
+	p := Project{ID: id}
+	currentUser := r.Context().Value("currentUser").(*User)
+	if err := p.archiveProject(currentUser.ID); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"archivedAt": p.ArchivedAt})
+}
+
+func (app *App) unarchiveProject(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorizeAccess(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
+	p := Project{ID: id}
+	if err := p.unarchiveProject(); err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -164,6 +237,41 @@ func (app *App) deleteProject(w http.ResponseWriter, r *http.Request) {
 	respond(w, http.StatusOK, map[string]string{"result": "success"})
 }
 
+// markTemplate lists the project among the templates, archived projects
+// included, to create new projects from.
+func (app *App) markTemplate(w http.ResponseWriter, r *http.Request) {
+	app.setTemplate(w, r, true)
+}
+
+func (app *App) unmarkTemplate(w http.ResponseWriter, r *http.Request) {
+	app.setTemplate(w, r, false)
+}
+
+func (app *App) setTemplate(w http.ResponseWriter, r *http.Request, isTemplate bool) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorizeAccess(w, r, id, PERMISSION_EDIT) {
+		return
+	}
+
+	p := Project{ID: id}
+	if err := p.setTemplate(isTemplate); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]bool{"isTemplate": p.IsTemplate})
+}
+
 func (app *App) getInvitation(w http.ResponseWriter, r *http.Request) {
 	var err error
 	vars := mux.Vars(r)
@@ -176,12 +284,7 @@ func (app *App) getInvitation(w http.ResponseWriter, r *http.Request) {
 
 	p := Project{InviteCode: id}
 	if err = p.getInvitation(); err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			respondError(w, http.StatusNotFound, "item-not-found")
-		default:
-			respondError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondInvitationError(w, err)
 		return
 	}
 
@@ -215,32 +318,120 @@ func (app *App) acceptInvitation(w http.ResponseWriter, r *http.Request) {
 
 	p := Project{InviteCode: id}
 	if err = p.getInvitation(); err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			respondError(w, http.StatusNotFound, "item-not-found")
-		default:
-			respondError(w, http.StatusInternalServerError, err.Error())
-		}
+		respondInvitationError(w, err)
 		return
 	}
 
 	currentUser := r.Context().Value("currentUser").(*User)
 	access := Acl{
-		ObjectID:   p.ID,
-		ObjectType: "project",
-		UserID:     currentUser.ID,
-		Access:     "MODIFY",
+		ObjectID: p.ID,
+		UserID:   currentUser.ID,
 	}
-	err = access.createAccess()
-	if err != nil {
+	err = access.getAccess()
+	if err != nil && err != sql.ErrNoRows {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	// Members keep their access, without using the invite
+	if err == sql.ErrNoRows {
+		if err = p.joinByInvitation(currentUser.ID); err != nil {
+			log.Println(err)
+			respondInvitationError(w, err)
+			return
+		}
+		recordActivity(r, p.ID, ACTIVITY_COLLABORATOR, currentUser.ID, ACTIVITY_JOINED,
+			currentUser.EmailAddress+" ("+p.InviteAccess+")")
+	}
 
 	respond(w, http.StatusOK, p)
 }
 
+func respondInvitationError(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		respondError(w, http.StatusNotFound, "item-not-found")
+		return
+	}
+	switch err.Error() {
+	case "invite-expired", "invite-disabled", "invite-limit-reached", "invalid-role":
+		respondError(w, http.StatusGone, err.Error())
+	default:
+		respondError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// updateInviteSettings enables or disables joining by the invite link, and
+// sets the access it gives and how many times it can be used.
+func (app *App) updateInviteSettings(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	var s InviteSettings
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&s); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	p := Project{ID: id, InviteSettings: s}
+	if err = p.updateInviteSettings(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+			return
+		}
+		switch err.Error() {
+		case "invalid-max-uses", "invalid-access-level", "invalid-role":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, p)
+}
+
+// rotateInviteCode replaces a leaked invite link, the previous code is
+// then "invite-expired".
+func (app *App) rotateInviteCode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	p := Project{ID: id}
+	if err = p.rotateInviteCode(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"inviteCode": p.InviteCode})
+}
+
 func (app *App) getCollaborators(w http.ResponseWriter, r *http.Request) {
 	var err error
 	vars := mux.Vars(r)
@@ -251,6 +442,10 @@ func (app *App) getCollaborators(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
 	collaborators, err := getCollaborators(id)
 	if err != nil {
 		log.Println(err)
@@ -277,6 +472,22 @@ func (app *App) revokeCollaborator(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "invalid-id")
 		return
 	}
+	if !authorize(w, r, projectId, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	// The owner hands the project over instead
+	current := Acl{ObjectID: projectId, UserID: userId}
+	err = current.getAccess()
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err == nil && current.Access == "OWNER" {
+		respondError(w, http.StatusConflict, "owner-access")
+		return
+	}
 
 	access := Acl{
 		ObjectID:   projectId,
@@ -290,5 +501,194 @@ func (app *App) revokeCollaborator(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The access to the project applies to everything in it
+	if err := dropChildAccess(projectId, userId); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := cancelOwnershipTransfers(projectId, userId); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	revoked := User{ID: userId}
+	if err := revoked.getUser(); err != nil {
+		log.Println(err)
+	}
+	recordActivity(r, projectId, ACTIVITY_COLLABORATOR, userId, ACTIVITY_REVOKED, revoked.EmailAddress)
+
 	respond(w, http.StatusOK, nil)
 }
+
+// updateCollaboratorAccess changes the access level of a collaborator. The
+// owner is changed by an ownership transfer instead.
+func (app *App) updateCollaboratorAccess(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectId := vars["projectId"]
+	userId := vars["userId"]
+	if _, err := uuidParser.Parse(projectId); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+	if _, err := uuidParser.Parse(userId); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, projectId, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	var p struct {
+		Access string `json:"access"`
+		RoleID string `json:"roleId"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if p.Access == "OWNER" {
+		respondError(w, http.StatusBadRequest, "invalid-access-level")
+		return
+	}
+
+	access := Acl{ObjectID: projectId, UserID: userId}
+	if err := access.getAccess(); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "collaborator-not-found")
+		} else {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if access.Access == "OWNER" {
+		respondError(w, http.StatusConflict, "owner-access")
+		return
+	}
+	access.ObjectType = "project"
+	access.Access = p.Access
+	access.RoleID = p.RoleID
+
+	permissions, err := access.getGrantedPermissions()
+	if err == nil && !authorizeGrant(w, r, projectId, permissions) {
+		return
+	}
+	if err == nil {
+		err = access.setAccess()
+	}
+	if err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-access-level", "invalid-role":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// The access to the project applies to everything in it
+	if err := dropChildAccess(projectId, userId); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// setAccessOverride gives a user a different access to a single object than
+// the one inherited from its project.
+func (app *App) setAccessOverride(w http.ResponseWriter, r *http.Request) {
+	objectType, access, ok := app.accessOverride(w, r)
+	if !ok {
+		return
+	}
+
+	var p Acl
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	// Owners are owners of the whole project
+	if p.Access == "OWNER" {
+		respondError(w, http.StatusBadRequest, "invalid-access-level")
+		return
+	}
+	access.ObjectType = objectType
+	access.Access = p.Access
+	access.RoleID = p.RoleID
+
+	if err := access.setAccess(); err != nil {
+		log.Println(err)
+		if err.Error() == "invalid-access-level" || err.Error() == "invalid-role" {
+			respondError(w, http.StatusBadRequest, err.Error())
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// dropAccessOverride brings back the access inherited from the project.
+func (app *App) dropAccessOverride(w http.ResponseWriter, r *http.Request) {
+	objectType, access, ok := app.accessOverride(w, r)
+	if !ok {
+		return
+	}
+	access.ObjectType = objectType
+
+	if err := access.dropAccess(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// accessOverride validates an access override request. Overrides apply to
+// child objects only and can be managed by the collaborators allowed to
+// manage the collaborators of the object.
+func (app *App) accessOverride(w http.ResponseWriter, r *http.Request) (string, Acl, bool) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	userId := vars["userId"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return "", Acl{}, false
+	}
+	if _, err := uuidParser.Parse(userId); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return "", Acl{}, false
+	}
+
+	objectType, _, err := getParentObject(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusBadRequest, "invalid-object")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return "", Acl{}, false
+	}
+
+	if !authorize(w, r, id, PERMISSION_MANAGE_COLLABORATORS) {
+		return "", Acl{}, false
+	}
+
+	return objectType, Acl{ObjectID: id, UserID: userId}, true
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 )
 
@@ -9,16 +11,41 @@ type Project struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	InviteCode  string `json:"inviteCode"`
-	Access      string `json:"access"`
-	AuthorName  string `json:"authorName"`
-	CreatedAt   string `json:"createdAt"`
+	InviteSettings
+	OrganizationID string `json:"organizationId"`
+	ArchivedAt     string `json:"archivedAt"`
+	IsTemplate     bool   `json:"isTemplate"`
+	Access         string `json:"access"`
+	AuthorName     string `json:"authorName"`
+	CreatedAt      string `json:"createdAt"`
 }
 
+// InviteSettings controls who can join with the invite link of the project,
+// and with which access. InviteMaxUses of 0 is unlimited.
+type InviteSettings struct {
+	InviteEnabled bool   `json:"inviteEnabled"`
+	InviteAccess  string `json:"inviteAccess"`
+	InviteRoleID  string `json:"inviteRoleId"`
+	InviteMaxUses int    `json:"inviteMaxUses"`
+	InviteUses    int    `json:"inviteUses"`
+}
+
+// Sources of the access of the collaborators
+const (
+	COLLABORATOR_SOURCE_DIRECT = "direct"
+	COLLABORATOR_SOURCE_TEAM   = "team"
+)
+
 type Collaborator struct {
 	ID           string `json:"id"`
 	Username     string `json:"username"`
 	EmailAddress string `json:"emailAddress"`
 	Access       string `json:"access"`
+	RoleID       string `json:"roleId"`
+	RoleName     string `json:"roleName"`
+	Source       string `json:"source"`
+	TeamID       string `json:"teamId"`
+	TeamName     string `json:"teamName"`
 	CreatedAt    string `json:"createdAt"`
 }
 
@@ -34,36 +61,72 @@ type Collaborators struct {
 
 func (p *Project) getProject() error {
 	return app.DB.QueryRow(`
-	SELECT name, description, invite_code FROM projects WHERE id=$1
+	SELECT name, description, invite_code, invite_enabled, invite_access,
+	COALESCE(invite_role_id, ''), invite_max_uses, invite_uses,
+	COALESCE(organization_id::text, ''), COALESCE(archived_at::text, ''), is_template
+	FROM projects WHERE id=$1
 	AND deleted_at IS NULL
 	`,
-		p.ID).Scan(&p.Name, &p.Description, &p.InviteCode)
+		p.ID).Scan(
+		&p.Name,
+		&p.Description,
+		&p.InviteCode,
+		&p.InviteEnabled,
+		&p.InviteAccess,
+		&p.InviteRoleID,
+		&p.InviteMaxUses,
+		&p.InviteUses,
+		&p.OrganizationID,
+		&p.ArchivedAt,
+		&p.IsTemplate,
+	)
 }
 
-func (p *Project) deleteProject() error {
+// archiveProject makes the project read-only, it stays listed.
+func (p *Project) archiveProject(archivedBy string) error {
+	return app.DB.QueryRow(`
+	UPDATE projects SET archived_at=COALESCE(archived_at, NOW()), archived_by=COALESCE(archived_by, $2)
+	WHERE id=$1 AND deleted_at IS NULL
+	RETURNING archived_at::text
+	`, p.ID, archivedBy).Scan(&p.ArchivedAt)
+}
+
+func (p *Project) unarchiveProject() error {
 	_, err := app.DB.Exec(`
-	UPDATE projects SET deleted_at=NOW() WHERE id=$1;
-	`, p.ID)
-	if err != nil {
-		return err
-	}
-	_, err = app.DB.Exec(`
-	UPDATE scopes SET deleted_at=NOW() WHERE project_id=$1;
-	`, p.ID)
-	if err != nil {
-		return err
-	}
-	_, err = app.DB.Exec(`
-	UPDATE scenarios SET deleted_at=NOW() WHERE project_id=$1;
+	UPDATE projects SET archived_at=NULL, archived_by=NULL WHERE id=$1
 	`, p.ID)
+	p.ArchivedAt = ""
 	return err
 }
 
+// setTemplate lists the project among the templates new projects are
+// created from, or takes it off.
+func (p *Project) setTemplate(isTemplate bool) error {
+	return app.DB.QueryRow(`
+	UPDATE projects SET is_template=$2, updated_at=NOW()
+	WHERE id=$1 AND deleted_at IS NULL
+	RETURNING is_template
+	`, p.ID, isTemplate).Scan(&p.IsTemplate)
+}
+
+func (p *Project) isArchived() (bool, error) {
+	var isArchived bool
+	err := app.DB.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM projects WHERE id::text=$1 AND archived_at IS NOT NULL)
+	`, p.ID).Scan(&isArchived)
+	return isArchived, err
+}
+
+// deleteProject moves the project to the recycle bin with everything in it.
+func (p *Project) deleteProject(deletedBy string) error {
+	return deleteObject("project", p.ID, deletedBy)
+}
+
 func (p *Project) createProject() error {
 	err := app.DB.QueryRow(`
-	INSERT INTO projects(name, description) VALUES($1, $2) RETURNING id
+	INSERT INTO projects(name, description, organization_id) VALUES($1, $2, NULLIF($3, '')::uuid) RETURNING id
   `,
-		p.Name, p.Description).Scan(&p.ID)
+		p.Name, p.Description, p.OrganizationID).Scan(&p.ID)
 
 	if err != nil {
 		log.Println(err)
@@ -73,18 +136,34 @@ func (p *Project) createProject() error {
 	return nil
 }
 
-func getProjects(start, count int, userId string) ([]Project, error) {
+// getProjects lists the projects the user has access to, only the templates
+// when templatesOnly is set.
+func getProjects(start, count int, userId string, templatesOnly bool) ([]Project, error) {
 	rows, err := app.DB.Query(`
-	SELECT projects.id, projects.name, projects.description, projects.created_at, users.email_address
-	FROM projects, access_control_lists acl, users
-	WHERE projects.deleted_at IS NULL AND
-	projects.id::text=acl.object_id::text AND acl.object_type='project' AND acl.user_id=$3
-	AND acl.user_id::text = users.id::text
-	GROUP BY projects.id, users.email_address
+	SELECT projects.id, projects.name, projects.description, projects.created_at,
+	COALESCE(projects.organization_id::text, ''), COALESCE(projects.archived_at::text, ''),
+	projects.is_template, users.email_address
+	FROM projects, users
+	WHERE projects.deleted_at IS NULL AND users.id::text=$3
+	AND (projects.is_template OR NOT $4) AND (
+	  EXISTS (
+	    SELECT 1 FROM access_control_lists acl
+	    WHERE projects.id::text=acl.object_id::text AND acl.object_type='project' AND acl.user_id=$3
+	  ) OR
+	  EXISTS (
+	    SELECT 1 FROM team_grants g, teams t, team_members m
+	    WHERE g.project_id=projects.id::text AND g.team_id=t.id AND t.deleted_at IS NULL
+	    AND m.team_id=t.id AND m.user_id=$3
+	  ) OR
+	  projects.organization_id IN (
+	    SELECT m.organization_id FROM organization_members m, organizations o
+	    WHERE m.organization_id=o.id AND o.deleted_at IS NULL AND m.user_id=$3
+	  )
+	)
 	ORDER BY projects.created_at ASC
 	LIMIT $1 OFFSET $2
   `,
-		count, start, userId)
+		count, start, userId, templatesOnly)
 
 	if err != nil {
 		log.Println(err)
@@ -102,6 +181,9 @@ func getProjects(start, count int, userId string) ([]Project, error) {
 			&p.Name,
 			&p.Description,
 			&p.CreatedAt,
+			&p.OrganizationID,
+			&p.ArchivedAt,
+			&p.IsTemplate,
 			&p.AuthorName,
 		); err != nil {
 			log.Println(err)
@@ -113,12 +195,154 @@ func getProjects(start, count int, userId string) ([]Project, error) {
 	return projects, nil
 }
 
+// getInvitation finds the project of the invite code. Rotated codes are
+// "invite-expired", codes of projects not accepting joins by link are
+// "invite-disabled" and codes used up are "invite-limit-reached".
 func (p *Project) getInvitation() error {
-	return app.DB.QueryRow(`
-	SELECT id, name, invite_code FROM projects WHERE invite_code=$1
+	err := app.DB.QueryRow(`
+	SELECT id, name, invite_code, invite_enabled, invite_access,
+	COALESCE(invite_role_id, ''), invite_max_uses, invite_uses
+	FROM projects WHERE invite_code::text=$1
 	AND deleted_at IS NULL
 	`,
-		p.InviteCode).Scan(&p.ID, &p.Name, &p.InviteCode)
+		p.InviteCode).Scan(
+		&p.ID,
+		&p.Name,
+		&p.InviteCode,
+		&p.InviteEnabled,
+		&p.InviteAccess,
+		&p.InviteRoleID,
+		&p.InviteMaxUses,
+		&p.InviteUses,
+	)
+	if err == sql.ErrNoRows {
+		var isRetired bool
+		err := app.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM retired_invite_codes WHERE invite_code::text=$1)
+		`, p.InviteCode).Scan(&isRetired)
+		if err != nil {
+			return err
+		}
+		if isRetired {
+			return errors.New("invite-expired")
+		}
+		return sql.ErrNoRows
+	}
+	if err != nil {
+		return err
+	}
+	if !p.InviteEnabled {
+		return errors.New("invite-disabled")
+	}
+	if p.InviteMaxUses > 0 && p.InviteUses >= p.InviteMaxUses {
+		return errors.New("invite-limit-reached")
+	}
+	return nil
+}
+
+// joinByInvitation gives the access of the invite link to the user and
+// counts the use, unless the limit has been reached meanwhile.
+func (p *Project) joinByInvitation(userID string) error {
+	access := Acl{
+		ObjectID:   p.ID,
+		ObjectType: "project",
+		UserID:     userID,
+		Access:     p.InviteAccess,
+		RoleID:     p.InviteRoleID,
+	}
+	if err := access.validate(); err != nil {
+		return err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+	UPDATE projects SET invite_uses=invite_uses+1
+	WHERE id=$1 AND invite_code::text=$2 AND invite_enabled
+	AND (invite_max_uses=0 OR invite_uses < invite_max_uses)
+	`, p.ID, p.InviteCode)
+	if err != nil {
+		return err
+	}
+	joined, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if joined < 1 {
+		return errors.New("invite-limit-reached")
+	}
+	if err = access.setAccessTx(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	p.InviteUses++
+	return nil
+}
+
+func (s *InviteSettings) validate(projectID string) error {
+	if s.InviteMaxUses < 0 {
+		return errors.New("invalid-max-uses")
+	}
+	if s.InviteAccess == "OWNER" {
+		return errors.New("invalid-access-level")
+	}
+	access := Acl{
+		ObjectID: projectID,
+		Access:   s.InviteAccess,
+		RoleID:   s.InviteRoleID,
+	}
+	if err := access.validate(); err != nil {
+		return err
+	}
+	s.InviteRoleID = access.RoleID
+	return nil
+}
+
+func (p *Project) updateInviteSettings() error {
+	if err := p.InviteSettings.validate(p.ID); err != nil {
+		return err
+	}
+	return app.DB.QueryRow(`
+	UPDATE projects SET invite_enabled=$1, invite_access=$2,
+	invite_role_id=NULLIF($3, ''), invite_max_uses=$4, updated_at=NOW()
+	WHERE id=$5 AND deleted_at IS NULL
+	RETURNING invite_code, invite_uses
+	`,
+		p.InviteEnabled, p.InviteAccess, p.InviteRoleID, p.InviteMaxUses, p.ID,
+	).Scan(&p.InviteCode, &p.InviteUses)
+}
+
+// rotateInviteCode replaces the invite code, the previous one expires and
+// the uses are counted again from zero.
+func (p *Project) rotateInviteCode() error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO retired_invite_codes (invite_code, project_id)
+	SELECT invite_code, id::text FROM projects WHERE id=$1 AND deleted_at IS NULL
+	`, p.ID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`
+	UPDATE projects SET invite_code=gen_random_uuid(), invite_uses=0, updated_at=NOW()
+	WHERE id=$1 AND deleted_at IS NULL
+	RETURNING invite_code
+	`, p.ID).Scan(&p.InviteCode)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (p *Project) updateProject() error {
@@ -134,13 +358,26 @@ func (p *Project) updateProject() error {
 
 func getCollaborators(projectId string) (*Collaborators, error) {
 	rows, err := app.DB.Query(`
-	SELECT users.id, users.user_name, users.email_address, acl.access, max(acl.created_at)
-	FROM users, access_control_lists acl WHERE
-	users.id::text=acl.user_id::text AND acl.object_id=$1
-	GROUP BY users.id, acl.access
-	ORDER BY max(acl.created_at)
+	SELECT * FROM (
+	  SELECT users.id, users.user_name, users.email_address, acl.access,
+	  COALESCE(roles.id::text, ''), COALESCE(roles.name, ''), $2::text AS source, '' AS team_id, '' AS team_name,
+	  max(acl.created_at) AS created_at
+	  FROM users, access_control_lists acl
+	  LEFT JOIN roles ON roles.id::text=acl.role_id AND roles.deleted_at IS NULL
+	  WHERE users.id::text=acl.user_id::text AND acl.object_id=$1
+	  GROUP BY users.id, acl.access, roles.id, roles.name
+	  UNION ALL
+	  SELECT users.id, users.user_name, users.email_address, g.access,
+	  COALESCE(roles.id::text, ''), COALESCE(roles.name, ''), $3::text, t.id::text, t.name,
+	  GREATEST(g.created_at, m.created_at)
+	  FROM users, team_members m, teams t, team_grants g
+	  LEFT JOIN roles ON roles.id::text=g.role_id AND roles.deleted_at IS NULL
+	  WHERE users.id::text=m.user_id AND m.team_id=t.id AND t.deleted_at IS NULL
+	  AND g.team_id=t.id AND g.project_id=$1
+	) collaborators
+	ORDER BY created_at
   `,
-		projectId)
+		projectId, COLLABORATOR_SOURCE_DIRECT, COLLABORATOR_SOURCE_TEAM)
 
 	if err != nil {
 		log.Println(err)
@@ -158,6 +395,11 @@ func getCollaborators(projectId string) (*Collaborators, error) {
 			&p.Username,
 			&p.EmailAddress,
 			&p.Access,
+			&p.RoleID,
+			&p.RoleName,
+			&p.Source,
+			&p.TeamID,
+			&p.TeamName,
 			&p.CreatedAt,
 		); err != nil {
 			log.Println(err)
@@ -163,3 +163,984 @@ func TestProjectDelete(t *testing.T) {
 	response = executeRequest(req)
 	assert.Equal(t, http.StatusNotFound, response.Code)
 }
+
+func TestProjectInheritedAccess(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	var jsonStr = []byte(`{"name":"test project"}`)
+	req, _ := http.NewRequest("POST", "/api/project", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var project map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &project)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+
+	// The second user joins as collaborator
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	var collaborator map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &collaborator)
+
+	// The owner creates the scenario
+	jsonStr = []byte(`{"name":"test scope","projectId":"` + projectId + `"}`)
+	req, _ = http.NewRequest("POST", "/api/scope", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var scope map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &scope)
+
+	jsonStr = []byte(`{"name":"test scenario","projectId":"` + projectId + `","scopeId":"` + fmt.Sprintf("%s", scope["id"]) + `","steps":[]}`)
+	req, _ = http.NewRequest("POST", "/api/scenario", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var scenario map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &scenario)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+
+	// MODIFY on the project applies to the scenario
+	req, _ = http.NewRequest("GET", "/api/scenario/"+scenarioId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	jsonStr = []byte(`{"name":"updated","scopeId":"` + fmt.Sprintf("%s", scope["id"]) + `","steps":[]}`)
+	req, _ = http.NewRequest("PUT", "/api/scenario/"+scenarioId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/scenario/"+scenarioId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Override for this scenario only
+	override := fmt.Sprintf("/api/access-override/%s/%s", scenarioId, collaborator["id"])
+	req, _ = http.NewRequest("PUT", override, bytes.NewBuffer([]byte(`{"access":"READ"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", override, bytes.NewBuffer([]byte(`{"access":"READ"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/scenario/"+scenarioId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("DELETE", override, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/scenario/"+scenarioId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestProjectCustomRole(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	var jsonStr = []byte(`{"name":"test project"}`)
+	req, _ := http.NewRequest("POST", "/api/project", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	req.Header.Set("Content-Type", "application/json")
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var project map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &project)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	var collaborator map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &collaborator)
+
+	jsonStr = []byte(`{"name":"test scope","projectId":"` + projectId + `"}`)
+	req, _ = http.NewRequest("POST", "/api/scope", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	var scope map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &scope)
+
+	jsonStr = []byte(`{"name":"test scenario","projectId":"` + projectId + `","scopeId":"` + fmt.Sprintf("%s", scope["id"]) + `","steps":[]}`)
+	req, _ = http.NewRequest("POST", "/api/scenario", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	var scenario map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &scenario)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+
+	// Only the collaborators managers can define roles
+	jsonStr = []byte(`{"name":"Tester","projectId":"` + projectId + `","permissions":["view","execute-tests"]}`)
+	req, _ = http.NewRequest("POST", "/api/role", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	jsonStr = []byte(`{"name":"Tester","projectId":"` + projectId + `","permissions":["view","fly"]}`)
+	req, _ = http.NewRequest("POST", "/api/role", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	jsonStr = []byte(`{"name":"Tester","projectId":"` + projectId + `","permissions":["view","execute-tests"]}`)
+	req, _ = http.NewRequest("POST", "/api/role", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+	var role map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &role)
+	roleId := fmt.Sprintf("%s", role["id"])
+
+	assign := fmt.Sprintf("/api/assign-role/%s/%s", projectId, collaborator["id"])
+	req, _ = http.NewRequest("PUT", assign, bytes.NewBuffer([]byte(`{"roleId":"`+roleId+`"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/collaborators/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), `"roleName":"Tester"`)
+
+	// The tester can view the scenario but not edit it
+	req, _ = http.NewRequest("GET", "/api/scenario/"+scenarioId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	jsonStr = []byte(`{"name":"updated","scopeId":"` + fmt.Sprintf("%s", scope["id"]) + `","steps":[]}`)
+	req, _ = http.NewRequest("PUT", "/api/scenario/"+scenarioId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// The role is assigned, it cannot be deleted
+	req, _ = http.NewRequest("DELETE", "/api/role/"+roleId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// Granting the permission to the role applies to its collaborators
+	jsonStr = []byte(`{"name":"Tester","permissions":["view","execute-tests","edit-scenarios"]}`)
+	req, _ = http.NewRequest("PUT", "/api/role/"+roleId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	jsonStr = []byte(`{"name":"updated","scopeId":"` + fmt.Sprintf("%s", scope["id"]) + `","steps":[]}`)
+	req, _ = http.NewRequest("PUT", "/api/scenario/"+scenarioId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// The owner can neither be demoted nor revoked
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	var owner map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &owner)
+
+	assign = fmt.Sprintf("/api/assign-role/%s/%s", projectId, owner["id"])
+	req, _ = http.NewRequest("PUT", assign, bytes.NewBuffer([]byte(`{"roleId":"`+roleId+`"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/revoke/%s/%s", projectId, owner["id"]), nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// Managing the collaborators does not give more than the manager has
+	jsonStr = []byte(`{"name":"Tester","permissions":["view","manage-collaborators"]}`)
+	req, _ = http.NewRequest("PUT", "/api/role/"+roleId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	jsonStr = []byte(`{"name":"Admin","projectId":"` + projectId + `","permissions":["view","delete"]}`)
+	req, _ = http.NewRequest("POST", "/api/role", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "permission-not-held")
+
+	jsonStr = []byte(`{"name":"Tester","permissions":["view","manage-collaborators","manage-api-keys"]}`)
+	req, _ = http.NewRequest("PUT", "/api/role/"+roleId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/collaborator-access/%s/%s", projectId, collaborator["id"]),
+		bytes.NewBuffer([]byte(`{"access":"MODIFY"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	jsonStr = []byte(`{"name":"Viewer","projectId":"` + projectId + `","permissions":["view"]}`)
+	req, _ = http.NewRequest("POST", "/api/role", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusCreated, response.Code)
+}
+
+func TestProjectEmailInvitation(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	// Ownership can not be given by invitation
+	jsonStr := []byte(`{"emailAddress":"masepindrayana@gmail.com","access":"OWNER","projectId":"` + projectId + `"}`)
+	req, _ := http.NewRequest("POST", "/api/invitation", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.Contains(t, response.Body.String(), "invalid-access-level")
+
+	invitation := createTestObject(t, testUserToken1, "/api/invitation",
+		`{"emailAddress":"Masepindrayana@gmail.com","access":"READ","expiresInDays":3,"projectId":"`+projectId+`"}`)
+	invitationId := fmt.Sprintf("%s", invitation["id"])
+	assert.Equal(t, "masepindrayana@gmail.com", invitation["emailAddress"])
+
+	// The token is only sent by email
+	_, err := app.DB.Exec(`UPDATE project_invitations SET token_hash=$1 WHERE id=$2`, hashToken("test-token"), invitationId)
+	assert.Equal(t, nil, err)
+
+	req, _ = http.NewRequest("GET", "/api/invitations?projectId="+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), invitationId)
+
+	// Only the invited email address can accept
+	jsonStr = []byte(`{"token":"test-token"}`)
+	req, _ = http.NewRequest("PUT", "/api/accept-invitation", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "email-mismatch")
+
+	req, _ = http.NewRequest("PUT", "/api/accept-invitation", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Single use
+	req, _ = http.NewRequest("PUT", "/api/accept-invitation", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusGone, response.Code)
+	assert.Contains(t, response.Body.String(), "invitation-used")
+
+	req, _ = http.NewRequest("DELETE", "/api/invitation/"+invitationId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// Expired and revoked invitations
+	invitation = createTestObject(t, testUserToken1, "/api/invitation",
+		`{"emailAddress":"masepindrayana@gmail.com","access":"MODIFY","projectId":"`+projectId+`"}`)
+	invitationId = fmt.Sprintf("%s", invitation["id"])
+	_, err = app.DB.Exec(`
+	UPDATE project_invitations SET token_hash=$1, expires_at=NOW() - INTERVAL '1 minute' WHERE id=$2
+	`, hashToken("expired-token"), invitationId)
+	assert.Equal(t, nil, err)
+
+	req, _ = http.NewRequest("PUT", "/api/accept-invitation", bytes.NewBuffer([]byte(`{"token":"expired-token"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusGone, response.Code)
+	assert.Contains(t, response.Body.String(), "invitation-expired")
+
+	req, _ = http.NewRequest("DELETE", "/api/invitation/"+invitationId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/invitation/"+invitationId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/accept-invitation", bytes.NewBuffer([]byte(`{"token":"expired-token"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
+
+func TestProjectInviteLinkSettings(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ := http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	oldCode := fmt.Sprintf("%s", project["inviteCode"])
+	assert.Equal(t, true, project["inviteEnabled"])
+	assert.Equal(t, "MODIFY", project["inviteAccess"])
+
+	// Disabled links can not be used
+	jsonStr := []byte(`{"inviteEnabled":false,"inviteAccess":"READ"}`)
+	req, _ = http.NewRequest("PUT", "/api/invite-settings/"+projectId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/invite/"+oldCode, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusGone, response.Code)
+	assert.Contains(t, response.Body.String(), "invite-disabled")
+
+	jsonStr = []byte(`{"inviteEnabled":true,"inviteAccess":"OWNER"}`)
+	req, _ = http.NewRequest("PUT", "/api/invite-settings/"+projectId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	// A single use, with READ access
+	jsonStr = []byte(`{"inviteEnabled":true,"inviteAccess":"READ","inviteMaxUses":1}`)
+	req, _ = http.NewRequest("PUT", "/api/invite-settings/"+projectId, bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/invite/"+oldCode, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/invite/"+oldCode, nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusGone, response.Code)
+	assert.Contains(t, response.Body.String(), "invite-limit-reached")
+
+	// Rotation expires the previous code and counts the uses again
+	req, _ = http.NewRequest("PUT", "/api/rotate-invite/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/rotate-invite/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var rotated map[string]string
+	json.Unmarshal(response.Body.Bytes(), &rotated)
+	assert.NotEqual(t, oldCode, rotated["inviteCode"])
+
+	req, _ = http.NewRequest("GET", "/api/invite/"+oldCode, nil)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusGone, response.Code)
+	assert.Contains(t, response.Body.String(), "invite-expired")
+
+	req, _ = http.NewRequest("GET", "/api/invite/"+rotated["inviteCode"], nil)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestProjectOwnershipTransfer(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ := http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	var owner map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &owner)
+	ownerId := fmt.Sprintf("%s", owner["id"])
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	var collaborator map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &collaborator)
+	collaboratorId := fmt.Sprintf("%s", collaborator["id"])
+	assert.Equal(t, float64(0), collaborator["quotas"].(map[string]interface{})["project"])
+
+	// Access level change
+	access := "/api/collaborator-access/" + projectId + "/" + collaboratorId
+	req, _ = http.NewRequest("PUT", access, bytes.NewBuffer([]byte(`{"access":"READ"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", access, bytes.NewBuffer([]byte(`{"access":"OWNER"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/collaborator-access/"+projectId+"/"+ownerId, bytes.NewBuffer([]byte(`{"access":"READ"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// The collaborator can not take the project
+	jsonStr := []byte(`{"projectId":"` + projectId + `","toUserId":"` + collaboratorId + `"}`)
+	req, _ = http.NewRequest("POST", "/api/ownership-transfer", bytes.NewBuffer(jsonStr))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	transfer := createTestObject(t, testUserToken1, "/api/ownership-transfer", string(jsonStr))
+	transferId := fmt.Sprintf("%s", transfer["id"])
+
+	req, _ = http.NewRequest("GET", "/api/ownership-transfers", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), transferId)
+
+	// Only the offered user accepts
+	req, _ = http.NewRequest("PUT", "/api/ownership-transfer/"+transferId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/ownership-transfer/"+transferId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/ownership-transfer/"+transferId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// The new owner deletes, the previous one modifies
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &collaborator)
+	assert.Equal(t, float64(1), collaborator["quotas"].(map[string]interface{})["project"])
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &owner)
+	assert.Equal(t, float64(0), owner["quotas"].(map[string]interface{})["project"])
+
+	req, _ = http.NewRequest("DELETE", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestProjectRecycleBin(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	scope := createTestObject(t, testUserToken1, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	scenario := `{"name":"test scenario","projectId":"` + projectId + `","scopeId":"` + scopeId + `","steps":[]}`
+	first := createTestObject(t, testUserToken1, "/api/scenario", scenario)
+	firstId := fmt.Sprintf("%s", first["id"])
+	second := createTestObject(t, testUserToken1, "/api/scenario", scenario)
+	secondId := fmt.Sprintf("%s", second["id"])
+
+	// The first scenario is deleted on its own, the second along with the scope
+	req, _ := http.NewRequest("DELETE", "/api/scenario/"+firstId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/scope/"+scopeId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/recycle-bin/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var items map[string][]map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &items)
+	assert.Equal(t, 3, len(items["data"]))
+	for _, item := range items["data"] {
+		assert.Equal(t, "padfoot.tgz@gmail.com", item["deletedByEmail"])
+	}
+
+	req, _ = http.NewRequest("GET", "/api/recycle-bin/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+secondId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+	assert.Contains(t, response.Body.String(), "parent-deleted")
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+scopeId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+scopeId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/scenario/"+secondId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/scenario/"+firstId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/recycle-bin/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &items)
+	assert.Equal(t, 1, len(items["data"]))
+	assert.Equal(t, firstId, items["data"][0]["id"])
+
+	// Archived projects are read-only
+	req, _ = http.NewRequest("PUT", "/api/project-archive/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	assert.NotEmpty(t, project["archivedAt"])
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "project-archived")
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+firstId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/project-archive/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestProjectDeleteCascade(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	scope := createTestObject(t, testUserToken1, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	scenario := `{"name":"test scenario","projectId":"` + projectId + `","scopeId":"` + scopeId + `","steps":[]}`
+	deleted := createTestObject(t, testUserToken1, "/api/scenario", scenario)
+	deletedId := fmt.Sprintf("%s", deleted["id"])
+	kept := createTestObject(t, testUserToken1, "/api/scenario", scenario)
+	keptId := fmt.Sprintf("%s", kept["id"])
+
+	scenarios := `[{"id":"` + deletedId + `"},{"id":"` + keptId + `"}]`
+	open := createTestObject(t, testUserToken1, "/api/session", `{"version":"1.0","projectId":"`+projectId+`","scenarios":`+scenarios+`}`)
+	openId := fmt.Sprintf("%s", open["id"])
+	closed := createTestObject(t, testUserToken1, "/api/session", `{"version":"0.9","projectId":"`+projectId+`","scenarios":`+scenarios+`}`)
+	closedId := fmt.Sprintf("%s", closed["id"])
+	createTestObject(t, testUserToken1, "/api/test", `{"sessionId":"`+openId+`","scenarioId":"`+deletedId+`"}`)
+
+	req, _ := http.NewRequest("PUT", "/api/session/"+closedId, bytes.NewBuffer([]byte(`{"version":"0.9","status":1,"scenarios":`+scenarios+`}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	liveTests := func() int {
+		var count int
+		app.DB.QueryRow(`SELECT COUNT(*) FROM tests WHERE session_id=$1 AND deleted_at IS NULL`, openId).Scan(&count)
+		return count
+	}
+	assert.Equal(t, 1, liveTests())
+
+	// The open session drops the deleted scenario, the closed one keeps it
+	req, _ = http.NewRequest("DELETE", "/api/scenario/"+deletedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotContains(t, response.Body.String(), deletedId)
+	assert.Equal(t, 0, liveTests())
+
+	req, _ = http.NewRequest("GET", "/api/session/"+closedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var session map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &session)
+	assert.Equal(t, 2, len(session["scenarios"].([]interface{})))
+	assert.Contains(t, response.Body.String(), `"deleted":true`)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+deletedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Contains(t, response.Body.String(), deletedId)
+	assert.Equal(t, 1, liveTests())
+
+	// The project takes its sessions and tests along
+	req, _ = http.NewRequest("DELETE", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+	assert.Equal(t, 0, liveTests())
+
+	req, _ = http.NewRequest("GET", "/api/recycle-bin/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var items map[string][]map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &items)
+	assert.Equal(t, 6, len(items["data"]))
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), deletedId)
+	assert.Equal(t, 1, liveTests())
+
+	// A scenario deleted while the session is deleted is detached on restore
+	req, _ = http.NewRequest("DELETE", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/scenario/"+deletedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.NotContains(t, response.Body.String(), deletedId)
+	assert.Equal(t, 0, liveTests())
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+deletedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Contains(t, response.Body.String(), deletedId)
+	assert.Equal(t, 1, liveTests())
+
+	// And one restored while the session is deleted is attached back
+	req, _ = http.NewRequest("DELETE", "/api/scenario/"+deletedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+deletedId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/restore/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/session/"+openId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Contains(t, response.Body.String(), deletedId)
+	assert.Equal(t, 1, liveTests())
+}
+
+func TestProjectCreatorAccess(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ := http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	var collaborator map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &collaborator)
+	collaboratorId := fmt.Sprintf("%s", collaborator["id"])
+
+	// The collaborator does not own what they create
+	scope := createTestObject(t, testUserToken2, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scopeId := fmt.Sprintf("%s", scope["id"])
+	req, _ = http.NewRequest("DELETE", "/api/scope/"+scopeId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Demoted, the access to the project applies to the scope too
+	req, _ = http.NewRequest("PUT", "/api/collaborator-access/"+projectId+"/"+collaboratorId,
+		bytes.NewBuffer([]byte(`{"access":"READ"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/scope/"+scopeId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Overrides are given on the scope, but not its ownership
+	override := fmt.Sprintf("/api/access-override/%s/%s", scopeId, collaboratorId)
+	req, _ = http.NewRequest("PUT", override, bytes.NewBuffer([]byte(`{"access":"OWNER"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("PUT", override, bytes.NewBuffer([]byte(`{"access":"MODIFY"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	// Revoked, the override goes along
+	req, _ = http.NewRequest("PUT", "/api/revoke/"+projectId+"/"+collaboratorId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/scope/"+scopeId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+}
+
+func TestProjectForeignScenario(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	scope := createTestObject(t, testUserToken1, "/api/scope", `{"name":"test scope","projectId":"`+projectId+`"}`)
+	scenario := createTestObject(t, testUserToken1, "/api/scenario",
+		`{"name":"test scenario","projectId":"`+projectId+`","scopeId":"`+fmt.Sprintf("%s", scope["id"])+`","steps":[]}`)
+	scenarioId := fmt.Sprintf("%s", scenario["id"])
+
+	other := createTestObject(t, testUserToken2, "/api/project", `{"name":"other project"}`)
+	otherId := fmt.Sprintf("%s", other["id"])
+	session := createTestObject(t, testUserToken2, "/api/session", `{"version":"1.0","projectId":"`+otherId+`","scenarios":[]}`)
+	sessionId := fmt.Sprintf("%s", session["id"])
+
+	// The scenarios of another project cannot be tested
+	req, _ := http.NewRequest("POST", "/api/test", bytes.NewBuffer([]byte(`{"sessionId":"`+sessionId+`","scenarioId":"`+scenarioId+`"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.NotContains(t, response.Body.String(), "test scenario")
+
+	req, _ = http.NewRequest("POST", "/api/session",
+		bytes.NewBuffer([]byte(`{"version":"1.0","projectId":"`+otherId+`","scenarios":[{"id":"`+scenarioId+`"}]}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/session/"+sessionId,
+		bytes.NewBuffer([]byte(`{"version":"1.0","status":0,"scenarios":[{"id":"`+scenarioId+`"}]}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	// The sessions of others are forbidden before being looked up
+	req, _ = http.NewRequest("PUT", "/api/session/"+sessionId,
+		bytes.NewBuffer([]byte(`{"version":"2.0","status":0,"scenarios":[]}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+}
+
+func TestProjectOwnershipTransferRevoked(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	req, _ := http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	var collaborator map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &collaborator)
+	collaboratorId := fmt.Sprintf("%s", collaborator["id"])
+
+	transfer := createTestObject(t, testUserToken1, "/api/ownership-transfer",
+		`{"projectId":"`+projectId+`","toUserId":"`+collaboratorId+`"}`)
+	transferId := fmt.Sprintf("%s", transfer["id"])
+
+	// Revoking the collaborator cancels the offer
+	req, _ = http.NewRequest("PUT", "/api/revoke/"+projectId+"/"+collaboratorId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/ownership-transfer/"+transferId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	// An offer left pending is void too
+	_, err := app.DB.Exec(`UPDATE ownership_transfers SET cancelled_at=NULL WHERE id=$1`, transferId)
+	assert.Equal(t, nil, err)
+	req, _ = http.NewRequest("PUT", "/api/ownership-transfer/"+transferId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusConflict, response.Code)
+
+	owner, err := getProjectOwner(projectId)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, collaboratorId, owner)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RateLimitStore keeps the token buckets. Take refills the bucket of the key
+// for the time elapsed since its last use, then consumes one token if any.
+type RateLimitStore interface {
+	Take(key string, limit RateLimit, now time.Time) (*RateLimitResult, error)
+	// Purge removes the buckets unused since before, they are full anyway
+	Purge(before time.Time) (int64, error)
+}
+
+// RateLimit allows Capacity requests in a burst, refilled over Period.
+type RateLimit struct {
+	Capacity int
+	Period   time.Duration
+}
+
+func (l RateLimit) ratePerSecond() float64 {
+	return float64(l.Capacity) / l.Period.Seconds()
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining float64 // Tokens left in the bucket
+}
+
+const (
+	RATE_LIMIT_STORE_MEMORY   = "memory"
+	RATE_LIMIT_STORE_POSTGRES = "postgres"
+	RATE_LIMIT_STORE_OFF      = "off"
+)
+
+const (
+	RATE_LIMIT_GROUP_DEFAULT = "default"
+	RATE_LIMIT_GROUP_HEAVY   = "heavy"  // Listings loading whole projects
+	RATE_LIMIT_GROUP_AUTH    = "auth"   // Sign in and invitations, mostly anonymous
+	RATE_LIMIT_GROUP_UPLOAD  = "upload" // Blob uploads
+	RATE_LIMIT_GROUP_SCIM    = "scim"   // Provisioning, in bursts
+)
+
+// Requests without a user are limited by IP address, with this tier
+const RATE_LIMIT_TIER_ANONYMOUS = "anonymous"
+
+// Buckets unused for this long are purged
+const RATE_LIMIT_IDLE_TTL = 24 * time.Hour
+
+// Route groups by route template, the others are in the default group
+var RATE_LIMIT_GROUPS = map[string]string{
+	"GET /api/projects":           RATE_LIMIT_GROUP_HEAVY,
+	"GET /api/scopes":             RATE_LIMIT_GROUP_HEAVY,
+	"GET /api/scenarios":          RATE_LIMIT_GROUP_HEAVY,
+	"GET /api/sessions":           RATE_LIMIT_GROUP_HEAVY,
+	"GET /api/admin/blob-gc":      RATE_LIMIT_GROUP_HEAVY,
+	"POST /api/auth/register":     RATE_LIMIT_GROUP_AUTH,
+	"POST /api/auth/verify-email": RATE_LIMIT_GROUP_AUTH,
+	"POST /api/auth/login":        RATE_LIMIT_GROUP_AUTH,
+	"GET /api/invite/{id}":        RATE_LIMIT_GROUP_AUTH,
+	"PUT /api/invite/{id}":        RATE_LIMIT_GROUP_AUTH,
+	"POST /api/blob":              RATE_LIMIT_GROUP_UPLOAD,
+}
+
+// Limits by route group and subscription type. A group without a limit for
+// the tier falls back to the default group, a tier without any limit, e.g.
+// admins, is not limited.
+var RATE_LIMITS = map[string]map[string]RateLimit{
+	RATE_LIMIT_GROUP_DEFAULT: {
+		RATE_LIMIT_TIER_ANONYMOUS: {60, time.Minute},
+		"free":                    {300, time.Minute},
+		"standard":                {1200, time.Minute},
+	},
+	RATE_LIMIT_GROUP_HEAVY: {
+		RATE_LIMIT_TIER_ANONYMOUS: {10, time.Minute},
+		"free":                    {30, time.Minute},
+		"standard":                {120, time.Minute},
+	},
+	RATE_LIMIT_GROUP_AUTH: {
+		RATE_LIMIT_TIER_ANONYMOUS: {10, time.Minute},
+		"free":                    {30, time.Minute},
+		"standard":                {30, time.Minute},
+	},
+	RATE_LIMIT_GROUP_UPLOAD: {
+		"free":     {20, time.Minute},
+		"standard": {120, time.Minute},
+	},
+	RATE_LIMIT_GROUP_SCIM: {
+		RATE_LIMIT_TIER_ANONYMOUS: {600, time.Minute},
+	},
+}
+
+type RateLimiter struct {
+	Store  RateLimitStore
+	Limits map[string]map[string]RateLimit
+}
+
+// newRateLimiter creates the limiter selected by RATE_LIMIT_STORE, in memory
+// by default. Several instances of the API share their buckets through
+// Postgres. RATE_LIMITS overrides the limits, e.g. "heavy:free=60/1m".
+func newRateLimiter() (*RateLimiter, error) {
+	limits, err := parseRateLimits(RATE_LIMITS, os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", RATE_LIMIT_STORE_MEMORY:
+		return &RateLimiter{Store: NewMemoryRateLimitStore(), Limits: limits}, nil
+	case RATE_LIMIT_STORE_POSTGRES:
+		return &RateLimiter{Store: NewPostgresRateLimitStore(), Limits: limits}, nil
+	case RATE_LIMIT_STORE_OFF:
+		return nil, nil
+	default:
+		return nil, errors.New("unknown-rate-limit-store")
+	}
+}
+
+// parseRateLimits applies the comma separated overrides, formatted as
+// group:tier=capacity/period, on a copy of the limits.
+func parseRateLimits(defaults map[string]map[string]RateLimit, overrides string) (map[string]map[string]RateLimit, error) {
+	limits := map[string]map[string]RateLimit{}
+	for group, tiers := range defaults {
+		limits[group] = map[string]RateLimit{}
+		for tier, limit := range tiers {
+			limits[group][tier] = limit
+		}
+	}
+
+	for _, override := range strings.Split(overrides, ",") {
+		override = strings.TrimSpace(override)
+		if len(override) < 1 {
+			continue
+		}
+		parts := strings.FieldsFunc(override, func(c rune) bool {
+			return c == ':' || c == '=' || c == '/'
+		})
+		if len(parts) != 4 {
+			return nil, errors.New("invalid-rate-limit")
+		}
+		capacity, err := strconv.Atoi(parts[2])
+		if err != nil || capacity < 1 {
+			return nil, errors.New("invalid-rate-limit")
+		}
+		period, err := time.ParseDuration(parts[3])
+		if err != nil || period <= 0 {
+			return nil, errors.New("invalid-rate-limit")
+		}
+		if limits[parts[0]] == nil {
+			limits[parts[0]] = map[string]RateLimit{}
+		}
+		limits[parts[0]][parts[1]] = RateLimit{capacity, period}
+	}
+	return limits, nil
+}
+
+func (l *RateLimiter) getLimit(group, tier string) (RateLimit, bool) {
+	if limit, ok := l.Limits[group][tier]; ok {
+		return limit, true
+	}
+	limit, ok := l.Limits[RATE_LIMIT_GROUP_DEFAULT][tier]
+	return limit, ok
+}
+
+// rateLimitGroup returns the group of the matched route.
+func rateLimitGroup(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return RATE_LIMIT_GROUP_DEFAULT
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return RATE_LIMIT_GROUP_DEFAULT
+	}
+	if group, ok := RATE_LIMIT_GROUPS[r.Method+" "+template]; ok {
+		return group
+	}
+	if strings.HasPrefix(template, SCIM_PATH_PREFIX) {
+		return RATE_LIMIT_GROUP_SCIM
+	}
+	return RATE_LIMIT_GROUP_DEFAULT
+}
+
+// rateLimitSubject returns the key of the caller, API keys having their own
+// bucket, along with its tier.
+func rateLimitSubject(r *http.Request) (string, string) {
+	currentUser, _ := r.Context().Value("currentUser").(*User)
+	if currentUser == nil {
+		return rateLimitAddress(r), RATE_LIMIT_TIER_ANONYMOUS
+	}
+	tier := currentUser.SubscriptionType
+	if currentUser.Role == "ADMIN" {
+		tier = "admin"
+	}
+	if apiKey := getRequestApiKey(r); apiKey != nil {
+		return "key:" + apiKey.ID, tier
+	}
+	return "user:" + currentUser.ID, tier
+}
+
+// rateLimitAddress returns the key of an anonymous caller.
+func rateLimitAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimitMiddleware runs after Middleware, the caller is known.
+func (l *RateLimiter) RateLimitMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, tier := rateLimitSubject(r)
+		if l.take(w, r, subject, tier) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// takeAnonymous charges the bucket of the IP address for a request failing
+// authentication, rejected before RateLimitMiddleware. Invalid credentials
+// are limited as anonymous requests.
+func (l *RateLimiter) takeAnonymous(w http.ResponseWriter, r *http.Request) bool {
+	return l.take(w, r, rateLimitAddress(r), RATE_LIMIT_TIER_ANONYMOUS)
+}
+
+// take consumes a token from the bucket of the subject for the route group
+// and sets the rate limit headers. Rate limited requests are answered.
+func (l *RateLimiter) take(w http.ResponseWriter, r *http.Request, subject, tier string) bool {
+	group := rateLimitGroup(r)
+	limit, ok := l.getLimit(group, tier)
+	if !ok {
+		return true
+	}
+
+	result, err := l.Store.Take(subject+":"+group, limit, time.Now())
+	if err != nil {
+		// Do not block the API on the store
+		log.Println(err)
+		return true
+	}
+
+	rate := limit.ratePerSecond()
+	reset := math.Ceil((float64(limit.Capacity) - result.Remaining) / rate)
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Capacity))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(int(math.Floor(result.Remaining))))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(reset)))
+	w.Header().Set("RateLimit-Policy", strconv.Itoa(limit.Capacity)+";w="+strconv.Itoa(int(limit.Period.Seconds())))
+
+	if !result.Allowed {
+		log.Println("RATE LIMITED", r.Method, r.URL.Path, subject, group)
+		retryAfter := math.Ceil((1 - result.Remaining) / rate)
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+		respondError(w, http.StatusTooManyRequests, "rate-limited")
+		return false
+	}
+	return true
+}
+
+// runPurgeLoop removes the idle buckets every RATE_LIMIT_PURGE_INTERVAL_MINUTES.
+func (l *RateLimiter) runPurgeLoop() {
+	minutes, err := strconv.Atoi(os.Getenv("RATE_LIMIT_PURGE_INTERVAL_MINUTES"))
+	if err != nil {
+		minutes = 60
+	}
+	if minutes < 1 {
+		log.Println("Rate limit purge is disabled")
+		return
+	}
+	for {
+		time.Sleep(time.Duration(minutes) * time.Minute)
+		purged, err := l.Store.Purge(time.Now().Add(-RATE_LIMIT_IDLE_TTL))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("Idle rate limit buckets purged:", purged)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryRateLimitStore keeps the buckets of a single instance.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*tokenBucket{}}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit, now time.Time) (*RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capacity := float64(limit.Capacity)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updatedAt: now}
+		s.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.updatedAt).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(capacity, bucket.tokens+elapsed*limit.ratePerSecond())
+		bucket.updatedAt = now
+	}
+
+	if bucket.tokens < 1 {
+		return &RateLimitResult{Allowed: false, Remaining: bucket.tokens}, nil
+	}
+	bucket.tokens--
+	return &RateLimitResult{Allowed: true, Remaining: bucket.tokens}, nil
+}
+
+func (s *MemoryRateLimitStore) Purge(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for key, bucket := range s.buckets {
+		if bucket.updatedAt.Before(before) {
+			delete(s.buckets, key)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+)
+
+// PostgresRateLimitStore shares the buckets between the instances of the
+// API. The refill and the consumption happen in a single upsert, the row
+// lock serializes concurrent requests of the same key.
+type PostgresRateLimitStore struct{}
+
+func NewPostgresRateLimitStore() *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{}
+}
+
+func (s *PostgresRateLimitStore) Take(key string, limit RateLimit, now time.Time) (*RateLimitResult, error) {
+	result := RateLimitResult{}
+	err := app.DB.QueryRow(`
+	INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+	VALUES ($1, $2::float8 - 1, true, $4::timestamp)
+	ON CONFLICT (key) DO UPDATE SET
+	allowed = LEAST($2::float8, b.tokens + GREATEST(EXTRACT(EPOCH FROM $4::timestamp - b.updated_at), 0) * $3::float8) >= 1,
+	tokens = LEAST($2::float8, b.tokens + GREATEST(EXTRACT(EPOCH FROM $4::timestamp - b.updated_at), 0) * $3::float8)
+		- CASE WHEN LEAST($2::float8, b.tokens + GREATEST(EXTRACT(EPOCH FROM $4::timestamp - b.updated_at), 0) * $3::float8) >= 1 THEN 1 ELSE 0 END,
+	updated_at = GREATEST(b.updated_at, $4::timestamp)
+	RETURNING allowed, tokens
+	`,
+		key, limit.Capacity, limit.ratePerSecond(), now.UTC(),
+	).Scan(&result.Allowed, &result.Remaining)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *PostgresRateLimitStore) Purge(before time.Time) (int64, error) {
+	res, err := app.DB.Exec(`
+	DELETE FROM rate_limit_buckets WHERE updated_at < $1
+	`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+// getRecycleBin lists the deleted scopes, scenarios and sessions of the
+// project, with who deleted them.
+func (app *App) getRecycleBin(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
+	items, err := getRecycleBin(id)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, items)
+}
+
+// restoreObject restores a deleted project, scope, scenario or session with
+// the children deleted along with it.
+func (app *App) restoreObject(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
+	if err := restoreObject(id); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+			return
+		}
+		switch err.Error() {
+		case "parent-deleted":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
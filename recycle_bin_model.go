@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+)
+
+// RecycleBinItem is a deleted project, scope, scenario or session, kept
+// until restored.
+type RecycleBinItem struct {
+	ID             string `json:"id"`
+	Type           string `json:"type"`
+	Name           string `json:"name"`
+	DeletedAt      string `json:"deletedAt"`
+	DeletedBy      string `json:"deletedBy"`
+	DeletedByEmail string `json:"deletedByEmail"`
+}
+
+type RecycleBin struct {
+	Data []RecycleBinItem `json:"data"`
+}
+
+// getRecycleBin lists the deleted items of the project, the project itself
+// when deleted, most recent first.
+func getRecycleBin(projectID string) (RecycleBin, error) {
+	rows, err := app.DB.Query(`
+	SELECT bin.id, bin.type, bin.name, bin.deleted_at::text,
+	COALESCE(bin.deleted_by, ''), COALESCE(users.email_address, '')
+	FROM (
+	  SELECT id::text, 'project' AS type, name, deleted_at, deleted_by
+	  FROM projects WHERE id::text=$1 AND deleted_at IS NOT NULL
+	  UNION ALL
+	  SELECT id::text, 'scope', name, deleted_at, deleted_by
+	  FROM scopes WHERE project_id::text=$1 AND deleted_at IS NOT NULL
+	  UNION ALL
+	  SELECT id::text, 'scenario', name, deleted_at, deleted_by
+	  FROM scenarios WHERE project_id::text=$1 AND deleted_at IS NOT NULL
+	  UNION ALL
+	  SELECT id::text, 'session', version, deleted_at, deleted_by
+	  FROM sessions WHERE project_id::text=$1 AND deleted_at IS NOT NULL
+	) bin
+	LEFT JOIN users ON users.id::text=bin.deleted_by
+	ORDER BY bin.deleted_at DESC, bin.type
+	`, projectID)
+	res := RecycleBin{Data: []RecycleBinItem{}}
+	if err != nil {
+		log.Println(err)
+		return res, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item RecycleBinItem
+		if err := rows.Scan(
+			&item.ID,
+			&item.Type,
+			&item.Name,
+			&item.DeletedAt,
+			&item.DeletedBy,
+			&item.DeletedByEmail,
+		); err != nil {
+			log.Println(err)
+			return res, err
+		}
+		res.Data = append(res.Data, item)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (app *App) getRoles(w http.ResponseWriter, r *http.Request) {
+	projectId := r.FormValue("projectId")
+	_, err := uuidParser.Parse(projectId)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, projectId, PERMISSION_VIEW) {
+		return
+	}
+
+	roles, err := getRoles(projectId)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, roles)
+}
+
+func (app *App) createRole(w http.ResponseWriter, r *http.Request) {
+	var p Role
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if _, err := uuidParser.Parse(p.ProjectID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, p.ProjectID, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+	if !authorizeGrant(w, r, p.ProjectID, p.Permissions) {
+		return
+	}
+
+	if err := p.createRole(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-name", "invalid-permission":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+func (app *App) updateRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	current, ok := getManagedRole(w, r, id)
+	if !ok {
+		return
+	}
+
+	var p Role
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.ID = id
+	p.ProjectID = current.ProjectID
+	p.CreatedAt = current.CreatedAt
+
+	// Its collaborators are given the permissions of the role right away
+	if !authorizeGrant(w, r, p.ProjectID, p.Permissions) {
+		return
+	}
+
+	if err := p.updateRole(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-name", "invalid-permission":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, p)
+}
+
+func (app *App) deleteRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	_, err := uuidParser.Parse(id)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	p, ok := getManagedRole(w, r, id)
+	if !ok {
+		return
+	}
+
+	if err := p.deleteRole(); err != nil {
+		log.Println(err)
+		if err.Error() == "role-in-use" {
+			respondError(w, http.StatusConflict, err.Error())
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// assignRole gives a collaborator of the project the permissions of a custom
+// role instead of a built-in access level.
+func (app *App) assignRole(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	projectId := vars["projectId"]
+	userId := vars["userId"]
+	if _, err := uuidParser.Parse(projectId); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+	if _, err := uuidParser.Parse(userId); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if !authorize(w, r, projectId, PERMISSION_MANAGE_COLLABORATORS) {
+		return
+	}
+
+	var p struct {
+		RoleID string `json:"roleId"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	// Roles are assigned to existing collaborators only
+	access := Acl{ObjectID: projectId, UserID: userId}
+	if err := access.getAccess(); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "collaborator-not-found")
+		} else {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if access.Access == "OWNER" {
+		respondError(w, http.StatusConflict, "owner-access")
+		return
+	}
+	access.ObjectType = "project"
+	access.Access = ACL_CUSTOM
+	access.RoleID = p.RoleID
+
+	permissions, err := access.getGrantedPermissions()
+	if err == nil && !authorizeGrant(w, r, projectId, permissions) {
+		return
+	}
+	if err == nil {
+		err = access.setAccess()
+	}
+	if err != nil {
+		log.Println(err)
+		if err.Error() == "invalid-role" {
+			respondError(w, http.StatusBadRequest, err.Error())
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// The access to the project applies to everything in it
+	if err := dropChildAccess(projectId, userId); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// getManagedRole loads a role the current user is allowed to manage.
+func getManagedRole(w http.ResponseWriter, r *http.Request, id string) (Role, bool) {
+	p := Role{ID: id}
+	if err := p.getRole(); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			respondError(w, http.StatusNotFound, "item-not-found")
+		default:
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return p, false
+	}
+	if !authorize(w, r, p.ProjectID, PERMISSION_MANAGE_COLLABORATORS) {
+		return p, false
+	}
+	return p, true
+}
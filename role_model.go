@@ -0,0 +1,217 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+const (
+	PERMISSION_VIEW                 = "view"
+	PERMISSION_EDIT                 = "edit"
+	PERMISSION_DELETE               = "delete"
+	PERMISSION_EDIT_SCOPES          = "edit-scopes"
+	PERMISSION_EDIT_SCENARIOS       = "edit-scenarios"
+	PERMISSION_MANAGE_SESSIONS      = "manage-sessions"
+	PERMISSION_CLOSE_SESSIONS       = "close-sessions"
+	PERMISSION_EXECUTE_TESTS        = "execute-tests"
+	PERMISSION_UPLOAD_FILES         = "upload-files"
+	PERMISSION_MANAGE_COLLABORATORS = "manage-collaborators"
+	PERMISSION_VIEW_BILLING         = "view-billing"
+	PERMISSION_MANAGE_API_KEYS      = "manage-api-keys"
+)
+
+var PERMISSIONS = [...]string{
+	PERMISSION_VIEW,
+	PERMISSION_EDIT,
+	PERMISSION_DELETE,
+	PERMISSION_EDIT_SCOPES,
+	PERMISSION_EDIT_SCENARIOS,
+	PERMISSION_MANAGE_SESSIONS,
+	PERMISSION_CLOSE_SESSIONS,
+	PERMISSION_EXECUTE_TESTS,
+	PERMISSION_UPLOAD_FILES,
+	PERMISSION_MANAGE_COLLABORATORS,
+	PERMISSION_VIEW_BILLING,
+	PERMISSION_MANAGE_API_KEYS,
+}
+
+// Access level of the collaborators with a custom role
+const ACL_CUSTOM = "CUSTOM"
+
+// Permissions of the built-in access levels
+var ACL_PERMISSIONS = map[string][]string{
+	"OWNER": PERMISSIONS[:],
+	"MODIFY": {
+		PERMISSION_VIEW,
+		PERMISSION_EDIT,
+		PERMISSION_EDIT_SCOPES,
+		PERMISSION_EDIT_SCENARIOS,
+		PERMISSION_MANAGE_SESSIONS,
+		PERMISSION_CLOSE_SESSIONS,
+		PERMISSION_EXECUTE_TESTS,
+		PERMISSION_UPLOAD_FILES,
+	},
+	"READ": {
+		PERMISSION_VIEW,
+	},
+}
+
+type Role struct {
+	ID          string   `json:"id"`
+	ProjectID   string   `json:"projectId"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+func isValidPermission(permission string) bool {
+	for _, p := range PERMISSIONS {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Role) validate() error {
+	if len(p.Name) < 1 {
+		return errors.New("invalid-name")
+	}
+	for _, permission := range p.Permissions {
+		if !isValidPermission(permission) {
+			return errors.New("invalid-permission")
+		}
+	}
+	return nil
+}
+
+func (p *Role) getRole() error {
+	return app.DB.QueryRow(`
+	SELECT project_id, name, permissions, created_at FROM roles WHERE id::text=$1
+	AND deleted_at IS NULL
+	`,
+		p.ID).Scan(&p.ProjectID, &p.Name, pq.Array(&p.Permissions), &p.CreatedAt)
+}
+
+func (p *Role) createRole() error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	err := app.DB.QueryRow(`
+	INSERT INTO roles(project_id, name, permissions) VALUES($1, $2, $3) RETURNING id, created_at
+	`,
+		p.ProjectID, p.Name, pq.Array(p.Permissions)).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func (p *Role) updateRole() error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	_, err := app.DB.Exec(`
+	UPDATE roles SET name=$1, permissions=$2, updated_at=NOW()
+	WHERE id=$3
+	`,
+		p.Name, pq.Array(p.Permissions), p.ID)
+	return err
+}
+
+// deleteRole refuses to delete a role that is still assigned.
+func (p *Role) deleteRole() error {
+	var count int
+	err := app.DB.QueryRow(`
+	SELECT COUNT(*) FROM access_control_lists WHERE role_id=$1 AND deleted_at IS NULL
+	`, p.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("role-in-use")
+	}
+	_, err = app.DB.Exec(`
+	UPDATE roles SET deleted_at=NOW() WHERE id=$1
+	`, p.ID)
+	return err
+}
+
+func getRoles(projectId string) ([]Role, error) {
+	rows, err := app.DB.Query(`
+	SELECT id, project_id, name, permissions, created_at FROM roles
+	WHERE deleted_at IS NULL AND project_id=$1
+	ORDER BY name ASC
+	`,
+		projectId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []Role{}
+
+	for rows.Next() {
+		var p Role
+		if err := rows.Scan(&p.ID, &p.ProjectID, &p.Name, pq.Array(&p.Permissions), &p.CreatedAt); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		roles = append(roles, p)
+	}
+
+	return roles, nil
+}
+
+// getPermissions lists the permissions granted by the access.
+func (a *Acl) getPermissions() ([]string, error) {
+	if a.Access != ACL_CUSTOM {
+		return ACL_PERMISSIONS[a.Access], nil
+	}
+	role := Role{ID: a.RoleID}
+	if err := role.getRole(); err != nil {
+		if err == sql.ErrNoRows {
+			// The role was deleted, nothing is granted
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	return role.Permissions, nil
+}
+
+// getGrantedPermissions validates the access about to be given and lists
+// the permissions it grants.
+func (a *Acl) getGrantedPermissions() ([]string, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	return a.getPermissions()
+}
+
+// hasPermission resolves the access of the user to the object and checks
+// whether it grants the permission.
+func (a *Acl) hasPermission(permission string) (bool, error) {
+	err := a.resolveAccess()
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	permissions, err := a.getPermissions()
+	if err != nil {
+		return false, err
+	}
+	for _, p := range permissions {
+		if p == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
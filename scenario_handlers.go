@@ -21,6 +21,10 @@ func (app *App) getScenario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
 	p := Scenario{ID: id}
 	if err = p.getScenario(); err != nil {
 		switch err {
@@ -47,6 +51,10 @@ func (app *App) getScenarios(w http.ResponseWriter, r *http.Request) {
 		start = 0
 	}
 
+	if !authorizeReference(w, r, projectId, PERMISSION_VIEW) {
+		return
+	}
+
 	scenarios, err := getScenarios(start, count, projectId)
 	if err != nil {
 		log.Println(err)
@@ -67,6 +75,25 @@ func (app *App) createScenario(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if !authorizeReference(w, r, p.ProjectID, PERMISSION_EDIT_SCENARIOS) {
+		return
+	}
+	// The scope decides where the access to the scenario is inherited from
+	if _, err := uuidParser.Parse(p.ScopeID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-scope")
+		return
+	}
+	scopeProjectID, err := getProjectOf(p.ScopeID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if scopeProjectID != p.ProjectID {
+		respondError(w, http.StatusBadRequest, "invalid-scope")
+		return
+	}
+
 	gb := NewGrowthBook(app.GBFeatures, "")
 	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
 	if isContentCreationLimiterEnabled {
@@ -88,19 +115,7 @@ func (app *App) createScenario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentUser := r.Context().Value("currentUser").(*User)
-	access := Acl{
-		ObjectID:   p.ID,
-		ObjectType: "scenario",
-		UserID:     currentUser.ID,
-		Access:     "OWNER",
-	}
-	err = access.createAccess()
-	if err != nil {
-		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	recordActivity(r, p.ProjectID, ACTIVITY_SCENARIO, p.ID, ACTIVITY_CREATED, p.Name)
 
 	respond(w, http.StatusCreated, p)
 }
@@ -115,6 +130,10 @@ func (app *App) updateScenario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_EDIT_SCENARIOS) {
+		return
+	}
+
 	var p Scenario
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&p); err != nil {
@@ -125,15 +144,50 @@ func (app *App) updateScenario(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	p.ID = id
 
+	current := Scenario{ID: id}
+	if err := current.getScenario(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
 	if err := p.updateScenario(); err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	changed := []string{}
+	if p.Name != current.Name {
+		changed = append(changed, "name")
+	}
+	if !isSameSteps(p.Steps, current.Steps) {
+		changed = append(changed, "steps")
+	}
+	if p.ScopeID != current.ScopeID {
+		changed = append(changed, "scope")
+	}
+	recordActivity(r, current.ProjectID, ACTIVITY_SCENARIO, id, ACTIVITY_UPDATED, describeChanges(p.Name, changed...))
+
 	respond(w, http.StatusOK, p)
 }
 
+func isSameSteps(steps, others []Step) bool {
+	if len(steps) != len(others) {
+		return false
+	}
+	for i, step := range steps {
+		if step != others[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (app *App) deleteScenario(w http.ResponseWriter, r *http.Request) {
 	var err error
 	vars := mux.Vars(r)
@@ -144,12 +198,27 @@ func (app *App) deleteScenario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
 	p := Scenario{ID: id}
-	if err := p.deleteScenario(); err != nil {
+	currentUser := r.Context().Value("currentUser").(*User)
+	err = p.getScenario()
+	if err == nil {
+		err = p.deleteScenario(currentUser.ID)
+	}
+	if err != nil {
 		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
+	recordActivity(r, p.ProjectID, ACTIVITY_SCENARIO, id, ACTIVITY_DELETED, p.Name)
+
 	respond(w, http.StatusOK, map[string]string{"result": "success"})
 }
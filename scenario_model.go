@@ -19,6 +19,7 @@ type Scenario struct {
 	Assists      []Assist `json:"assists"`
 	Status       int      `json:"status"`
 	Notes        string   `json:"notes"`
+	Deleted      bool     `json:"deleted"`
 }
 
 type Step struct {
@@ -65,11 +66,10 @@ func (p *Scenario) updateScenario() error {
 	return err
 }
 
-func (p *Scenario) deleteScenario() error {
-	_, err := app.DB.Exec(`
-	UPDATE scenarios SET deleted_at=NOW() WHERE id=$1
-	`, p.ID)
-	return err
+// deleteScenario moves the scenario to the recycle bin, out of the open
+// sessions.
+func (p *Scenario) deleteScenario(deletedBy string) error {
+	return deleteObject("scenario", p.ID, deletedBy)
 }
 
 func (p *Scenario) createScenario() error {
@@ -117,14 +117,16 @@ func getScenarios(start, count int, projectId string) ([]Scenario, error) {
 	return scenarios, nil
 }
 
+// getScenariosBySession lists the scenarios of the session. Closed sessions
+// keep the scenarios deleted since, flagged as deleted.
 func getScenariosBySession(start, count int, sessionID string) ([]Scenario, error) {
 	rows, err := app.DB.Query(`
-	SELECT scen.id, scen.name, scen.scope_id, scen.project_id
+	SELECT scen.id, scen.name, scen.scope_id, scen.project_id, scen.deleted_at IS NOT NULL
 	FROM scenarios scen, sessions s WHERE scen.id::text=ANY( s.scenarios) AND s.id::text=$3
-	AND scen.deleted_at IS NULL
+	AND (scen.deleted_at IS NULL OR s.status!=$4)
 	LIMIT $1 OFFSET $2
   `,
-		count, start, sessionID)
+		count, start, sessionID, SESSION_STATUS_OPEN)
 
 	if err != nil {
 		log.Println(err)
@@ -142,6 +144,7 @@ func getScenariosBySession(start, count int, sessionID string) ([]Scenario, erro
 			&p.Name,
 			&p.ScopeID,
 			&p.ProjectID,
+			&p.Deleted,
 		); err != nil {
 			log.Println(err)
 			return nil, err
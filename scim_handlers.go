@@ -0,0 +1,470 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+// The SCIM routes are not authenticated by Middleware, see authorizeSCIM
+const SCIM_PATH_PREFIX = "/scim/"
+
+func respondSCIM(w http.ResponseWriter, code int, payload interface{}) {
+	response, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(code)
+	w.Write(response)
+}
+
+func respondSCIMError(w http.ResponseWriter, code int, scimType, detail string) {
+	respondSCIM(w, code, ScimError{
+		Schemas:  []string{SCIM_SCHEMA_ERROR},
+		Status:   strconv.Itoa(code),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// authorizeSCIM checks the bearer token of the identity provider against
+// SCIM_TOKEN. SCIM is disabled when it is not set.
+func authorizeSCIM(w http.ResponseWriter, r *http.Request) bool {
+	token := os.Getenv("SCIM_TOKEN")
+	if len(token) < 1 {
+		respondSCIMError(w, http.StatusNotFound, "", "scim-disabled")
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(getBearerToken(r)), []byte(token)) != 1 {
+		log.Println("SCIM unauthorized", r.Method, r.URL.Path)
+		respondSCIMError(w, http.StatusUnauthorized, "", "unauthorized")
+		return false
+	}
+	log.Println("SCIM", r.Method, r.URL.Path) // Route log
+	return true
+}
+
+// scimPage returns the startIndex, from 1, and the count of a listing.
+func scimPage(r *http.Request) (int, int) {
+	startIndex, _ := strconv.Atoi(r.FormValue("startIndex"))
+	count, err := strconv.Atoi(r.FormValue("count"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if err != nil || count > SCIM_MAX_COUNT {
+		count = SCIM_MAX_COUNT
+	}
+	if count < 0 {
+		count = 0
+	}
+	return startIndex, count
+}
+
+func scimLocation(r *http.Request, resource, id string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + SCIM_PATH_PREFIX + "v2/" + resource + "/" + id
+}
+
+// decodeSCIM decodes the body of the request, responding with an error when
+// it is invalid.
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		log.Println(err)
+		respondSCIMError(w, http.StatusBadRequest, "invalidSyntax", "invalid-payload")
+		return false
+	}
+	return true
+}
+
+// scimID validates the ID of the route.
+func scimID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := mux.Vars(r)["id"]
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		return "", false
+	}
+	return id, true
+}
+
+func respondSCIMUserError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	switch err.Error() {
+	case "invalid-user-name", "invalid-patch", "invalid-value":
+		respondSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+	case "user-exists":
+		respondSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+	default:
+		respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+func (app *App) getScimUsers(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+
+	column, value, err := parseScimFilter(r.FormValue("filter"), SCIM_USER_FILTERS)
+	if err != nil {
+		respondSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := scimPage(r)
+
+	users, total, err := getScimUsers(column, value, startIndex, count)
+	if err != nil {
+		log.Println(err)
+		respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	for i := range users {
+		users[i].Meta.Location = scimLocation(r, "Users", users[i].ID)
+	}
+
+	respondSCIM(w, http.StatusOK, ScimListResponse{
+		Schemas:      []string{SCIM_SCHEMA_LIST_RESPONSE},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	})
+}
+
+func (app *App) getScimUser(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	respondSCIMUser(w, r, id, http.StatusOK)
+}
+
+// respondSCIMUser responds with the current state of the user.
+func respondSCIMUser(w http.ResponseWriter, r *http.Request, id string, code int) {
+	u, err := getScimUser(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+	u.Meta.Location = scimLocation(r, "Users", u.ID)
+
+	respondSCIM(w, code, u)
+}
+
+func (app *App) createScimUser(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+
+	var p ScimUser
+	if !decodeSCIM(w, r, &p) {
+		return
+	}
+
+	if err := p.createScimUser(); err != nil {
+		respondSCIMUserError(w, err)
+		return
+	}
+
+	respondSCIMUser(w, r, p.ID, http.StatusCreated)
+}
+
+func (app *App) replaceScimUser(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	var p ScimUser
+	if !decodeSCIM(w, r, &p) {
+		return
+	}
+	p.ID = id
+
+	if _, err := getScimUser(id); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+
+	if err := p.replaceScimUser(); err != nil {
+		respondSCIMUserError(w, err)
+		return
+	}
+
+	respondSCIMUser(w, r, id, http.StatusOK)
+}
+
+// patchScimUser applies the operations of the identity provider, most of
+// the time to deactivate the user.
+func (app *App) patchScimUser(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	var patch ScimPatch
+	if !decodeSCIM(w, r, &patch) {
+		return
+	}
+
+	u, err := getScimUser(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+
+	if err = u.applyPatch(patch.Operations); err != nil {
+		respondSCIMUserError(w, err)
+		return
+	}
+	if err = u.replaceScimUser(); err != nil {
+		respondSCIMUserError(w, err)
+		return
+	}
+
+	respondSCIMUser(w, r, id, http.StatusOK)
+}
+
+// deleteScimUser deactivates the user, it is kept with its projects.
+func (app *App) deleteScimUser(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := getScimUser(id); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+
+	if err := deactivateUser(id); err != nil {
+		log.Println(err)
+		respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respondSCIMGroupError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	switch err.Error() {
+	case "invalid-display-name", "invalid-member", "invalid-patch":
+		respondSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+func (app *App) getScimGroups(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+
+	column, value, err := parseScimFilter(r.FormValue("filter"), SCIM_GROUP_FILTERS)
+	if err != nil {
+		respondSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := scimPage(r)
+
+	groups, total, err := getScimGroups(column, value, startIndex, count)
+	if err != nil {
+		log.Println(err)
+		respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	for i := range groups {
+		groups[i].Meta.Location = scimLocation(r, "Groups", groups[i].ID)
+	}
+
+	respondSCIM(w, http.StatusOK, ScimListResponse{
+		Schemas:      []string{SCIM_SCHEMA_LIST_RESPONSE},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(groups),
+		Resources:    groups,
+	})
+}
+
+func (app *App) getScimGroup(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	respondSCIMGroup(w, r, id, http.StatusOK)
+}
+
+// respondSCIMGroup responds with the current state of the group.
+func respondSCIMGroup(w http.ResponseWriter, r *http.Request, id string, code int) {
+	g, err := getScimGroup(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+	g.Meta.Location = scimLocation(r, "Groups", g.ID)
+
+	respondSCIM(w, code, g)
+}
+
+func (app *App) createScimGroup(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+
+	var p ScimGroup
+	if !decodeSCIM(w, r, &p) {
+		return
+	}
+
+	if err := p.createScimGroup(); err != nil {
+		respondSCIMGroupError(w, err)
+		return
+	}
+
+	respondSCIMGroup(w, r, p.ID, http.StatusCreated)
+}
+
+func (app *App) replaceScimGroup(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	var p ScimGroup
+	if !decodeSCIM(w, r, &p) {
+		return
+	}
+	p.ID = id
+
+	if _, err := getScimGroup(id); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+
+	if err := p.replaceScimGroup(); err != nil {
+		respondSCIMGroupError(w, err)
+		return
+	}
+
+	respondSCIMGroup(w, r, id, http.StatusOK)
+}
+
+func (app *App) patchScimGroup(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	var patch ScimPatch
+	if !decodeSCIM(w, r, &patch) {
+		return
+	}
+
+	g, err := getScimGroup(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+
+	if err = g.patchScimGroup(patch.Operations); err != nil {
+		respondSCIMGroupError(w, err)
+		return
+	}
+
+	respondSCIMGroup(w, r, id, http.StatusOK)
+}
+
+func (app *App) deleteScimGroup(w http.ResponseWriter, r *http.Request) {
+	if !authorizeSCIM(w, r) {
+		return
+	}
+	id, ok := scimID(w, r)
+	if !ok {
+		return
+	}
+
+	g, err := getScimGroup(id)
+	if err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondSCIMError(w, http.StatusNotFound, "", "not-found")
+		} else {
+			respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		}
+		return
+	}
+
+	if err = g.deleteScimGroup(); err != nil {
+		log.Println(err)
+		respondSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
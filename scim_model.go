@@ -0,0 +1,790 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SCIM_SCHEMA_USER          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIM_SCHEMA_GROUP         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIM_SCHEMA_LIST_RESPONSE = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIM_SCHEMA_PATCH_OP      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIM_SCHEMA_ERROR         = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Largest page of a SCIM listing
+const SCIM_MAX_COUNT = 100
+
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+type ScimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Location     string `json:"location,omitempty"`
+}
+
+// ScimUser is a user as seen by the identity provider. The userName is the
+// email address of the user.
+type ScimUser struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        ScimName     `json:"name"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []ScimEmail  `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"` // Active unless set to false
+	Groups      []ScimMember `json:"groups,omitempty"`
+	Meta        ScimMeta     `json:"meta"`
+}
+
+type ScimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []ScimMember `json:"members"`
+	Meta        ScimMeta     `json:"meta"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimPatch struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations"`
+}
+
+type ScimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// Only the equality filters sent by the identity providers are supported,
+// e.g. userName eq "jane@example.com"
+var scimFilterPattern = regexp.MustCompile(`^\s*([A-Za-z.]+)\s+(?i:eq)\s+"([^"]*)"\s*$`)
+
+// Filterable attributes and their columns
+var SCIM_USER_FILTERS = map[string]string{
+	"username":     "email_address",
+	"emails.value": "email_address",
+	"externalid":   "scim_external_id",
+}
+
+var SCIM_GROUP_FILTERS = map[string]string{
+	"displayname": "display_name",
+	"externalid":  "external_id",
+}
+
+// parseScimFilter returns the column and the value of the filter, an empty
+// filter matches everything.
+func parseScimFilter(filter string, columns map[string]string) (string, string, error) {
+	if len(strings.TrimSpace(filter)) < 1 {
+		return "", "", nil
+	}
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", errors.New("invalid-filter")
+	}
+	column, ok := columns[strings.ToLower(match[1])]
+	if !ok {
+		return "", "", errors.New("invalid-filter")
+	}
+	return column, match[2], nil
+}
+
+// scimBool accepts the booleans sent as strings, e.g. "False" by Azure AD.
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, errors.New("invalid-value")
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, errors.New("invalid-value")
+}
+
+func scimTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (u *ScimUser) isActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+func (u *ScimUser) fullName() string {
+	if len(u.Name.Formatted) > 0 {
+		return u.Name.Formatted
+	}
+	if name := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); len(name) > 0 {
+		return name
+	}
+	return u.DisplayName
+}
+
+func (u *ScimUser) validate() error {
+	// Some providers only set the email addresses
+	if len(u.UserName) < 1 {
+		for _, email := range u.Emails {
+			if email.Primary || len(u.UserName) < 1 {
+				u.UserName = email.Value
+			}
+		}
+	}
+	if !strings.Contains(u.UserName, "@") {
+		return errors.New("invalid-user-name")
+	}
+	u.UserName = strings.ToLower(strings.TrimSpace(u.UserName))
+	return nil
+}
+
+func scanScimUser(row interface{ Scan(...interface{}) error }) (*ScimUser, error) {
+	u := ScimUser{Schemas: []string{SCIM_SCHEMA_USER}}
+	var externalID sql.NullString
+	var isActive bool
+	var createdAt, updatedAt time.Time
+	err := row.Scan(
+		&u.ID,
+		&u.UserName,
+		&u.Name.Formatted,
+		&externalID,
+		&isActive,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	u.ExternalID = externalID.String
+	u.DisplayName = u.Name.Formatted
+	u.Emails = []ScimEmail{{Value: u.UserName, Type: "work", Primary: true}}
+	u.Active = &isActive
+	u.Meta = ScimMeta{
+		ResourceType: "User",
+		Created:      scimTime(createdAt),
+		LastModified: scimTime(updatedAt),
+	}
+	return &u, nil
+}
+
+const scimUserColumns = `
+	id, email_address, full_name, scim_external_id, deleted_at IS NULL,
+	created_at, COALESCE(updated_at, created_at)
+`
+
+// getScimUser loads a user, deactivated ones included, with its groups.
+func getScimUser(id string) (*ScimUser, error) {
+	u, err := scanScimUser(app.DB.QueryRow(`
+	SELECT `+scimUserColumns+`
+	FROM users WHERE id::text=$1
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := app.DB.Query(`
+	SELECT g.id, g.display_name FROM groups g, group_members m
+	WHERE g.id=m.group_id AND m.user_id=$1 AND g.deleted_at IS NULL
+	ORDER BY g.display_name
+	`, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g ScimMember
+		if err := rows.Scan(&g.Value, &g.Display); err != nil {
+			return nil, err
+		}
+		u.Groups = append(u.Groups, g)
+	}
+	return u, nil
+}
+
+// getScimUsers lists the users matching the filter, startIndex starts at 1.
+func getScimUsers(column, value string, startIndex, count int) ([]ScimUser, int, error) {
+	filter, args := scimFilterClause(column, value)
+
+	var total int
+	err := app.DB.QueryRow(`
+	SELECT COUNT(*) FROM users WHERE `+filter, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	page, args := scimPageClause(args, startIndex, count)
+	rows, err := app.DB.Query(`
+	SELECT `+scimUserColumns+`
+	FROM users WHERE `+filter+`
+	ORDER BY created_at, id
+	`+page, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	users := []ScimUser{}
+
+	for rows.Next() {
+		u, err := scanScimUser(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, 0, err
+		}
+		users = append(users, *u)
+	}
+
+	return users, total, nil
+}
+
+// scimFilterClause matches the column case insensitively, as the email
+// addresses.
+func scimFilterClause(column, value string) (string, []interface{}) {
+	if len(column) < 1 {
+		return "TRUE", []interface{}{}
+	}
+	return "LOWER(" + column + ")=LOWER($1)", []interface{}{value}
+}
+
+// scimPageClause appends the page to the arguments, startIndex starts at 1.
+func scimPageClause(args []interface{}, startIndex, count int) (string, []interface{}) {
+	clause := fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	return clause, append(args, count, startIndex-1)
+}
+
+func (u *ScimUser) createScimUser() error {
+	if err := u.validate(); err != nil {
+		return err
+	}
+	err := app.DB.QueryRow(`
+	INSERT INTO users (email_address, full_name, scim_external_id)
+	VALUES ($1, $2, NULLIF($3, '')) RETURNING id
+	`,
+		u.UserName, u.fullName(), u.ExternalID,
+	).Scan(&u.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate") {
+			return errors.New("user-exists")
+		}
+		return err
+	}
+
+	// The owner access of the user to itself, as for createUser
+	access := Acl{
+		ObjectID:   u.ID,
+		ObjectType: "user",
+		UserID:     u.ID,
+		Access:     "OWNER",
+	}
+	if err = access.createAccess(); err != nil {
+		return err
+	}
+
+	if !u.isActive() {
+		return deactivateUser(u.ID)
+	}
+	return nil
+}
+
+// replaceScimUser updates the attributes of the user, deactivating or
+// reactivating it as needed.
+func (u *ScimUser) replaceScimUser() error {
+	if err := u.validate(); err != nil {
+		return err
+	}
+	_, err := app.DB.Exec(`
+	UPDATE users SET email_address=$1, full_name=$2, scim_external_id=NULLIF($3, ''), updated_at=NOW()
+	WHERE id::text=$4
+	`,
+		u.UserName, u.fullName(), u.ExternalID, u.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate") {
+			return errors.New("user-exists")
+		}
+		return err
+	}
+
+	if !u.isActive() {
+		return deactivateUser(u.ID)
+	}
+	_, err = app.DB.Exec(`
+	UPDATE users SET deleted_at=NULL WHERE id::text=$1
+	`, u.ID)
+	return err
+}
+
+// applyPatch applies the operations on the user, as sent by the identity
+// providers: a path with a value, or the attributes in the value.
+func (u *ScimUser) applyPatch(operations []ScimPatchOperation) error {
+	for _, op := range operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+		default:
+			return errors.New("invalid-patch")
+		}
+
+		values := map[string]json.RawMessage{}
+		if len(op.Path) > 0 {
+			values[op.Path] = op.Value
+		} else if err := json.Unmarshal(op.Value, &values); err != nil {
+			return errors.New("invalid-patch")
+		}
+
+		for path, value := range values {
+			var err error
+			switch strings.ToLower(path) {
+			case "active":
+				var active bool
+				active, err = scimBool(value)
+				u.Active = &active
+			case "username":
+				err = json.Unmarshal(value, &u.UserName)
+			case "externalid":
+				err = json.Unmarshal(value, &u.ExternalID)
+			case "displayname":
+				err = json.Unmarshal(value, &u.DisplayName)
+				u.Name.Formatted = u.DisplayName
+			case "name.formatted":
+				err = json.Unmarshal(value, &u.Name.Formatted)
+			case "name":
+				u.Name = ScimName{}
+				err = json.Unmarshal(value, &u.Name)
+			default:
+				// Attributes not kept by the API are ignored
+				continue
+			}
+			if err != nil {
+				return errors.New("invalid-patch")
+			}
+		}
+	}
+	return nil
+}
+
+// deactivateUser soft deletes the user, revokes its tokens and API keys and
+// removes its access to the projects of others, its teams and organizations
+// included. The projects it owns are kept.
+func deactivateUser(userID string) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE users SET deleted_at=NOW() WHERE id::text=$1 AND deleted_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if err = revokeUserTokensTx(tx, userID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE api_keys SET deleted_at=NOW() WHERE user_id=$1 AND deleted_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM access_control_lists WHERE user_id=$1 AND access!='OWNER'
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if err = cancelOwnershipTransfersTx(tx, "", userID); err != nil {
+		return err
+	}
+	// Teams and organizations would grant the access again
+	_, err = tx.Exec(`
+	DELETE FROM team_members WHERE user_id=$1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM organization_members WHERE user_id=$1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE impersonations SET ended_at=NOW() WHERE user_id=$1 AND ended_at IS NULL
+	`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (g *ScimGroup) validate() error {
+	if len(g.DisplayName) < 1 {
+		return errors.New("invalid-display-name")
+	}
+	return nil
+}
+
+func scanScimGroup(row interface{ Scan(...interface{}) error }) (*ScimGroup, error) {
+	g := ScimGroup{Schemas: []string{SCIM_SCHEMA_GROUP}, Members: []ScimMember{}}
+	var externalID sql.NullString
+	var createdAt, updatedAt time.Time
+	err := row.Scan(&g.ID, &g.DisplayName, &externalID, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	g.ExternalID = externalID.String
+	g.Meta = ScimMeta{
+		ResourceType: "Group",
+		Created:      scimTime(createdAt),
+		LastModified: scimTime(updatedAt),
+	}
+	return &g, nil
+}
+
+const scimGroupColumns = `
+	id, display_name, external_id, created_at, COALESCE(updated_at, created_at)
+`
+
+func (g *ScimGroup) getMembers() error {
+	rows, err := app.DB.Query(`
+	SELECT u.id, u.email_address FROM group_members m, users u
+	WHERE m.group_id=$1 AND u.id::text=m.user_id
+	ORDER BY u.email_address
+	`, g.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	g.Members = []ScimMember{}
+	for rows.Next() {
+		var m ScimMember
+		if err := rows.Scan(&m.Value, &m.Display); err != nil {
+			return err
+		}
+		g.Members = append(g.Members, m)
+	}
+	return nil
+}
+
+func getScimGroup(id string) (*ScimGroup, error) {
+	g, err := scanScimGroup(app.DB.QueryRow(`
+	SELECT `+scimGroupColumns+`
+	FROM groups WHERE id::text=$1 AND deleted_at IS NULL
+	`, id))
+	if err != nil {
+		return nil, err
+	}
+	if err = g.getMembers(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func getScimGroups(column, value string, startIndex, count int) ([]ScimGroup, int, error) {
+	filter, args := scimFilterClause(column, value)
+
+	var total int
+	err := app.DB.QueryRow(`
+	SELECT COUNT(*) FROM groups WHERE deleted_at IS NULL AND `+filter, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	page, args := scimPageClause(args, startIndex, count)
+	rows, err := app.DB.Query(`
+	SELECT `+scimGroupColumns+`
+	FROM groups WHERE deleted_at IS NULL AND `+filter+`
+	ORDER BY created_at, id
+	`+page, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, err
+	}
+
+	defer rows.Close()
+
+	groups := []ScimGroup{}
+
+	for rows.Next() {
+		g, err := scanScimGroup(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, 0, err
+		}
+		groups = append(groups, *g)
+	}
+	rows.Close()
+
+	for i := range groups {
+		if err := groups[i].getMembers(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return groups, total, nil
+}
+
+func (g *ScimGroup) createScimGroup() error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+	INSERT INTO groups (display_name, external_id) VALUES ($1, NULLIF($2, '')) RETURNING id
+	`, g.DisplayName, g.ExternalID).Scan(&g.ID)
+	if err != nil {
+		return err
+	}
+	if err = g.addMembers(tx, g.Members); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replaceScimGroup updates the group, its members are replaced.
+func (g *ScimGroup) replaceScimGroup() error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE groups SET display_name=$1, external_id=NULLIF($2, ''), updated_at=NOW() WHERE id=$3
+	`, g.DisplayName, g.ExternalID, g.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM group_members WHERE group_id=$1
+	`, g.ID)
+	if err != nil {
+		return err
+	}
+	if err = g.addMembers(tx, g.Members); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// patchScimGroup applies the operations on the name and the members.
+func (g *ScimGroup) patchScimGroup(operations []ScimPatchOperation) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, op := range operations {
+		path := strings.ToLower(op.Path)
+		var members []ScimMember
+		switch {
+		case path == "members":
+			if len(op.Value) > 0 {
+				if err = json.Unmarshal(op.Value, &members); err != nil {
+					return errors.New("invalid-patch")
+				}
+			}
+		case strings.HasPrefix(path, "members[") && strings.HasSuffix(path, "]"):
+			// members[value eq "id"]
+			match := scimFilterPattern.FindStringSubmatch(op.Path[len("members[") : len(op.Path)-1])
+			if match == nil || strings.ToLower(match[1]) != "value" {
+				return errors.New("invalid-patch")
+			}
+			members = []ScimMember{{Value: match[2]}}
+		case path == "displayname":
+			if err = json.Unmarshal(op.Value, &g.DisplayName); err != nil {
+				return errors.New("invalid-patch")
+			}
+		case path == "externalid":
+			if err = json.Unmarshal(op.Value, &g.ExternalID); err != nil {
+				return errors.New("invalid-patch")
+			}
+		case path == "":
+			var value struct {
+				DisplayName *string       `json:"displayName"`
+				ExternalID  *string       `json:"externalId"`
+				Members     *[]ScimMember `json:"members"`
+			}
+			if err = json.Unmarshal(op.Value, &value); err != nil {
+				return errors.New("invalid-patch")
+			}
+			if value.DisplayName != nil {
+				g.DisplayName = *value.DisplayName
+			}
+			if value.ExternalID != nil {
+				g.ExternalID = *value.ExternalID
+			}
+			if value.Members != nil {
+				members = *value.Members
+				path = "members"
+			}
+		default:
+			return errors.New("invalid-patch")
+		}
+
+		if path != "members" && !strings.HasPrefix(path, "members[") {
+			continue
+		}
+		switch strings.ToLower(op.Op) {
+		case "add":
+			err = g.addMembers(tx, members)
+		case "remove":
+			if len(members) < 1 && path == "members" {
+				// Without a value, every member is removed
+				_, err = tx.Exec(`DELETE FROM group_members WHERE group_id=$1`, g.ID)
+			} else {
+				err = g.removeMembers(tx, members)
+			}
+		case "replace":
+			_, err = tx.Exec(`DELETE FROM group_members WHERE group_id=$1`, g.ID)
+			if err == nil {
+				err = g.addMembers(tx, members)
+			}
+		default:
+			return errors.New("invalid-patch")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = g.validate(); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	UPDATE groups SET display_name=$1, external_id=NULLIF($2, ''), updated_at=NOW() WHERE id=$3
+	`, g.DisplayName, g.ExternalID, g.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (g *ScimGroup) deleteScimGroup() error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE groups SET deleted_at=NOW() WHERE id=$1
+	`, g.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM group_members WHERE group_id=$1
+	`, g.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addMembers adds the users to the group, unknown users are rejected.
+func (g *ScimGroup) addMembers(tx *sql.Tx, members []ScimMember) error {
+	if len(members) < 1 {
+		return nil
+	}
+	ids := []string{}
+	for _, m := range members {
+		ids = append(ids, m.Value)
+	}
+	var known int
+	err := tx.QueryRow(`
+	SELECT COUNT(*) FROM users WHERE id::text = ANY($1)
+	`, pq.Array(ids)).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if known < len(uniqueStrings(ids)) {
+		return errors.New("invalid-member")
+	}
+	_, err = tx.Exec(`
+	INSERT INTO group_members (group_id, user_id)
+	SELECT $1, id::text FROM users WHERE id::text = ANY($2)
+	ON CONFLICT DO NOTHING
+	`, g.ID, pq.Array(ids))
+	return err
+}
+
+func (g *ScimGroup) removeMembers(tx *sql.Tx, members []ScimMember) error {
+	ids := []string{}
+	for _, m := range members {
+		ids = append(ids, m.Value)
+	}
+	_, err := tx.Exec(`
+	DELETE FROM group_members WHERE group_id=$1 AND user_id = ANY($2)
+	`, g.ID, pq.Array(ids))
+	return err
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	unique := []string{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
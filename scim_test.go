@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testScimToken = "test-scim-token"
+
+func executeScimRequest(method, path, body string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer([]byte(body)))
+	req.Header.Set("Authorization", "Bearer "+testScimToken)
+	req.Header.Set("Content-Type", "application/scim+json")
+	response := executeRequest(req)
+	var m map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &m)
+	return response.Code, m
+}
+
+func TestScimUsers(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+	os.Setenv("SCIM_TOKEN", testScimToken)
+	defer os.Unsetenv("SCIM_TOKEN")
+
+	req, _ := http.NewRequest("GET", "/scim/v2/Users", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	code, user := executeScimRequest("POST", "/scim/v2/Users", `{
+		"schemas": ["`+SCIM_SCHEMA_USER+`"],
+		"userName": "Jane@Example.com",
+		"externalId": "00u1",
+		"name": {"givenName": "Jane", "familyName": "Doe"},
+		"active": true
+	}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "jane@example.com", user["userName"])
+	assert.Equal(t, "Jane Doe", user["displayName"])
+	assert.Equal(t, true, user["active"])
+	janeId := fmt.Sprintf("%s", user["id"])
+
+	code, m := executeScimRequest("POST", "/scim/v2/Users", `{"userName": "jane@example.com"}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "uniqueness", m["scimType"])
+
+	code, m = executeScimRequest("GET", `/scim/v2/Users?filter=userName+eq+"JANE@example.com"`, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), m["totalResults"])
+
+	code, m = executeScimRequest("GET", `/scim/v2/Users?filter=nickName+eq+"jane"`, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalidFilter", m["scimType"])
+
+	code, m = executeScimRequest("PUT", "/scim/v2/Users/"+janeId, `{
+		"userName": "jane@example.com",
+		"name": {"formatted": "Jane Smith"}
+	}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Jane Smith", m["displayName"])
+
+	// The second user collaborates on a project of the first one
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	json.Unmarshal(response.Body.Bytes(), &project)
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/api/invite/%s", project["inviteCode"]), nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	ownProject := createTestObject(t, testUserToken2, "/api/project", `{"name":"own project"}`)
+	apiKey := createTestObject(t, testUserToken2, "/api/api-key", `{"name":"ci","scope":"full"}`)
+
+	code, m = executeScimRequest("GET", `/scim/v2/Users?filter=userName+eq+"masepindrayana@gmail.com"`, "")
+	assert.Equal(t, http.StatusOK, code)
+	collaboratorId := fmt.Sprintf("%s", m["Resources"].([]interface{})[0].(map[string]interface{})["id"])
+
+	// Along with the memberships that grant access too
+	_, err := app.DB.Exec(`INSERT INTO organization_members (organization_id, user_id, role) VALUES (gen_random_uuid(), $1, 'MEMBER')`, collaboratorId)
+	assert.Equal(t, nil, err)
+	_, err = app.DB.Exec(`INSERT INTO team_members (team_id, user_id) VALUES (gen_random_uuid(), $1)`, collaboratorId)
+	assert.Equal(t, nil, err)
+
+	// Deactivated as sent by Azure AD
+	code, m = executeScimRequest("PATCH", "/scim/v2/Users/"+collaboratorId, `{
+		"schemas": ["`+SCIM_SCHEMA_PATCH_OP+`"],
+		"Operations": [{"op": "Replace", "path": "active", "value": "False"}]
+	}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, m["active"])
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", "Bearer "+fmt.Sprintf("%s", apiKey["key"]))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+
+	// The collaborator access is removed, the own projects are kept
+	var acls int
+	err = app.DB.QueryRow(`SELECT COUNT(*) FROM access_control_lists WHERE user_id=$1 AND object_id=$2`,
+		collaboratorId, projectId).Scan(&acls)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, acls)
+	err = app.DB.QueryRow(`SELECT COUNT(*) FROM access_control_lists WHERE user_id=$1 AND object_id=$2`,
+		collaboratorId, ownProject["id"]).Scan(&acls)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, acls)
+	var memberships int
+	err = app.DB.QueryRow(`SELECT (SELECT COUNT(*) FROM organization_members WHERE user_id=$1)
+	+ (SELECT COUNT(*) FROM team_members WHERE user_id=$1)`, collaboratorId).Scan(&memberships)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, memberships)
+
+	code, _ = executeScimRequest("DELETE", "/scim/v2/Users/"+janeId, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, m = executeScimRequest("GET", "/scim/v2/Users/"+janeId, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, m["active"])
+
+	// Reactivated
+	code, m = executeScimRequest("PATCH", "/scim/v2/Users/"+janeId, `{
+		"Operations": [{"op": "replace", "value": {"active": true}}]
+	}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, m["active"])
+}
+
+func TestScimGroups(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+	os.Setenv("SCIM_TOKEN", testScimToken)
+	defer os.Unsetenv("SCIM_TOKEN")
+
+	_, jane := executeScimRequest("POST", "/scim/v2/Users", `{"userName": "jane@example.com"}`)
+	janeId := fmt.Sprintf("%s", jane["id"])
+	_, john := executeScimRequest("POST", "/scim/v2/Users", `{"userName": "john@example.com"}`)
+	johnId := fmt.Sprintf("%s", john["id"])
+
+	code, group := executeScimRequest("POST", "/scim/v2/Groups", `{
+		"displayName": "Mobile QA",
+		"members": [{"value": "`+janeId+`"}]
+	}`)
+	assert.Equal(t, http.StatusCreated, code)
+	groupId := fmt.Sprintf("%s", group["id"])
+	assert.Equal(t, 1, len(group["members"].([]interface{})))
+
+	code, m := executeScimRequest("POST", "/scim/v2/Groups", `{
+		"displayName": "Web QA",
+		"members": [{"value": "`+groupId+`"}]
+	}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid-member", m["detail"])
+
+	code, m = executeScimRequest("PATCH", "/scim/v2/Groups/"+groupId, `{
+		"Operations": [
+			{"op": "add", "path": "members", "value": [{"value": "`+johnId+`"}]},
+			{"op": "remove", "path": "members[value eq \"`+janeId+`\"]"},
+			{"op": "replace", "path": "displayName", "value": "Android QA"}
+		]
+	}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Android QA", m["displayName"])
+	members := m["members"].([]interface{})
+	assert.Equal(t, 1, len(members))
+	assert.Equal(t, johnId, members[0].(map[string]interface{})["value"])
+
+	code, m = executeScimRequest("GET", "/scim/v2/Users/"+johnId, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Android QA", m["groups"].([]interface{})[0].(map[string]interface{})["display"])
+
+	code, m = executeScimRequest("GET", `/scim/v2/Groups?filter=displayName+eq+"Android QA"`, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, float64(1), m["totalResults"])
+
+	code, _ = executeScimRequest("DELETE", "/scim/v2/Groups/"+groupId, "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = executeScimRequest("GET", "/scim/v2/Groups/"+groupId, "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -22,6 +21,10 @@ func (app *App) getScope(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
 	p := Scope{ID: id}
 	if err = p.getScope(); err != nil {
 		switch err {
@@ -48,26 +51,7 @@ func (app *App) getScopes(w http.ResponseWriter, r *http.Request) {
 		start = 0
 	}
 
-	currentUser := r.Context().Value("currentUser")
-	if currentUser == nil {
-		log.Println("currentUser is empty")
-		err := errors.New("current-user-is-empty")
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	access := Acl{
-		ObjectID: projectId,
-		UserID:   currentUser.(*User).ID,
-	}
-	err := access.getAccess()
-	if err != nil {
-		log.Println(err)
-		if err == sql.ErrNoRows {
-			respondError(w, http.StatusForbidden, "forbidden")
-		} else {
-			respondError(w, http.StatusInternalServerError, err.Error())
-		}
+	if !authorizeReference(w, r, projectId, PERMISSION_VIEW) {
 		return
 	}
 
@@ -111,7 +95,6 @@ func (app *App) getScopes(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) createScope(w http.ResponseWriter, r *http.Request) {
-	var err error
 	var p Scope
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&p); err != nil {
@@ -121,6 +104,10 @@ func (app *App) createScope(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if !authorizeReference(w, r, p.ProjectID, PERMISSION_EDIT_SCOPES) {
+		return
+	}
+
 	gb := NewGrowthBook(app.GBFeatures, "")
 	isContentCreationLimiterEnabled := gb.Feature(`content_creation_limiter`).On
 	if isContentCreationLimiterEnabled {
@@ -142,19 +129,7 @@ func (app *App) createScope(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	currentUser := r.Context().Value("currentUser").(*User)
-	access := Acl{
-		ObjectID:   p.ID,
-		ObjectType: "scope",
-		UserID:     currentUser.ID,
-		Access:     "OWNER",
-	}
-	err = access.createAccess()
-	if err != nil {
-		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	recordActivity(r, p.ProjectID, ACTIVITY_SCOPE, p.ID, ACTIVITY_CREATED, p.Name)
 
 	respond(w, http.StatusCreated, p)
 }
@@ -169,6 +144,10 @@ func (app *App) updateScope(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_EDIT_SCOPES) {
+		return
+	}
+
 	var p Scope
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&p); err != nil {
@@ -179,12 +158,29 @@ func (app *App) updateScope(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	p.ID = id
 
+	current := Scope{ID: id}
+	if err := current.getScope(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
 	if err := p.updateScope(); err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	description := p.Name
+	if p.Name != current.Name {
+		description = current.Name + " → " + p.Name
+	}
+	recordActivity(r, current.ProjectID, ACTIVITY_SCOPE, id, ACTIVITY_UPDATED, description)
+
 	respond(w, http.StatusOK, p)
 }
 
@@ -198,12 +194,27 @@ func (app *App) deleteScope(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
 	p := Scope{ID: id}
-	if err := p.deleteScope(); err != nil {
+	currentUser := r.Context().Value("currentUser").(*User)
+	err = p.getScope()
+	if err == nil {
+		err = p.deleteScope(currentUser.ID)
+	}
+	if err != nil {
 		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
+	recordActivity(r, p.ProjectID, ACTIVITY_SCOPE, id, ACTIVITY_DELETED, p.Name)
+
 	respond(w, http.StatusOK, map[string]string{"result": "success"})
 }
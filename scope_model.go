@@ -38,17 +38,9 @@ func (p *Scope) updateScope() error {
 	return err
 }
 
-func (p *Scope) deleteScope() error {
-	_, err := app.DB.Exec(`
-	UPDATE scopes SET deleted_at=NOW() WHERE id=$1;
-	`, p.ID)
-	if err != nil {
-		return err
-	}
-	_, err = app.DB.Exec(`
-	UPDATE scenarios SET deleted_at=NOW() WHERE scope_id=$1;
-	`, p.ID)
-	return err
+// deleteScope moves the scope to the recycle bin with its scenarios.
+func (p *Scope) deleteScope(deletedBy string) error {
+	return deleteObject("scope", p.ID, deletedBy)
 }
 
 func (p *Scope) createScope() error {
@@ -3,7 +3,6 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -22,6 +21,10 @@ func (app *App) getSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
 	p := Session{ID: id}
 	if err = p.getSession(); err != nil {
 		log.Println(err)
@@ -90,11 +93,7 @@ func (app *App) getSessions(w http.ResponseWriter, r *http.Request) {
 	log.Println(count)
 	log.Println(start)
 
-	currentUser := r.Context().Value("currentUser")
-	if currentUser == nil {
-		log.Println("currentUser is empty")
-		err := errors.New("current-user-is-empty")
-		respondError(w, http.StatusInternalServerError, err.Error())
+	if !authorizeReference(w, r, projectId, PERMISSION_VIEW) {
 		return
 	}
 
@@ -119,6 +118,21 @@ func (app *App) createSession(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if !authorizeReference(w, r, p.ProjectID, PERMISSION_MANAGE_SESSIONS) {
+		return
+	}
+
+	foreign, err := p.hasForeignScenarios()
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if foreign {
+		respondError(w, http.StatusBadRequest, "invalid-scenario")
+		return
+	}
+
 	currentUser := r.Context().Value("currentUser").(*User)
 	p.AuthorID = currentUser.ID
 
@@ -143,18 +157,7 @@ func (app *App) createSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	access := Acl{
-		ObjectID:   p.ID,
-		ObjectType: "session",
-		UserID:     currentUser.ID,
-		Access:     "OWNER",
-	}
-	err = access.createAccess()
-	if err != nil {
-		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	recordActivity(r, p.ProjectID, ACTIVITY_SESSION, p.ID, ACTIVITY_OPENED, p.Version)
 
 	respond(w, http.StatusCreated, p)
 }
@@ -179,15 +182,82 @@ func (app *App) updateSession(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	p.ID = id
 
+	if !authorize(w, r, id, PERMISSION_VIEW) {
+		return
+	}
+
+	// Closing a session and editing it are granted separately
+	current := Session{ID: id}
+	if err := current.getSession(); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	scenarioIDs, err := current.getScenarioIDs()
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	isEdited := p.Version != current.Version || p.Description != current.Description ||
+		!isSameScenarios(p.Scenarios, scenarioIDs)
+	if p.Status != current.Status {
+		if !authorize(w, r, id, PERMISSION_CLOSE_SESSIONS) {
+			return
+		}
+	}
+	if isEdited {
+		if !authorize(w, r, id, PERMISSION_MANAGE_SESSIONS) {
+			return
+		}
+	}
+
+	if !isSameScenarios(p.Scenarios, scenarioIDs) {
+		p.ProjectID = current.ProjectID
+		foreign, err := p.hasForeignScenarios()
+		if err != nil {
+			log.Println(err)
+			respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if foreign {
+			respondError(w, http.StatusBadRequest, "invalid-scenario")
+			return
+		}
+	}
+
 	if err := p.updateSession(); err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if p.Status != current.Status && (p.Status == SESSION_STATUS_OPEN || current.Status == SESSION_STATUS_OPEN) {
+		action := ACTIVITY_CLOSED
+		if p.Status == SESSION_STATUS_OPEN {
+			action = ACTIVITY_OPENED
+		}
+		recordActivity(r, current.ProjectID, ACTIVITY_SESSION, id, action, p.Version)
+	}
+
 	respond(w, http.StatusOK, p)
 }
 
+func isSameScenarios(scenarios []Scenario, ids []string) bool {
+	if len(scenarios) != len(ids) {
+		return false
+	}
+	for i, scen := range scenarios {
+		if scen.ID != ids[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (app *App) deleteSession(w http.ResponseWriter, r *http.Request) {
 	var err error
 	vars := mux.Vars(r)
@@ -198,10 +268,19 @@ func (app *App) deleteSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
 	p := Session{ID: id}
-	if err := p.deleteSession(); err != nil {
+	currentUser := r.Context().Value("currentUser").(*User)
+	if err := p.deleteSession(currentUser.ID); err != nil {
 		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
@@ -218,6 +297,10 @@ func (app *App) resetSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_MANAGE_SESSIONS) {
+		return
+	}
+
 	p := Session{ID: id}
 	if err := p.resetSession(); err != nil {
 		log.Println(err)
@@ -238,6 +321,35 @@ func (app *App) createTest(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	// Tests inherit the access of their session
+	if !authorizeReference(w, r, p.SessionID, PERMISSION_EXECUTE_TESTS) {
+		return
+	}
+
+	// The scenario has to belong to the project of the session
+	session := Session{ID: p.SessionID}
+	if err := session.getSession(); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	s := Scenario{ID: p.ScenarioID}
+	if err := s.getScenario(); err != nil {
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusBadRequest, "invalid-scenario")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if s.ProjectID != session.ProjectID {
+		respondError(w, http.StatusBadRequest, "invalid-scenario")
+		return
+	}
+
 	currentUser := r.Context().Value("currentUser").(*User)
 	/*
 		0: unassigned
@@ -282,36 +394,15 @@ func (app *App) createTest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If there is no such test, create one
-	// Get the scenario first
-	s := Scenario{ID: p.ScenarioID}
-	err = s.getScenario()
-	if err != nil {
-		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-	if s.ID != "" {
-		p.Steps = s.Steps
-	}
+	// If there is no such test, create one from the scenario
+	p.Steps = s.Steps
 	if err := p.createTest(); err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	access := Acl{
-		ObjectID:   p.ID,
-		ObjectType: "test",
-		UserID:     currentUser.ID,
-		Access:     "OWNER",
-	}
-	err = access.createAccess()
-	if err != nil {
-		log.Println(err)
-		respondError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
+	recordActivity(r, session.ProjectID, ACTIVITY_TEST, p.ID, ACTIVITY_CLAIMED, s.Name)
 
 	respond(w, http.StatusCreated, p)
 }
@@ -326,6 +417,10 @@ func (app *App) deleteTest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_EXECUTE_TESTS) {
+		return
+	}
+
 	p := Test{ID: id}
 	if err := p.deleteTest(); err != nil {
 		log.Println(err)
@@ -346,6 +441,10 @@ func (app *App) updateTest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_EXECUTE_TESTS) {
+		return
+	}
+
 	var p Test
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&p); err != nil {
@@ -356,11 +455,35 @@ func (app *App) updateTest(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	p.ID = id
 
+	current := Test{ID: id}
+	if err := current.getTest(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
 	if err := p.updateTest(); err != nil {
 		log.Println(err)
 		respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	if p.Status != current.Status && (p.Status == TEST_STATUS_PASSED || p.Status == TEST_STATUS_FAILED) {
+		action := ACTIVITY_PASSED
+		if p.Status == TEST_STATUS_FAILED {
+			action = ACTIVITY_FAILED
+		}
+		s := Scenario{ID: current.ScenarioID}
+		if err := s.getScenario(); err != nil {
+			log.Println(err)
+		} else {
+			recordActivity(r, s.ProjectID, ACTIVITY_TEST, id, action, s.Name)
+		}
+	}
+
 	respond(w, http.StatusOK, p)
 }
@@ -38,6 +38,13 @@ type Test struct {
 	Assists      []Assist `json:"assists"`
 }
 
+// Statuses of the tests, 0 being unassigned
+const (
+	TEST_STATUS_ONTEST = 1
+	TEST_STATUS_PASSED = 2
+	TEST_STATUS_FAILED = 3
+)
+
 type Sessions struct {
 	Data  []Session `json:"data"`
 	Page  int32     `json:"page"`
@@ -66,11 +73,48 @@ func (p *Session) getSession() error {
 
 }
 
-func (p *Session) deleteSession() error {
-	_, err := app.DB.Exec(`
-	UPDATE sessions SET deleted_at=NOW() WHERE id=$1
-	`, p.ID)
-	return err
+func (p *Session) getScenarioIDs() ([]string, error) {
+	ids := []string{}
+	err := app.DB.QueryRow(`
+	SELECT scenarios FROM sessions WHERE id=$1
+	AND deleted_at IS NULL
+	`,
+		p.ID).Scan(pq.Array(&ids))
+	return ids, err
+}
+
+// hasForeignScenarios tells whether some of the scenarios of the session
+// are not scenarios of its project.
+func (p *Session) hasForeignScenarios() (bool, error) {
+	ids := map[string]bool{}
+	for _, scen := range p.Scenarios {
+		ids[scen.ID] = true
+	}
+	arr := []string{}
+	for id := range ids {
+		arr = append(arr, id)
+	}
+	if len(arr) < 1 {
+		return false, nil
+	}
+
+	var count int
+	err := app.DB.QueryRow(`
+	SELECT COUNT(*) FROM scenarios
+	WHERE id::text=ANY($1) AND project_id=$2
+	AND deleted_at IS NULL
+	`,
+		pq.Array(arr), p.ProjectID).Scan(&count)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return count != len(arr), nil
+}
+
+// deleteSession moves the session to the recycle bin with its tests.
+func (p *Session) deleteSession(deletedBy string) error {
+	return deleteObject("session", p.ID, deletedBy)
 }
 
 func (p *Session) resetSession() error {
@@ -358,6 +402,14 @@ func (p *Test) getTestByAssignee() error {
 	return nil
 }
 
+func (p *Test) getTest() error {
+	return app.DB.QueryRow(`
+	SELECT session_id, scenario_id, assignee_id, status FROM tests WHERE id=$1
+	AND deleted_at IS NULL
+	`,
+		p.ID).Scan(&p.SessionID, &p.ScenarioID, &p.AssigneeID, &p.Status)
+}
+
 func (p *Test) deleteTest() error {
 	_, err := app.DB.Exec(`
 	UPDATE tests SET status=3, deleted_at=NOW() WHERE id=$1
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Storage is the object storage backend of the blobs. Objects are
+// addressed by bucket and key.
+type Storage interface {
+	MakeBucket(ctx context.Context, bucket string) error
+	Put(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string) error
+	// Get reads the object starting at offset. A negative length reads
+	// until the end of the object.
+	Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, bucket, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, bucket, key string) error
+	// Presign returns a time-limited URL to download the object directly
+	// from the storage, or ErrPresignNotSupported.
+	Presign(ctx context.Context, bucket, key string, expiry time.Duration) (string, error)
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+var (
+	ErrObjectNotFound      = errors.New("object-not-found")
+	ErrPresignNotSupported = errors.New("presign-not-supported")
+)
+
+const (
+	STORAGE_DRIVER_MINIO = "minio"
+	STORAGE_DRIVER_LOCAL = "local"
+)
+
+// newStorage creates the storage backend selected by STORAGE_DRIVER.
+func newStorage() (Storage, error) {
+	switch os.Getenv("STORAGE_DRIVER") {
+	case STORAGE_DRIVER_LOCAL:
+		path := os.Getenv("STORAGE_PATH")
+		if len(path) < 1 {
+			path = "./data/blobs"
+		}
+		return NewLocalStorage(path)
+	case "", STORAGE_DRIVER_MINIO:
+		return NewMinioStorage(
+			os.Getenv("S3_URL"),
+			os.Getenv("S3_ACCESS_KEY"),
+			os.Getenv("S3_SECRET_KEY"),
+			false,
+		)
+	default:
+		return nil, errors.New("unknown-storage-driver")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalStorage keeps the objects on the local filesystem, one directory
+// per bucket. It is meant for small self-hosted installs and tests.
+type LocalStorage struct {
+	basePath string
+}
+
+func NewLocalStorage(basePath string) (*LocalStorage, error) {
+	s := &LocalStorage{basePath: basePath}
+	if err := os.MkdirAll(basePath, 0750); err != nil {
+		return nil, err
+	}
+	if err := s.MakeBucket(context.Background(), DEFAULT_BUCKET); err != nil {
+		return nil, err
+	}
+	if err := s.MakeBucket(context.Background(), PUBLIC_BUCKET); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *LocalStorage) path(bucket, key string) (string, error) {
+	for _, part := range []string{bucket, key} {
+		if len(part) < 1 || part == "." || part == ".." ||
+			strings.ContainsAny(part, `/\`) {
+			return "", errors.New("invalid-object-key")
+		}
+	}
+	return filepath.Join(s.basePath, bucket, key), nil
+}
+
+func (s *LocalStorage) MakeBucket(ctx context.Context, bucket string) error {
+	path, err := s.path(bucket, "_")
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Dir(path), 0750)
+}
+
+func (s *LocalStorage) Put(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string) error {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial object
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+type localObject struct {
+	io.Reader
+	file *os.File
+}
+
+func (o *localObject) Close() error {
+	return o.file.Close()
+}
+
+func (s *LocalStorage) Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	var reader io.Reader = file
+	if length >= 0 {
+		reader = io.LimitReader(file, length)
+	}
+	return &localObject{Reader: reader, file: file}, nil
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, bucket, key string) error {
+	path, err := s.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) Presign(ctx context.Context, bucket, key string, expiry time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
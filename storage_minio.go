@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type Minio struct {
+	bucketName     string
+	bucketLocation string
+	client         *minio.Client
+}
+
+func NewMinioStorage(endpoint, accessKey, secretKey string, useSSL bool) (*Minio, error) {
+	var err error
+	s := &Minio{
+		bucketName:     DEFAULT_BUCKET,
+		bucketLocation: "us-east-1",
+	}
+
+	cred := credentials.NewStaticV4(accessKey, secretKey, "")
+	// Initialize minio client object.
+	s.client, err = minio.New(endpoint, &minio.Options{
+		Creds:  cred,
+		Secure: useSSL,
+	})
+	if err != nil {
+		log.Println(endpoint)
+		log.Println(err)
+		return nil, err
+	}
+
+	ctx, delay := context.WithTimeout(context.Background(), 5*time.Second)
+	defer delay()
+	_ = s.MakeBucket(ctx, s.bucketName)
+	// Prepare other buckets
+	if err = s.MakeBucket(ctx, PUBLIC_BUCKET); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Minio) MakeBucket(ctx context.Context, bucket string) error {
+	err := s.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{
+		Region: s.bucketLocation,
+	})
+	if err != nil {
+		exists, errBucketExists := s.client.BucketExists(ctx, bucket)
+		if errBucketExists == nil && exists {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Minio) Put(ctx context.Context, bucket, key string, data io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, bucket, key, data, size,
+		minio.PutObjectOptions{
+			ContentType: contentType,
+			PartSize:    10 * 1024 * 1024,
+		})
+	return err
+}
+
+func (s *Minio) Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if offset > 0 || length >= 0 {
+		end := int64(0) // Until the end of the object
+		if length >= 0 {
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := s.client.GetObject(ctx, bucket, key, opts)
+	if err != nil {
+		return nil, minioError(err)
+	}
+	// GetObject is lazy, missing objects only fail once read. Stat sends the
+	// request so that they fail here, before callers write a response.
+	if _, err = obj.Stat(); err != nil {
+		obj.Close()
+		return nil, minioError(err)
+	}
+	return obj, nil
+}
+
+func (s *Minio) Stat(ctx context.Context, bucket, key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, minioError(err)
+	}
+	return &ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *Minio) Delete(ctx context.Context, bucket, key string) error {
+	return s.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *Minio) Presign(ctx context.Context, bucket, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, bucket, key, expiry, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func minioError(err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrObjectNotFound
+	}
+	return err
+}
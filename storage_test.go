@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageLocal(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStorage(t.TempDir())
+	assert.Equal(t, nil, err)
+
+	content := []byte("0123456789")
+	err = s.Put(ctx, DEFAULT_BUCKET, "object", bytes.NewReader(content), int64(len(content)), "text/plain")
+	assert.Equal(t, nil, err)
+
+	info, err := s.Stat(ctx, DEFAULT_BUCKET, "object")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(10), info.Size)
+
+	// Whole object
+	obj, err := s.Get(ctx, DEFAULT_BUCKET, "object", 0, -1)
+	assert.Equal(t, nil, err)
+	data, _ := ioutil.ReadAll(obj)
+	obj.Close()
+	assert.Equal(t, "0123456789", string(data))
+
+	// Range
+	obj, err = s.Get(ctx, DEFAULT_BUCKET, "object", 2, 3)
+	assert.Equal(t, nil, err)
+	data, _ = ioutil.ReadAll(obj)
+	obj.Close()
+	assert.Equal(t, "234", string(data))
+
+	_, err = s.Presign(ctx, DEFAULT_BUCKET, "object", 0)
+	assert.Equal(t, ErrPresignNotSupported, err)
+
+	// Keys can not escape the bucket
+	err = s.Put(ctx, DEFAULT_BUCKET, "../object", bytes.NewReader(content), int64(len(content)), "text/plain")
+	assert.NotEqual(t, nil, err)
+
+	err = s.Delete(ctx, DEFAULT_BUCKET, "object")
+	assert.Equal(t, nil, err)
+	_, err = s.Stat(ctx, DEFAULT_BUCKET, "object")
+	assert.Equal(t, ErrObjectNotFound, err)
+}
+
+func TestParseRange(t *testing.T) {
+	offset, length, ok := parseRange("bytes=2-4")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(2), offset)
+	assert.Equal(t, int64(3), length)
+
+	offset, length, ok = parseRange("bytes=5-")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, int64(5), offset)
+	assert.Equal(t, int64(-1), length)
+
+	_, _, ok = parseRange("bytes=0-1,4-5")
+	assert.Equal(t, false, ok)
+	_, _, ok = parseRange("")
+	assert.Equal(t, false, ok)
+}
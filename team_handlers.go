@@ -0,0 +1,325 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	uuidParser "github.com/docker/distribution/uuid"
+	"github.com/gorilla/mux"
+)
+
+func (app *App) getTeams(w http.ResponseWriter, r *http.Request) {
+	currentUser := r.Context().Value("currentUser").(*User)
+
+	teams, err := getTeams(currentUser.ID)
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, teams)
+}
+
+// createTeam creates a team managed by the current user, and by the admins
+// of the organization when organizationId is set.
+func (app *App) createTeam(w http.ResponseWriter, r *http.Request) {
+	var p Team
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+
+	if len(p.OrganizationID) > 0 {
+		if _, ok := authorizeOrganization(w, r, p.OrganizationID, ORG_ROLE_OWNER, ORG_ROLE_ADMIN); !ok {
+			return
+		}
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	p.CreatedBy = currentUser.ID
+
+	if err := p.createTeam(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-name":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+// getAuthorizedTeam loads the team of the request. Members see the team,
+// managers change it.
+func getAuthorizedTeam(w http.ResponseWriter, r *http.Request, manage bool) (Team, bool) {
+	id := mux.Vars(r)["id"]
+	p := Team{ID: id}
+	if _, err := uuidParser.Parse(id); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return p, false
+	}
+
+	if err := p.getTeam(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "item-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return p, false
+	}
+
+	if apiKey := getRequestApiKey(r); apiKey != nil && len(apiKey.ProjectID) > 0 {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return p, false
+	}
+	if isAdmin(r) {
+		return p, true
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	isGranted, err := p.canManageTeam(currentUser.ID)
+	if err == nil && !isGranted && !manage {
+		isGranted, err = p.isTeamMember(currentUser.ID)
+	}
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return p, false
+	}
+	if !isGranted {
+		log.Println("FORBIDDEN", "team", p.ID)
+		respondError(w, http.StatusForbidden, "forbidden")
+		return p, false
+	}
+	return p, true
+}
+
+func (app *App) getTeam(w http.ResponseWriter, r *http.Request) {
+	p, ok := getAuthorizedTeam(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := p.getTeamMembers(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, p)
+}
+
+func (app *App) updateTeam(w http.ResponseWriter, r *http.Request) {
+	p, ok := getAuthorizedTeam(w, r, true)
+	if !ok {
+		return
+	}
+
+	var payload Team
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.Name = payload.Name
+
+	if err := p.updateTeam(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-name":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, p)
+}
+
+func (app *App) deleteTeam(w http.ResponseWriter, r *http.Request) {
+	p, ok := getAuthorizedTeam(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := p.deleteTeam(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// addTeamMember adds a user by email address, the user gets the access of
+// the team on its projects right away.
+func (app *App) addTeamMember(w http.ResponseWriter, r *http.Request) {
+	team, ok := getAuthorizedTeam(w, r, true)
+	if !ok {
+		return
+	}
+
+	var p TeamMember
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&p); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.TeamID = team.ID
+
+	if err := p.addTeamMember(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "user-not-found":
+			respondError(w, http.StatusNotFound, err.Error())
+		case "already-member":
+			respondError(w, http.StatusConflict, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusCreated, p)
+}
+
+func (app *App) removeTeamMember(w http.ResponseWriter, r *http.Request) {
+	team, ok := getAuthorizedTeam(w, r, true)
+	if !ok {
+		return
+	}
+
+	userId := mux.Vars(r)["userId"]
+	if _, err := uuidParser.Parse(userId); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	p := TeamMember{TeamID: team.ID, UserID: userId}
+	if err := p.removeTeamMember(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "member-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+// teamGrant checks the permission to manage the collaborators of the project
+// and that the team is visible to the current user.
+func teamGrant(w http.ResponseWriter, r *http.Request) (TeamGrant, bool) {
+	vars := mux.Vars(r)
+	p := TeamGrant{ProjectID: vars["projectId"], TeamID: vars["teamId"]}
+	if _, err := uuidParser.Parse(p.ProjectID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return p, false
+	}
+	if _, err := uuidParser.Parse(p.TeamID); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return p, false
+	}
+
+	if !authorize(w, r, p.ProjectID, PERMISSION_MANAGE_COLLABORATORS) {
+		return p, false
+	}
+
+	team := Team{ID: p.TeamID}
+	if err := team.getTeam(); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			respondError(w, http.StatusNotFound, "team-not-found")
+		} else {
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return p, false
+	}
+	if isAdmin(r) {
+		return p, true
+	}
+
+	currentUser := r.Context().Value("currentUser").(*User)
+	isGranted, err := team.canManageTeam(currentUser.ID)
+	if err == nil && !isGranted {
+		isGranted, err = team.isTeamMember(currentUser.ID)
+	}
+	if err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return p, false
+	}
+	if !isGranted {
+		respondError(w, http.StatusNotFound, "team-not-found")
+		return p, false
+	}
+	return p, true
+}
+
+// setTeamAccess grants the access to all the members of the team, present
+// and future.
+func (app *App) setTeamAccess(w http.ResponseWriter, r *http.Request) {
+	p, ok := teamGrant(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Access string `json:"access"`
+		RoleID string `json:"roleId"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&payload); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusBadRequest, "invalid-payload")
+		return
+	}
+	defer r.Body.Close()
+	p.Access = payload.Access
+	p.RoleID = payload.RoleID
+
+	if err := p.setTeamGrant(); err != nil {
+		log.Println(err)
+		switch err.Error() {
+		case "invalid-access-level", "invalid-role":
+			respondError(w, http.StatusBadRequest, err.Error())
+		default:
+			respondError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	respond(w, http.StatusOK, p)
+}
+
+func (app *App) dropTeamAccess(w http.ResponseWriter, r *http.Request) {
+	p, ok := teamGrant(w, r)
+	if !ok {
+		return
+	}
+
+	if err := p.dropTeamGrant(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
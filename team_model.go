@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+)
+
+type Team struct {
+	ID             string       `json:"id"`
+	Name           string       `json:"name"`
+	OrganizationID string       `json:"organizationId"`
+	CreatedBy      string       `json:"createdBy"`
+	Members        []TeamMember `json:"members,omitempty"`
+	CreatedAt      string       `json:"createdAt"`
+}
+
+type TeamMember struct {
+	TeamID       string `json:"teamId"`
+	UserID       string `json:"userId"`
+	EmailAddress string `json:"emailAddress"`
+	Username     string `json:"username"`
+	CreatedAt    string `json:"createdAt"`
+}
+
+// TeamGrant gives the members of the team an access on the project.
+type TeamGrant struct {
+	TeamID    string `json:"teamId"`
+	ProjectID string `json:"projectId"`
+	Access    string `json:"access"`
+	RoleID    string `json:"roleId"`
+	CreatedAt string `json:"createdAt"`
+}
+
+func (t *Team) validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if len(t.Name) < 1 {
+		return errors.New("invalid-name")
+	}
+	return nil
+}
+
+func (t *Team) createTeam() error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	return app.DB.QueryRow(`
+	INSERT INTO teams (name, organization_id, created_by) VALUES ($1, NULLIF($2, '')::uuid, $3)
+	RETURNING id, created_at
+	`, t.Name, t.OrganizationID, t.CreatedBy).Scan(&t.ID, &t.CreatedAt)
+}
+
+func (t *Team) getTeam() error {
+	return app.DB.QueryRow(`
+	SELECT name, COALESCE(organization_id::text, ''), created_by, created_at
+	FROM teams WHERE id::text=$1 AND deleted_at IS NULL
+	`, t.ID).Scan(&t.Name, &t.OrganizationID, &t.CreatedBy, &t.CreatedAt)
+}
+
+func (t *Team) updateTeam() error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	_, err := app.DB.Exec(`
+	UPDATE teams SET name=$1, updated_at=NOW() WHERE id=$2 AND deleted_at IS NULL
+	`, t.Name, t.ID)
+	return err
+}
+
+// deleteTeam deletes the team and its grants, its members lose the access
+// they had through it.
+func (t *Team) deleteTeam() error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE teams SET deleted_at=NOW() WHERE id=$1
+	`, t.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+	DELETE FROM team_grants WHERE team_id=$1
+	`, t.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// canManageTeam tells whether the user manages the team, as its creator or
+// an admin of its organization.
+func (t *Team) canManageTeam(userID string) (bool, error) {
+	if t.CreatedBy == userID {
+		return true, nil
+	}
+	if len(t.OrganizationID) < 1 {
+		return false, nil
+	}
+	role, err := getOrganizationRole(t.OrganizationID, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return role == ORG_ROLE_OWNER || role == ORG_ROLE_ADMIN, nil
+}
+
+func (t *Team) isTeamMember(userID string) (bool, error) {
+	var isMember bool
+	err := app.DB.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM team_members WHERE team_id=$1 AND user_id=$2)
+	`, t.ID, userID).Scan(&isMember)
+	return isMember, err
+}
+
+// getTeams lists the teams the user created, is member of, or manages as
+// admin of their organization.
+func getTeams(userID string) ([]Team, error) {
+	rows, err := app.DB.Query(`
+	SELECT t.id, t.name, COALESCE(t.organization_id::text, ''), t.created_by, t.created_at
+	FROM teams t
+	WHERE t.deleted_at IS NULL AND (
+	  t.created_by=$1 OR
+	  EXISTS (SELECT 1 FROM team_members m WHERE m.team_id=t.id AND m.user_id=$1) OR
+	  EXISTS (
+	    SELECT 1 FROM organization_members om
+	    WHERE om.organization_id=t.organization_id AND om.user_id=$1 AND om.role IN ($2, $3)
+	  )
+	)
+	ORDER BY t.name
+	`, userID, ORG_ROLE_OWNER, ORG_ROLE_ADMIN)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	teams := []Team{}
+
+	for rows.Next() {
+		var t Team
+		if err := rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.OrganizationID,
+			&t.CreatedBy,
+			&t.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		teams = append(teams, t)
+	}
+
+	return teams, nil
+}
+
+func (t *Team) getTeamMembers() error {
+	rows, err := app.DB.Query(`
+	SELECT m.team_id, m.user_id, users.email_address, users.user_name, m.created_at
+	FROM team_members m, users
+	WHERE users.id::text=m.user_id AND m.team_id=$1
+	ORDER BY m.created_at
+	`, t.ID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	defer rows.Close()
+
+	t.Members = []TeamMember{}
+
+	for rows.Next() {
+		var m TeamMember
+		if err := rows.Scan(
+			&m.TeamID,
+			&m.UserID,
+			&m.EmailAddress,
+			&m.Username,
+			&m.CreatedAt,
+		); err != nil {
+			log.Println(err)
+			return err
+		}
+		t.Members = append(t.Members, m)
+	}
+
+	return nil
+}
+
+// addTeamMember adds an existing user, found by email address.
+func (m *TeamMember) addTeamMember() error {
+	user := User{EmailAddress: strings.TrimSpace(m.EmailAddress)}
+	if err := user.getUser(); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("user-not-found")
+		}
+		return err
+	}
+	m.UserID = user.ID
+	m.EmailAddress = user.EmailAddress
+	m.Username = user.UserName
+
+	res, err := app.DB.Exec(`
+	INSERT INTO team_members (team_id, user_id) VALUES ($1, $2)
+	ON CONFLICT DO NOTHING
+	`, m.TeamID, m.UserID)
+	if err != nil {
+		return err
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if added < 1 {
+		return errors.New("already-member")
+	}
+	return nil
+}
+
+func (m *TeamMember) removeTeamMember() error {
+	res, err := app.DB.Exec(`
+	DELETE FROM team_members WHERE team_id=$1 AND user_id=$2
+	`, m.TeamID, m.UserID)
+	if err != nil {
+		return err
+	}
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if removed < 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// setTeamGrant grants or replaces the access of the team on the project.
+func (g *TeamGrant) setTeamGrant() error {
+	if g.Access == "OWNER" {
+		return errors.New("invalid-access-level")
+	}
+	access := Acl{
+		ObjectID: g.ProjectID,
+		Access:   g.Access,
+		RoleID:   g.RoleID,
+	}
+	if err := access.validate(); err != nil {
+		return err
+	}
+	g.RoleID = access.RoleID
+	return app.DB.QueryRow(`
+	INSERT INTO team_grants (team_id, project_id, access, role_id)
+	VALUES ($1, $2, $3, NULLIF($4, ''))
+	ON CONFLICT (team_id, project_id) DO UPDATE SET access=$3, role_id=NULLIF($4, '')
+	RETURNING created_at
+	`, g.TeamID, g.ProjectID, g.Access, g.RoleID).Scan(&g.CreatedAt)
+}
+
+func (g *TeamGrant) dropTeamGrant() error {
+	_, err := app.DB.Exec(`
+	DELETE FROM team_grants WHERE team_id=$1 AND project_id=$2
+	`, g.TeamID, g.ProjectID)
+	return err
+}
+
+// getTeamAccess returns the access the user has on the project through its
+// teams, the highest when in several teams, or sql.ErrNoRows.
+func getTeamAccess(projectID, userID string) (string, string, error) {
+	var access, roleID string
+	err := app.DB.QueryRow(`
+	SELECT g.access, COALESCE(g.role_id, '')
+	FROM team_grants g, teams t, team_members m
+	WHERE g.team_id=t.id AND t.deleted_at IS NULL AND m.team_id=t.id
+	AND g.project_id=$1 AND m.user_id=$2
+	ORDER BY CASE g.access WHEN 'MODIFY' THEN 0 WHEN $3 THEN 1 ELSE 2 END, g.created_at
+	LIMIT 1
+	`, projectID, userID, ACL_CUSTOM).Scan(&access, &roleID)
+	return access, roleID, err
+}
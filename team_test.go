@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTeamAccess(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"test project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+	team := createTestObject(t, testUserToken1, "/api/team", `{"name":"Mobile QA"}`)
+	teamId := fmt.Sprintf("%s", team["id"])
+
+	member := createTestObject(t, testUserToken1, "/api/team-member/"+teamId, `{"emailAddress":"masepindrayana@gmail.com"}`)
+	memberId := fmt.Sprintf("%s", member["userId"])
+
+	req, _ := http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response := executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Members see the team, without managing it
+	req, _ = http.NewRequest("GET", "/api/team/"+teamId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), memberId)
+
+	req, _ = http.NewRequest("PUT", "/api/team/"+teamId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Ownership is not granted to teams
+	req, _ = http.NewRequest("PUT", "/api/team-access/"+projectId+"/"+teamId, bytes.NewBuffer([]byte(`{"access":"OWNER"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/team-access/"+projectId+"/"+teamId, bytes.NewBuffer([]byte(`{"access":"MODIFY"}`)))
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Contains(t, response.Body.String(), projectId)
+
+	// The source of each access is listed
+	req, _ = http.NewRequest("GET", "/api/collaborators/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var collaborators map[string][]map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &collaborators)
+	assert.Equal(t, 2, len(collaborators["data"]))
+	sources := map[string]string{}
+	for _, c := range collaborators["data"] {
+		sources[fmt.Sprintf("%s", c["id"])] = fmt.Sprintf("%s", c["source"])
+		if c["source"] == COLLABORATOR_SOURCE_TEAM {
+			assert.Equal(t, teamId, c["teamId"])
+			assert.Equal(t, "Mobile QA", c["teamName"])
+			assert.Equal(t, "MODIFY", c["access"])
+		}
+	}
+	assert.Equal(t, COLLABORATOR_SOURCE_TEAM, sources[memberId])
+
+	// Leaving the team removes the access
+	req, _ = http.NewRequest("DELETE", "/api/team-member/"+teamId+"/"+memberId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/team/"+teamId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/team/"+teamId, nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+}
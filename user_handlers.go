@@ -23,6 +23,9 @@ func (app *App) getUser(w http.ResponseWriter, r *http.Request) {
 			respondError(w, http.StatusBadRequest, "invalid-id")
 			return
 		}
+		if !authorize(w, r, id, PERMISSION_VIEW) {
+			return
+		}
 
 		u := User{}
 		u.ID = id
@@ -136,6 +139,10 @@ func (app *App) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_EDIT) {
+		return
+	}
+
 	var p User
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&p); err != nil {
@@ -165,6 +172,10 @@ func (app *App) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, id, PERMISSION_DELETE) {
+		return
+	}
+
 	p := User{ID: id}
 	if err := p.deleteUser(); err != nil {
 		log.Println(err)
@@ -174,3 +185,38 @@ func (app *App) deleteUser(w http.ResponseWriter, r *http.Request) {
 
 	respond(w, http.StatusOK, map[string]string{"result": "success"})
 }
+
+// logout revokes the token of the current request.
+func (app *App) logout(w http.ResponseWriter, r *http.Request) {
+	t := Token{Key: hashToken(getBearerToken(r))}
+	if err := t.revokeToken(); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
+
+func (app *App) revokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		respondError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	vars := mux.Vars(r)
+	userId := vars["userId"]
+	_, err := uuidParser.Parse(userId)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "invalid-id")
+		return
+	}
+
+	if err = revokeUserTokens(userId); err != nil {
+		log.Println(err)
+		respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	respond(w, http.StatusOK, map[string]string{"result": "success"})
+}
@@ -233,3 +233,98 @@ func TestUserAdminDelete(t *testing.T) {
 	response = executeRequest(req)
 	assert.Equal(t, http.StatusNotFound, response.Code)
 }
+
+func TestUserAdminImpersonation(t *testing.T) {
+	app.MigrateClean()
+	defer app.DB.Close()
+
+	project := createTestObject(t, testUserToken1, "/api/project", `{"name":"customer project"}`)
+	projectId := fmt.Sprintf("%s", project["id"])
+
+	req, _ := http.NewRequest("GET", "/api/user", nil)
+	req.Header.Set("Authorization", testUserToken1)
+	response := executeRequest(req)
+	var customer map[string]interface{}
+	json.Unmarshal(response.Body.Bytes(), &customer)
+	userId := fmt.Sprintf("%s", customer["id"])
+
+	payload := []byte(`{"userId":"` + userId + `","reason":"ticket #42","minutes":15}`)
+	req, _ = http.NewRequest("POST", "/api/admin/impersonation", bytes.NewBuffer(payload))
+	req.Header.Set("Authorization", testUserToken2)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	impersonation := createTestObject(t, testAdminToken1, "/api/admin/impersonation", string(payload))
+	impersonationId := fmt.Sprintf("%s", impersonation["id"])
+
+	// The admin sees the projects of the user
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", impersonationId)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Contains(t, response.Body.String(), "customer project")
+
+	// With the ACL of the user
+	req, _ = http.NewRequest("GET", "/api/admin/audit-logs", nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", impersonationId)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// Destructive methods are blocked, anything but reading
+	req, _ = http.NewRequest("PUT", "/api/project/"+projectId, bytes.NewBuffer([]byte(`{"name":"renamed"}`)))
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", impersonationId)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("DELETE", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", impersonationId)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "impersonation-read-only")
+
+	// Only the admin who started it can use it
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testUserToken2)
+	req.Header.Set("X-Impersonate", impersonationId)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/admin/audit-logs?impersonationId="+impersonationId, nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+	var logs []AuditLog
+	json.Unmarshal(response.Body.Bytes(), &logs)
+	assert.Equal(t, 4, len(logs))
+	assert.Equal(t, "DELETE", logs[0].Method)
+	assert.Equal(t, http.StatusForbidden, logs[0].Status)
+	assert.Equal(t, userId, logs[0].UserID)
+	assert.Equal(t, "PUT", logs[1].Method)
+	assert.Equal(t, "/api/projects", logs[3].Path)
+	assert.Equal(t, http.StatusOK, logs[3].Status)
+
+	req, _ = http.NewRequest("DELETE", "/api/admin/impersonation/"+impersonationId, nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	req, _ = http.NewRequest("GET", "/api/projects", nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", impersonationId)
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Contains(t, response.Body.String(), "impersonation-ended")
+
+	// Unless allowed when starting the impersonation
+	impersonation = createTestObject(t, testAdminToken1, "/api/admin/impersonation",
+		`{"userId":"`+userId+`","reason":"ticket #43","allowDestructive":true}`)
+	req, _ = http.NewRequest("DELETE", "/api/project/"+projectId, nil)
+	req.Header.Set("Authorization", testAdminToken1)
+	req.Header.Set("X-Impersonate", fmt.Sprintf("%s", impersonation["id"]))
+	response = executeRequest(req)
+	assert.Equal(t, http.StatusOK, response.Code)
+}